@@ -0,0 +1,109 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"net/http"
+)
+
+// listAPIKeys returns the authenticated user's API keys, without the keys themselves.
+func (app *application) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := app.DB.UserAPIKeys(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if keys == nil {
+		keys = []*models.APIKey{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, keys)
+}
+
+// createAPIKey creates an API key for the authenticated user. The response is the only time
+// the key is shown. The key can reach admin routes only if this session passed 2FA, so a
+// stolen password alone cannot mint an admin key.
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = models.ValidateAPIKey(requestPayload.Name, requestPayload.Scopes)
+	if err != nil {
+		app.errorJSON(w, repository.Validation("invalid_api_key_request", err.Error()))
+		return
+	}
+
+	plainText, key, err := models.NewAPIKey(user.ID, requestPayload.Name, requestPayload.Scopes)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	claims, _ := app.contextGetClaims(r)
+	key.MFA = claims != nil && claims.MFA
+
+	key.ID, err = app.DB.InsertAPIKey(r.Context(), key)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		*models.APIKey
+		Key string `json:"key"`
+	}{
+		APIKey: &key,
+		Key:    plainText,
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// deleteAPIKey revokes one of the authenticated user's API keys.
+func (app *application) deleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.DB.DeleteAPIKey(r.Context(), user.ID, id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "api key revoked",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"backend/internal/models"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// createTestAPIKey creates an API key with scopes through POST /me/api-keys, in the session of
+// the access token, and returns the key.
+func createTestAPIKey(t *testing.T, app *application, token string, scopes ...string) string {
+	t.Helper()
+
+	payload := map[string]interface{}{"name": "test", "scopes": scopes}
+	rr := doRequest(t, app, http.MethodPost, "/me/api-keys", payload, bearer(token))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("creating an api key: got %d, body %s", rr.Code, rr.Body)
+	}
+
+	var resp struct {
+		Key string `json:"key"`
+	}
+	decodeBody(t, rr, &resp)
+
+	return resp.Key
+}
+
+func apiKey(key string) http.Header {
+	return http.Header{"X-Api-Key": {key}}
+}
+
+// enableTestTOTP turns 2FA on for user, as enrolling and verifying a code does.
+func enableTestTOTP(t *testing.T, app *application, user *models.User) {
+	t.Helper()
+
+	err := app.DB.EnrollUserTOTP(context.Background(), user.ID, "JBSWY3DPEHPK3PXP", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.DB.EnableUserTOTP(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.TOTPEnabled = true
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	app := newTestApp(t)
+	editor := addTestUser(t, app, "editor@example.com", models.RoleEditor)
+	viewer := addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+
+	editorToken := loginTokens(t, app, editor).Token
+	readKey := createTestAPIKey(t, app, editorToken, models.ScopeMoviesRead)
+	writeKey := createTestAPIKey(t, app, editorToken, models.ScopeMoviesWrite)
+	bothKey := createTestAPIKey(t, app, editorToken, models.ScopeMoviesRead, models.ScopeMoviesWrite)
+	viewerKey := createTestAPIKey(t, app, loginTokens(t, app, viewer).Token, models.ScopeMoviesRead, models.ScopeMoviesWrite)
+
+	revoked := createTestAPIKey(t, app, editorToken, models.ScopeMoviesRead)
+	keys, err := app.DB.UserAPIKeys(context.Background(), editor.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := doRequest(t, app, http.MethodDelete, "/me/api-keys/"+strconv.Itoa(keys[len(keys)-1].ID), nil, bearer(editorToken))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("revoking: got %d, body %s", rr.Code, rr.Body)
+	}
+
+	edit := map[string]interface{}{
+		"id":           1,
+		"title":        "Highlander",
+		"release_date": "1986-03-07T00:00:00Z",
+		"runtime":      116,
+		"mpaa_rating":  "R",
+		"description":  "There can be only one.",
+		"genres_array": []int{5},
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		key    string
+		status int
+		code   string
+	}{
+		{"read with the read scope", http.MethodGet, "/admin/movies/1", nil, readKey, http.StatusOK, ""},
+		{"read with both scopes", http.MethodGet, "/admin/movies", nil, bothKey, http.StatusOK, ""},
+		{"read without the read scope", http.MethodGet, "/admin/movies/1", nil, writeKey, http.StatusForbidden, "insufficient_scope"},
+		{"write without the write scope", http.MethodPatch, "/admin/movies/1", edit, readKey, http.StatusForbidden, "insufficient_scope"},
+		{"write with the write scope", http.MethodPatch, "/admin/movies/1", edit, writeKey, http.StatusAccepted, ""},
+		{"scope beyond the role", http.MethodPatch, "/admin/movies/1", edit, viewerKey, http.StatusForbidden, "insufficient_role"},
+		{"delete as an editor", http.MethodDelete, "/admin/movies/1", nil, bothKey, http.StatusForbidden, "insufficient_role"},
+		{"account management", http.MethodGet, "/me/", nil, bothKey, http.StatusForbidden, "session_required"},
+		{"creating more keys", http.MethodPost, "/me/api-keys", map[string]interface{}{"name": "x", "scopes": []string{"movies:read"}}, bothKey, http.StatusForbidden, "session_required"},
+		{"unknown key", http.MethodGet, "/admin/movies/1", nil, "gm_0123456789", http.StatusUnauthorized, "invalid_api_key"},
+		{"revoked key", http.MethodGet, "/admin/movies/1", nil, revoked, http.StatusUnauthorized, "invalid_api_key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, app, tt.method, tt.path, tt.body, apiKey(tt.key))
+			if tt.code == "" {
+				if rr.Code != tt.status {
+					t.Errorf("status: got %d, want %d (body %s)", rr.Code, tt.status, rr.Body)
+				}
+				return
+			}
+			checkProblem(t, rr, tt.status, tt.code)
+		})
+	}
+}
+
+func TestAdminAPIKeyNeedsMFASession(t *testing.T) {
+	app := newTestApp(t)
+	admin := addTestUser(t, app, "second-admin@example.com", models.RoleAdmin)
+
+	// created before the admin enrolled in 2FA
+	unverifiedKey := createTestAPIKey(t, app, loginTokens(t, app, admin).Token, models.ScopeMoviesRead)
+
+	enableTestTOTP(t, app, admin)
+	verifiedKey := createTestAPIKey(t, app, loginTokens(t, app, admin).Token, models.ScopeMoviesRead)
+
+	rr := doRequest(t, app, http.MethodGet, "/admin/movies/1", nil, apiKey(unverifiedKey))
+	checkProblem(t, rr, http.StatusForbidden, "mfa_required")
+
+	rr = doRequest(t, app, http.MethodGet, "/admin/movies/1", nil, apiKey(verifiedKey))
+	if rr.Code != http.StatusOK {
+		t.Errorf("key of a verified session: got %d, body %s", rr.Code, rr.Body)
+	}
+
+	// turning 2FA off takes admin access away from every key
+	err := app.DB.DisableUserTOTP(context.Background(), admin.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = doRequest(t, app, http.MethodGet, "/admin/movies/1", nil, apiKey(verifiedKey))
+	checkProblem(t, rr, http.StatusForbidden, "mfa_required")
+}
+
+func TestCreateAPIKeyValidation(t *testing.T) {
+	app := newTestApp(t)
+	user := addTestUser(t, app, "editor@example.com", models.RoleEditor)
+	auth := bearer(loginTokens(t, app, user).Token)
+
+	scopes := []string{models.ScopeMoviesRead}
+
+	tests := []struct {
+		name    string
+		payload map[string]interface{}
+		status  int
+	}{
+		{"longest name", map[string]interface{}{"name": strings.Repeat("é", models.APIKeyNameMaxLength), "scopes": scopes}, http.StatusCreated},
+		{"padded longest name", map[string]interface{}{"name": " " + strings.Repeat("k", models.APIKeyNameMaxLength) + " ", "scopes": scopes}, http.StatusCreated},
+		{"name too long", map[string]interface{}{"name": strings.Repeat("k", models.APIKeyNameMaxLength+1), "scopes": scopes}, http.StatusBadRequest},
+		{"blank name", map[string]interface{}{"name": "  ", "scopes": scopes}, http.StatusBadRequest},
+		{"no scopes", map[string]interface{}{"name": "ci"}, http.StatusBadRequest},
+		{"unknown scope", map[string]interface{}{"name": "ci", "scopes": []string{"users:write"}}, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, app, http.MethodPost, "/me/api-keys", tt.payload, auth)
+			if tt.status == http.StatusCreated {
+				if rr.Code != tt.status {
+					t.Errorf("got %d, body %s", rr.Code, rr.Body)
+				}
+				return
+			}
+			checkProblem(t, rr, tt.status, "invalid_api_key_request")
+		})
+	}
+}
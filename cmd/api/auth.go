@@ -1,6 +1,9 @@
 package main
 
 import (
+	"backend/internal/models"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
@@ -10,12 +13,37 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 )
 
+// Values of the token_use claim, which keeps access and refresh tokens from standing in for
+// each other.
+const (
+	tokenUseAccess = "access"
+	tokenUseRefresh = "refresh"
+	tokenUseChallenge = "2fa_challenge"
+	tokenUseOIDCState = "oidc_state"
+)
+
+// Errors returned by ValidateToken. authRequired turns them into WWW-Authenticate challenges.
+var (
+	ErrNoToken = errors.New("no token")
+	ErrInvalidAuthHeader = errors.New("invalid auth header")
+	ErrTokenMalformed = errors.New("token is malformed")
+	ErrTokenSignature = errors.New("token signature is invalid")
+	ErrTokenExpired = errors.New("token is expired")
+	ErrTokenNotValidYet = errors.New("token is not valid yet")
+	ErrTokenAudience = errors.New("token has an invalid audience")
+	ErrTokenIssuer = errors.New("token has an invalid issuer")
+	ErrTokenUse = errors.New("token is not valid for this use")
+)
+
 type Auth struct {
 	Issuer string
 	Audience string
-	Secret string
+	Secret string // HS256 secret, only used when Keys is empty
+	Keys []SigningKey // the first key signs new tokens; every key verifies
 	TokenExpiry time.Duration
 	RefreshExpiry time.Duration
+	ChallengeExpiry time.Duration // lifetime of the token between password and 2FA code
+	Leeway time.Duration // allowed clock skew when checking exp, nbf and iat
 	CookieDomain string
 	CookiePath string
 	CookieName string
@@ -25,20 +53,41 @@ type jwtUser struct {
 	ID int `json:"id"`
 	FirstName string `json:"first_name"`
 	LastName string `json:"last_name"`
+	Role string `json:"role"`
+	MFA bool `json:"mfa"`
 }
 
 type TokenPairs struct {
 	Token string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
+	// TwoFactorEnrollmentRequired tells admins without 2FA that admin routes stay closed
+	// until they enroll
+	TwoFactorEnrollmentRequired bool `json:"two_factor_enrollment_required,omitempty"`
+
+	// the server-side record of RefreshToken, to be stored before the pair is handed out
+	refresh models.RefreshToken
 }
 
 type Claims struct {
+	Role string `json:"role"`
+	TokenUse string `json:"token_use"`
+	MFA bool `json:"mfa,omitempty"` // the user has 2FA enabled, so the session passed it
+
+	// set in OIDC state tokens only
+	Nonce string `json:"nonce,omitempty"`
+	PKCEVerifier string `json:"pkce_verifier,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
+// GenerateTokenPair creates an access token and a refresh token for user. The refresh token
+// belongs to familyID; pass an empty familyID to start a new family on login.
+func (j *Auth) GenerateTokenPair(user *jwtUser, familyID string) (TokenPairs, error) {
+	if familyID == "" {
+		familyID = randomID()
+	}
+
 	// Create a token
-	token := jwt.New(jwt.SigningMethodHS256)
+	token := j.newToken()
 
 	// Set the claims
 	claims := token.Claims.(jwt.MapClaims) //token.Claims.(jwt.MapClaims) : token.Claims을 jwt.MapClaims로 Casting
@@ -47,28 +96,39 @@ func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
 	claims["aud"] = j.Audience //Audience
 	claims["iss"] = j.Issuer // issuer
 	claims["iat"] = time.Now().UTC().Unix() //issued at
+	claims["nbf"] = time.Now().UTC().Unix() // not before
 	claims["typ"] = "JWT"// type
+	claims["token_use"] = tokenUseAccess
+	claims["role"] = user.Role // read from the database on every login and refresh
+	claims["mfa"] = user.MFA
 
 	// Set the expiry for JWT
 	claims["exp"] = time.Now().UTC().Add(j.TokenExpiry).Unix()// expiry
 
 	// Create a signed token
-	signedAccessToken, err := token.SignedString([]byte(j.Secret))
+	signedAccessToken, err := token.SignedString(j.signingKey())
 	if err != nil {
 		return TokenPairs{}, err
 	}
 
 	// Create a refresh token and set claims
-	refreshToken := jwt.New(jwt.SigningMethodHS256)
+	refreshToken := j.newToken()
 	refreshTokenClaims := refreshToken.Claims.(jwt.MapClaims)
 	refreshTokenClaims["sub"] = fmt.Sprint(user.ID)
+	refreshTokenClaims["aud"] = j.Audience
+	refreshTokenClaims["iss"] = j.Issuer
 	refreshTokenClaims["iat"] = time.Now().UTC().Unix()
+	refreshTokenClaims["nbf"] = time.Now().UTC().Unix()
+	refreshTokenClaims["token_use"] = tokenUseRefresh
+	refreshTokenID := randomID()
+	refreshTokenClaims["jti"] = refreshTokenID // key of the server-side record
 
 	// Set the expiry for the refresh token
-	refreshTokenClaims["exp"] = time.Now().UTC().Add(j.RefreshExpiry).Unix()
+	refreshExpiresAt := time.Now().UTC().Add(j.RefreshExpiry)
+	refreshTokenClaims["exp"] = refreshExpiresAt.Unix()
 
 	// Create a signed refresh token
-	signedRefreshToken, err := refreshToken.SignedString([]byte(j.Secret))
+	signedRefreshToken, err := refreshToken.SignedString(j.signingKey())
 	if err != nil {
 		return TokenPairs{}, err
 	}
@@ -77,12 +137,57 @@ func (j *Auth) GenerateTokenPair(user *jwtUser) (TokenPairs, error) {
 	var tokenPairs = TokenPairs{
 		Token: signedAccessToken,
 		RefreshToken:  signedRefreshToken,
+		refresh: models.RefreshToken{
+			ID: refreshTokenID,
+			UserID: user.ID,
+			FamilyID: familyID,
+			ExpiresAt: refreshExpiresAt,
+			CreatedAt: time.Now().UTC(),
+		},
 	}
 
 	// Return TokenPairs
 	return tokenPairs, nil
 }
 
+// GenerateChallengeToken creates the short-lived token authenticate hands out instead of a
+// token pair when the user has 2FA enabled. It proves the password was checked, and is
+// exchanged for a token pair together with a TOTP or recovery code.
+func (j *Auth) GenerateChallengeToken(userID int) (string, error) {
+	token := j.newToken()
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["sub"] = fmt.Sprint(userID)
+	claims["aud"] = j.Audience
+	claims["iss"] = j.Issuer
+	claims["iat"] = time.Now().UTC().Unix()
+	claims["nbf"] = time.Now().UTC().Unix()
+	claims["exp"] = time.Now().UTC().Add(j.ChallengeExpiry).Unix()
+	claims["token_use"] = tokenUseChallenge
+
+	return token.SignedString(j.signingKey())
+}
+
+// GenerateOIDCStateToken creates the token that carries an OIDC login from /auth/oidc/login
+// to the callback in a cookie: the state (as jti), the nonce expected in the ID token and
+// the PKCE code verifier.
+func (j *Auth) GenerateOIDCStateToken(state, nonce, verifier string, expiry time.Duration) (string, error) {
+	token := j.newToken()
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["jti"] = state
+	claims["aud"] = j.Audience
+	claims["iss"] = j.Issuer
+	claims["iat"] = time.Now().UTC().Unix()
+	claims["nbf"] = time.Now().UTC().Unix()
+	claims["exp"] = time.Now().UTC().Add(expiry).Unix()
+	claims["nonce"] = nonce
+	claims["pkce_verifier"] = verifier
+	claims["token_use"] = tokenUseOIDCState
+
+	return token.SignedString(j.signingKey())
+}
+
 // GetRefreshCookie returns a cookie containing the refresh token. Note that the cookie is http only, secure,
 func (j *Auth) GetRefreshCookie(refreshToken string) *http.Cookie {
 	return &http.Cookie{
@@ -122,46 +227,133 @@ func (j *Auth) GetTokenFromHeaderAndVerify(w http.ResponseWriter, r *http.Reques
 
 	// sanity check
 	if authHeader == "" {
-		return "", nil, errors.New("no auth header")
+		return "", nil, ErrNoToken
 	}
 
 	// split the header on spaces
 	headerParts := strings.Split(authHeader, " ")
 	if len(headerParts) != 2{
 		// Authorization header has to be formed as 'Bearer afdsafasfddsx~~'
-		return "", nil, errors.New("Invalid auth header")
+		return "", nil, ErrInvalidAuthHeader
 	}
 
 	// check to see if we have the word Bearer
 	if headerParts[0] != "Bearer" {
 		// Authorization header has to be formed as 'Bearer afdsafasfddsx~~'
-		return "", nil, errors.New("Invalid auth header")
+		return "", nil, ErrInvalidAuthHeader
 	}
 
 	token := headerParts[1]
 
-	// declare an empty claims
+	claims, err := j.ValidateToken(token, tokenUseAccess)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, claims, nil
+}
+
+// ParseRefreshToken validates a refresh token and returns its claims.
+func (j *Auth) ParseRefreshToken(refreshToken string) (*Claims, error) {
+	claims, err := j.ValidateToken(refreshToken, tokenUseRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ID == "" {
+		return nil, ErrTokenMalformed
+	}
+
+	return claims, nil
+}
+
+// ValidateToken checks the signature, audience, issuer, lifetime and token_use claim of a
+// token and returns its claims. The errors are the Err* values above, so callers can tell
+// why a token was rejected without matching on messages.
+func (j *Auth) ValidateToken(token string, use string) (*Claims, error) {
 	claims := &Claims{}
 
-	// parse the token
-	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+	// the claims are checked below, with leeway and our own errors
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+
+	_, err := parser.ParseWithClaims(token, claims, j.verificationKey)
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenMalformed) {
+			return nil, ErrTokenMalformed
+		}
+		return nil, ErrTokenSignature
+	}
+
+	now := time.Now()
+
+	switch {
+	case !claims.VerifyAudience(j.Audience, true):
+		return nil, ErrTokenAudience
+	case !claims.VerifyIssuer(j.Issuer, true):
+		return nil, ErrTokenIssuer
+	case !claims.VerifyExpiresAt(now.Add(-j.Leeway), true):
+		return nil, ErrTokenExpired
+	case !claims.VerifyNotBefore(now.Add(j.Leeway), false), !claims.VerifyIssuedAt(now.Add(j.Leeway), false):
+		return nil, ErrTokenNotValidYet
+	case claims.TokenUse != use:
+		return nil, ErrTokenUse
+	}
+
+	return claims, nil
+}
+
+// newToken returns an unsigned token for the current signing key, with the key's id in the
+// kid header.
+func (j *Auth) newToken() *jwt.Token {
+	if len(j.Keys) == 0 {
+		return jwt.New(jwt.SigningMethodHS256)
+	}
+
+	token := jwt.New(j.Keys[0].Method)
+	token.Header["kid"] = j.Keys[0].ID
+	return token
+}
+
+// signingKey returns the key newToken's tokens are signed with.
+func (j *Auth) signingKey() interface{} {
+	if len(j.Keys) == 0 {
+		return []byte(j.Secret)
+	}
+	return j.Keys[0].PrivateKey
+}
+
+// verificationKey is the jwt.Keyfunc of every token we parse. It picks the public key named by
+// the kid header and only accepts the algorithm of that key, so a token cannot choose a weaker
+// algorithm or be verified with the wrong kind of key.
+func (j *Auth) verificationKey(token *jwt.Token) (interface{}, error) {
+	if len(j.Keys) == 0 {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			// if signing method is not expected one
 			return nil, fmt.Errorf("unexpected signing method : %v", token.Header["alg"])
 		}
 		return []byte(j.Secret), nil
-	})
+	}
 
-	if err != nil {
-		if strings.HasPrefix(err.Error(), "token is expired by"){
-			return "", nil, errors.New("expired token")
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range j.Keys {
+		if key.ID != kid {
+			continue
 		}
-		return "", nil, err
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method : %v", token.Header["alg"])
+		}
+		return key.PublicKey, nil
 	}
 
-	if claims.Issuer != j.Issuer {
-		return "", nil, errors.New("invalid issuer")
-	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
 
-	return token, claims, nil
+// randomID returns 128 random bits, hex encoded.
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand only fails if the OS cannot supply randomness
+		panic(err)
+	}
+	return hex.EncodeToString(b)
 }
\ No newline at end of file
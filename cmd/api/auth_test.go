@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestValidateToken(t *testing.T) {
+	auth := newTestApp(t).auth
+	now := time.Now()
+
+	// valid returns the claims of an access token that passes every check
+	valid := func() *Claims {
+		return &Claims{
+			Role:     "viewer",
+			TokenUse: tokenUseAccess,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   "1",
+				Audience:  jwt.ClaimStrings{auth.Audience},
+				Issuer:    auth.Issuer,
+				IssuedAt:  jwt.NewNumericDate(now),
+				NotBefore: jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(auth.TokenExpiry)),
+			},
+		}
+	}
+	sign := func(claims *Claims) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(auth.Secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	with := func(change func(c *Claims)) string {
+		claims := valid()
+		change(claims)
+		return sign(claims)
+	}
+
+	// half the leeway is clock skew we accept, twice the leeway is not
+	within, beyond := auth.Leeway/2, 2*auth.Leeway
+
+	tests := []struct {
+		name  string
+		token string
+		use   string
+		err   error
+	}{
+		{"valid", sign(valid()), tokenUseAccess, nil},
+		{"another audience among ours", with(func(c *Claims) { c.Audience = jwt.ClaimStrings{"other", auth.Audience} }), tokenUseAccess, nil},
+		{"expired within the leeway", with(func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-within)) }), tokenUseAccess, nil},
+		{"issued slightly in the future", with(func(c *Claims) { c.IssuedAt = jwt.NewNumericDate(now.Add(within)) }), tokenUseAccess, nil},
+		{"valid slightly in the future", with(func(c *Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(within)) }), tokenUseAccess, nil},
+
+		{"wrong audience", with(func(c *Claims) { c.Audience = jwt.ClaimStrings{"other"} }), tokenUseAccess, ErrTokenAudience},
+		{"no audience", with(func(c *Claims) { c.Audience = nil }), tokenUseAccess, ErrTokenAudience},
+		{"wrong issuer", with(func(c *Claims) { c.Issuer = "other" }), tokenUseAccess, ErrTokenIssuer},
+		{"no issuer", with(func(c *Claims) { c.Issuer = "" }), tokenUseAccess, ErrTokenIssuer},
+		{"expired beyond the leeway", with(func(c *Claims) { c.ExpiresAt = jwt.NewNumericDate(now.Add(-beyond)) }), tokenUseAccess, ErrTokenExpired},
+		{"no expiry", with(func(c *Claims) { c.ExpiresAt = nil }), tokenUseAccess, ErrTokenExpired},
+		{"not valid yet", with(func(c *Claims) { c.NotBefore = jwt.NewNumericDate(now.Add(beyond)) }), tokenUseAccess, ErrTokenNotValidYet},
+		{"issued in the future", with(func(c *Claims) { c.IssuedAt = jwt.NewNumericDate(now.Add(beyond)) }), tokenUseAccess, ErrTokenNotValidYet},
+		{"refresh token as access token", with(func(c *Claims) { c.TokenUse = tokenUseRefresh }), tokenUseAccess, ErrTokenUse},
+		{"access token as refresh token", sign(valid()), tokenUseRefresh, ErrTokenUse},
+		{"challenge token as access token", with(func(c *Claims) { c.TokenUse = tokenUseChallenge }), tokenUseAccess, ErrTokenUse},
+		{"no token use", with(func(c *Claims) { c.TokenUse = "" }), tokenUseAccess, ErrTokenUse},
+
+		{"other secret", func() string {
+			signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, valid()).SignedString([]byte("another secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signed
+		}(), tokenUseAccess, ErrTokenSignature},
+		{"changed payload", func() string {
+			parts := strings.Split(sign(valid()), ".")
+			other := strings.Split(with(func(c *Claims) { c.Role = "admin" }), ".")
+			return parts[0] + "." + other[1] + "." + parts[2]
+		}(), tokenUseAccess, ErrTokenSignature},
+		{"alg none", func() string {
+			signed, err := jwt.NewWithClaims(jwt.SigningMethodNone, valid()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return signed
+		}(), tokenUseAccess, ErrTokenSignature},
+		{"not a token", "not.a.token", tokenUseAccess, ErrTokenMalformed},
+		{"empty", "", tokenUseAccess, ErrTokenMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := auth.ValidateToken(tt.token, tt.use)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if err == nil && claims.Subject != "1" {
+				t.Errorf("subject: got %q, want 1", claims.Subject)
+			}
+		})
+	}
+}
+
+func TestGetTokenFromHeaderAndVerify(t *testing.T) {
+	auth := newTestApp(t).auth
+
+	tokens, err := auth.GenerateTokenPair(&jwtUser{ID: 1, Role: "viewer"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		err    error
+	}{
+		{"bearer token", "Bearer " + tokens.Token, nil},
+		{"no header", "", ErrNoToken},
+		{"other scheme", "Basic " + tokens.Token, ErrInvalidAuthHeader},
+		{"no scheme", tokens.Token, ErrInvalidAuthHeader},
+		{"extra part", "Bearer " + tokens.Token + " x", ErrInvalidAuthHeader},
+		{"refresh token", "Bearer " + tokens.RefreshToken, ErrTokenUse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			rr := httptest.NewRecorder()
+			_, _, err := auth.GetTokenFromHeaderAndVerify(rr, req)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+			if rr.Header().Get("Vary") != "Authorization" {
+				t.Errorf("Vary: got %q", rr.Header().Get("Vary"))
+			}
+		})
+	}
+}
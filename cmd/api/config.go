@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+const configUsage = `usage:
+  config print           show the configuration with the source of each value, secrets redacted
+  config check           validate the configuration`
+
+// runConfig executes a `config` subcommand. args are the arguments after "config".
+func (app *application) runConfig(args []string) error {
+	if len(args) != 1 {
+		return errors.New(configUsage)
+	}
+
+	switch args[0] {
+	case "print":
+		return app.config.Print(os.Stdout)
+	case "check":
+		return app.config.Validate()
+	default:
+		return errors.New(configUsage)
+	}
+}
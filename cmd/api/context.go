@@ -0,0 +1,53 @@
+package main
+
+import (
+	"backend/internal/logging"
+	"backend/internal/models"
+	"context"
+	"net/http"
+)
+
+// contextKey is the type of the request context keys set by this package, so they cannot
+// collide with keys of other packages.
+type contextKey string
+
+const (
+	claimsContextKey = contextKey("claims")
+	userIDContextKey = contextKey("userID")
+	apiKeyContextKey = contextKey("apiKey")
+)
+
+// contextSetAuth returns a copy of r carrying the verified claims of its access token and
+// the id of the user they belong to. The id is also recorded for the access log.
+func (app *application) contextSetAuth(r *http.Request, claims *Claims, userID int) *http.Request {
+	logging.SetUserID(r.Context(), userID)
+
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	ctx = context.WithValue(ctx, userIDContextKey, userID)
+	return r.WithContext(ctx)
+}
+
+// contextGetClaims returns the verified access token claims stored by authRequired.
+func (app *application) contextGetClaims(r *http.Request) (*Claims, bool) {
+	claims, ok := r.Context().Value(claimsContextKey).(*Claims)
+	return claims, ok
+}
+
+// contextGetUserID returns the id of the authenticated user stored by authRequired.
+func (app *application) contextGetUserID(r *http.Request) (int, bool) {
+	id, ok := r.Context().Value(userIDContextKey).(int)
+	return id, ok
+}
+
+// contextSetAPIKey returns a copy of r recording that it was authenticated with key rather
+// than an access token.
+func (app *application) contextSetAPIKey(r *http.Request, key *models.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKey returns the API key the request was authenticated with, if any.
+func (app *application) contextGetAPIKey(r *http.Request) (*models.APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyContextKey).(*models.APIKey)
+	return key, ok
+}
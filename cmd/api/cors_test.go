@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	app := newTestApp(t)
+	app.config.CORS.AllowedOrigins = []string{"http://localhost:3000", "https://*.example.org"}
+	app.config.CORS.PublicAllowedOrigins = []string{"*"}
+
+	tests := []struct {
+		name    string
+		path    string
+		origin  string
+		method  string
+		headers string
+		// allowOrigin is the expected Access-Control-Allow-Origin; empty if the preflight
+		// is rejected
+		allowOrigin string
+		credentials bool
+	}{
+		{"allowed origin", "/admin/movies/1", "http://localhost:3000", http.MethodPatch, "Content-Type, Authorization", "http://localhost:3000", true},
+		{"allowed subdomain", "/logout", "https://app.example.org", http.MethodPost, "X-CSRF-Token", "https://app.example.org", true},
+		{"other origin", "/admin/movies/1", "https://evil.example.com", http.MethodPatch, "Content-Type", "", false},
+		{"lookalike origin", "/logout", "https://example.org.evil.com", http.MethodPost, "", "", false},
+		{"other scheme", "/logout", "https://localhost:3000", http.MethodPost, "", "", false},
+		{"method not allowed", "/admin/movies/1", "http://localhost:3000", "TRACE", "", "", false},
+		{"header not allowed", "/admin/movies/1", "http://localhost:3000", http.MethodPatch, "X-Custom", "", false},
+		{"public catalog from anywhere", "/movies/1", "https://evil.example.com", http.MethodGet, "Accept", "*", false},
+		{"public catalog without credentials headers", "/movies/1", "http://localhost:3000", http.MethodGet, "Authorization", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{
+				"Origin":                        {tt.origin},
+				"Access-Control-Request-Method": {tt.method},
+			}
+			if tt.headers != "" {
+				header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+
+			rr := doRequest(t, app, http.MethodOptions, tt.path, nil, header)
+			got := rr.Header()
+
+			if tt.allowOrigin == "" {
+				checkProblem(t, rr, http.StatusForbidden, "cors_rejected")
+				if v := got.Get("Access-Control-Allow-Origin"); v != "" {
+					t.Errorf("rejected preflight allows origin %q", v)
+				}
+				return
+			}
+
+			if rr.Code != http.StatusNoContent {
+				t.Fatalf("got %d, body %s", rr.Code, rr.Body)
+			}
+			if v := got.Get("Access-Control-Allow-Origin"); v != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin: got %q, want %q", v, tt.allowOrigin)
+			}
+			if v := got.Get("Access-Control-Allow-Credentials"); (v == "true") != tt.credentials {
+				t.Errorf("Access-Control-Allow-Credentials: got %q", v)
+			}
+			if tt.headers != "" && got.Get("Access-Control-Allow-Headers") != tt.headers {
+				t.Errorf("Access-Control-Allow-Headers: got %q, want %q", got.Get("Access-Control-Allow-Headers"), tt.headers)
+			}
+			if got.Get("Access-Control-Max-Age") == "" {
+				t.Error("Access-Control-Max-Age is missing")
+			}
+		})
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	app := newTestApp(t)
+
+	rr := doRequest(t, app, http.MethodGet, "/movies", nil, http.Header{"Origin": {"http://localhost:3000"}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d, body %s", rr.Code, rr.Body)
+	}
+	if v := rr.Header().Get("Access-Control-Allow-Origin"); v != "http://localhost:3000" {
+		t.Errorf("Access-Control-Allow-Origin: got %q", v)
+	}
+
+	rr = doRequest(t, app, http.MethodGet, "/movies", nil, http.Header{"Origin": {"https://evil.example.com"}})
+	checkProblem(t, rr, http.StatusForbidden, "cors_rejected")
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"database/sql"
-	"log"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
@@ -25,10 +24,10 @@ func openDB(dsn string) (*sql.DB, error){
 }
 
 func (app *application) connectToDB() (*sql.DB, error){
-	connection, err := openDB(app.DSN)
+	connection, err := openDB(app.config.DB.DSN)
 	if err != nil {
 		return nil, err
 	}
-	log.Println("Connected to Postgres!")
+	app.logger.Info("connected to Postgres")
 	return connection, nil
 }
\ No newline at end of file
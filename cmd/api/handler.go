@@ -2,21 +2,30 @@ package main
 
 import (
 	"backend/internal/graph"
+	"backend/internal/logging"
+	"backend/internal/metrics"
 	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/tracing"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
-
-	"github.com/go-chi/chi/v5"
-	"github.com/golang-jwt/jwt/v4"
 )
 
+// errInvalidCredentials does not tell whether the email or the password was wrong.
+var errInvalidCredentials = repository.Validation("invalid_credentials", "invalid credentials")
+
+// errUnauthorized is for sessions that are missing, invalid or revoked; it does not tell which.
+var errUnauthorized = repository.Unauthorized("unauthorized", "unauthorized")
+
 func (app *application) Home(w http.ResponseWriter, r *http.Request){
 	var payload = struct {
 		Status string `json:"status"`
@@ -25,20 +34,127 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request){
 	}{
 		Status : "active",
 		Message : "Go Movies up and running",
-		Version: "1.0.0",
+		Version: version,
 	}
 
 	_ = app.writeJSON(w, http.StatusOK, payload)
 }
 
 func (app *application) AllMovies(w http.ResponseWriter, r *http.Request){
-	movies, err := app.DB.AllMovies()
+	app.listMovies(w, r)
+}
+
+// listMovies writes one page of movies, selected by the query string:
+//
+//	page, page_size                  offset pagination (defaults 1 and 20)
+//	cursor                           keyset pagination, using next_cursor from a previous page
+//	sort                             title, release_date, runtime or created_at, "-" prefix for descending
+//	mpaa_rating, genre               one or more values, repeated or comma separated
+//	release_year_from, release_year_to, runtime_min, runtime_max
+func (app *application) listMovies(w http.ResponseWriter, r *http.Request) {
+	filter, err := app.readMovieFilter(r)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, movies)
+	movies, metadata, err := app.DB.ListMovies(r.Context(), filter)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if movies == nil {
+		movies = []*models.Movie{}
+	}
+
+	var payload = struct {
+		Movies []*models.Movie `json:"movies"`
+		Metadata models.PageMetadata `json:"metadata"`
+	}{
+		movies,
+		metadata,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// readMovieFilter builds a validated models.MovieFilter from the query string.
+func (app *application) readMovieFilter(r *http.Request) (models.MovieFilter, error) {
+	qs := r.URL.Query()
+
+	var filter models.MovieFilter
+	var err error
+
+	ints := []struct {
+		key string
+		dest *int
+	}{
+		{"page", &filter.Page},
+		{"page_size", &filter.PageSize},
+		{"release_year_from", &filter.ReleaseYearFrom},
+		{"release_year_to", &filter.ReleaseYearTo},
+		{"runtime_min", &filter.RunTimeMin},
+		{"runtime_max", &filter.RunTimeMax},
+	}
+	for _, i := range ints {
+		*i.dest, err = app.readIntQuery(qs, i.key, 0)
+		if err != nil {
+			return filter, err
+		}
+	}
+
+	filter.Sort = qs.Get("sort")
+	filter.Cursor = qs.Get("cursor")
+	filter.MPAARatings = app.readCSVQuery(qs, "mpaa_rating")
+
+	for _, g := range app.readCSVQuery(qs, "genre") {
+		id, err := strconv.Atoi(g)
+		if err != nil {
+			return filter, repository.Validation("invalid_filter", "genre must be a list of genre ids")
+		}
+		filter.GenreIDs = append(filter.GenreIDs, id)
+	}
+
+	err = filter.Validate()
+	if err != nil {
+		return filter, repository.Validation("invalid_filter", err.Error())
+	}
+
+	return filter, nil
+}
+
+// SearchMovies runs a full-text search over titles and descriptions: /movies/search?q=...&limit=...
+func (app *application) SearchMovies(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	query := strings.TrimSpace(qs.Get("q"))
+	if query == "" {
+		app.errorJSON(w, repository.Validation("invalid_query", "q must not be empty"))
+		return
+	}
+
+	limit, err := app.readIntQuery(qs, "limit", models.DefaultPageSize)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if limit < 1 || limit > models.MaxPageSize {
+		app.errorJSON(w, repository.Validation("invalid_query", fmt.Sprintf("limit must be between 1 and %d", models.MaxPageSize)))
+		return
+	}
+
+	results, err := app.DB.SearchMovies(r.Context(), query, limit)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	if results == nil {
+		results = []*models.MovieSearchResult{}
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, results)
 }
 
 func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
@@ -54,128 +170,274 @@ func (app *application) authenticate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// validate user against database
-	user, err := app.DB.GetUserByEmail(requestPayload.Email)
+	ip := app.clientIP(r)
+
+	// count the attempt up front, refusing it while the account or client is backed off or
+	// locked out
+	attempt, allowedAt, err := app.reserveLogin(r.Context(), requestPayload.Email, ip)
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid credentials"), http.StatusBadRequest)
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if attempt == nil {
+		app.metrics.CountLogin("password", "throttled")
+		app.tooManyLogins(w, allowedAt)
 		return
 	}
 
-	// check password
-	valid, err := user.PasswordMatches(requestPayload.Password)
-	if err != nil || !valid {
-		app.errorJSON(w, errors.New("Invalid credentials"), http.StatusBadRequest)
+	// validate user against database
+	user, err := app.DB.GetUserByEmail(r.Context(), requestPayload.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		app.releaseLogin(r.Context(), attempt)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
-	// create a jwt user
-	u := jwtUser{
-		ID:  user.ID,
-		FirstName: user.FirstName,
-		LastName: user.LastName,
+	// check password, taking as long for an unknown email as for a wrong password
+	valid := false
+	userID := 0
+	if user != nil {
+		userID = user.ID
+		valid, err = user.PasswordMatches(requestPayload.Password)
+		if err != nil {
+			valid = false
+		}
+	} else {
+		models.PasswordMatchesNoUser(requestPayload.Password)
+	}
+
+	if !valid {
+		app.metrics.CountLogin("password", "failure")
+		app.loginFailed(r.Context(), attempt, userID)
+		app.errorJSON(w, errInvalidCredentials)
+		return
+	}
+
+	// with 2FA the password alone is not enough: hand out a challenge for authenticate2FA
+	if user.TOTPEnabled {
+		// the backoff goes on until the code is accepted too
+		app.releaseLogin(r.Context(), attempt)
+
+		challenge, err := app.auth.GenerateChallengeToken(user.ID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		resp := struct {
+			TwoFactorRequired bool `json:"two_factor_required"`
+			ChallengeToken string `json:"challenge_token"`
+		}{
+			TwoFactorRequired: true,
+			ChallengeToken: challenge,
+		}
+
+		app.metrics.CountLogin("password", "challenge")
+		_ = app.writeJSON(w, http.StatusAccepted, resp)
+		return
 	}
 
-	// generate tokens
-	tokens, err := app.auth.GenerateTokenPair(&u)
+	app.loginSucceeded(r.Context(), attempt)
+
+	// generate tokens, starting a new refresh token family
+	tokens, err := app.issueTokens(r.Context(), user, "")
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
+	tokens.TwoFactorEnrollmentRequired = user.Role == models.RoleAdmin
+
 	//Setting refresh cookie to user browser
 	refreshCookie := app.auth.GetRefreshCookie(tokens.RefreshToken)
 	http.SetCookie(w, refreshCookie)
 
+	app.metrics.CountLogin("password", "success")
 	app.writeJSON(w, http.StatusAccepted, tokens)
 }
 
+// issueTokens generates a token pair for user and stores the refresh token, so that it can
+// be rotated and revoked later. familyID is empty on login and the family of the exchanged
+// token on refresh.
+func (app *application) issueTokens(ctx context.Context, user *models.User, familyID string) (TokenPairs, error) {
+	// create a jwt user
+	u := jwtUser{
+		ID:  user.ID,
+		FirstName: user.FirstName,
+		LastName: user.LastName,
+		Role: user.Role,
+		MFA: user.TOTPEnabled, // logins of 2FA users always pass authenticate2FA
+	}
+
+	tokens, err := app.auth.GenerateTokenPair(&u, familyID)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	err = app.DB.InsertRefreshToken(ctx, tokens.refresh)
+	if err != nil {
+		return TokenPairs{}, err
+	}
+
+	return tokens, nil
+}
+
+// refreshToken exchanges the refresh token cookie for a new token pair. Each refresh token
+// can be exchanged once: presenting one that was already used means it has leaked, so the
+// whole family, including the legitimate latest token, is revoked.
 func (app *application) refreshToken(w http.ResponseWriter, r *http.Request){
-	for _, cookie := range r.Cookies(){
-		if cookie.Name == app.auth.CookieName {
-			claims := &Claims{}
-			refreshToken := cookie.Value
-
-			//parse the token to get the claims
-			/*
-			 jwt.ParseWithClaims(tokenString, Claims, KeyFunction)
-			 토큰 검증을 위해 ParseWithClaims 함수 사용
-			 - tokenString : 검증할 토큰 문자열
-			 - Claims : Claims 구조체 포인터
-			 - KeyFunctions : 토큰 서명 검증전 검증 핸들러
-			*/
-			_, err := jwt.ParseWithClaims(refreshToken, claims, func(token *jwt.Token) (interface{}, error){
-				// if 잘못된 토큰 인 경우 {
-				// 	return nil, err //secret 제공 거부, 에러 리턴
-				// }
-				// if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				// 	return nil, errors.New("unexpected signing method")
-				// }
-				return []byte(app.JWTSecret), nil
-			})
-
-			if err != nil {
-				app.errorJSON(w, errors.New("unauthorized"), http.StatusUnauthorized)
-				return
-			}
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err != nil {
+		app.errorJSON(w, errUnauthorized)
+		return
+	}
 
-			// get the user id from the token claims
-			// Atoi : 숫자로 이루어진 문자열을 숫자로 변환
-			userID, err := strconv.Atoi(claims.Subject)
-			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
+	//parse the token to get the claims
+	claims, err := app.auth.ParseRefreshToken(cookie.Value)
+	if err != nil {
+		app.errorJSON(w, errUnauthorized)
+		return
+	}
 
-			user, err := app.DB.GetUserById(userID)
-			if err != nil {
-				app.errorJSON(w, errors.New("unknown user"), http.StatusUnauthorized)
-				return
-			}
+	// look up the server-side record
+	stored, err := app.DB.GetRefreshToken(r.Context(), claims.ID)
+	if err != nil && !errors.Is(err, repository.ErrRefreshTokenNotFound) {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if err != nil || stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		app.errorJSON(w, errUnauthorized)
+		return
+	}
 
-			u := jwtUser{
-				ID : user.ID,
-				FirstName:  user.FirstName,
-				LastName: user.LastName,
-			}
+	// get the user id from the token claims
+	// Atoi : 숫자로 이루어진 문자열을 숫자로 변환
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID != stored.UserID {
+		app.errorJSON(w, errUnknownUser)
+		return
+	}
 
-			tokenPairs, err := app.auth.GenerateTokenPair(&u)
-			if err != nil {
-				app.errorJSON(w, errors.New("error generating tokens"), http.StatusUnauthorized)
-				return
-			}
+	var tokenPairs TokenPairs
+	reused := false
+	userGone := false
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		fresh, err := repo.MarkRefreshTokenUsed(r.Context(), stored.ID)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			reused = true
+			return nil
+		}
+
+		user, err := repo.GetUserById(r.Context(), userID)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			userGone = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// read the user again so that role changes apply from this refresh on
+		u := jwtUser{
+			ID : user.ID,
+			FirstName:  user.FirstName,
+			LastName: user.LastName,
+			Role: user.Role,
+			MFA: user.TOTPEnabled,
+		}
 
-			http.SetCookie(w, app.auth.GetRefreshCookie(tokenPairs.RefreshToken))
-			app.writeJSON(w, http.StatusOK,tokenPairs)
+		tokenPairs, err = app.auth.GenerateTokenPair(&u, stored.FamilyID)
+		if err != nil {
+			return err
 		}
+
+		return repo.InsertRefreshToken(r.Context(), tokenPairs.refresh)
+	})
+	if err != nil {
+		// the token may be fine; a retry can succeed, so the client should not log out
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
+
+	if userGone {
+		app.errorJSON(w, errUnknownUser)
+		return
+	}
+
+	if reused {
+		// a used token came back: someone else holds a copy, so end the session everywhere it went
+		err = app.DB.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), "revoking a replayed refresh token family", "error", err)
+		}
+
+		http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+		app.errorJSON(w, errUnauthorized)
+		return
+	}
+
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokenPairs.RefreshToken))
+	app.writeJSON(w, http.StatusOK,tokenPairs)
 }
 
+// logout revokes the session of the refresh token cookie, if there is one, and deletes the cookie.
 func (app *application) logout(w http.ResponseWriter, r *http.Request){
+	cookie, err := r.Cookie(app.auth.CookieName)
+	if err == nil {
+		claims, err := app.auth.ParseRefreshToken(cookie.Value)
+		if err == nil {
+			stored, err := app.DB.GetRefreshToken(r.Context(), claims.ID)
+			if err == nil {
+				err = app.DB.RevokeRefreshTokenFamily(r.Context(), stored.FamilyID)
+			}
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				app.errorJSON(w, err, http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
 	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
 	w.WriteHeader(http.StatusAccepted)
 }
 
-func (app *application) MovieCatalog(w http.ResponseWriter, r *http.Request){
-	movies, err := app.DB.AllMovies()
+// logoutEverywhere revokes every refresh token of the authenticated user, ending all of
+// their sessions once the current access tokens expire.
+func (app *application) logoutEverywhere(w http.ResponseWriter, r *http.Request){
+	user, err := app.currentUser(r)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusUnauthorized)
 		return
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, movies)
+	err = app.DB.RevokeUserRefreshTokens(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (app *application) MovieCatalog(w http.ResponseWriter, r *http.Request){
+	app.listMovies(w, r)
 }
 
 func (app *application) GetMovie(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	movieID, err := strconv.Atoi(id)
+	movieID, err := app.readIDParam(r)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	movie, err := app.DB.OneMovie(movieID)
+	movie, err := app.DB.OneMovie(r.Context(), movieID)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -183,16 +445,15 @@ func (app *application) GetMovie(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) MovieForEdit(w http.ResponseWriter, r *http.Request) {
-	id := chi.URLParam(r, "id")
-	movieID, err := strconv.Atoi(id)
+	movieID, err := app.readIDParam(r)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	movie, genres, err := app.DB.OneMovieForEdit(movieID)
+	movie, genres, err := app.DB.OneMovieForEdit(r.Context(), movieID)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -209,9 +470,9 @@ func (app *application) MovieForEdit(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) AllGenres(w http.ResponseWriter, r *http.Request){
-	genres, err := app.DB.AllGenres()
+	genres, err := app.DB.AllGenres(r.Context())
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -228,21 +489,28 @@ func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request){
 	}
 
 	// try to get an image
-	movie = app.getPoster(movie)
+	movie = app.getPoster(r.Context(), movie)
 
 	movie.CreatedAt = time.Now()
 	movie.UpdatedAt = time.Now()
+
+	// record who made the change
+	userID, _ := app.contextGetUserID(r)
+	movie.CreatedBy = userID
+	movie.UpdatedBy = userID
 	
-	newID, err := app.DB.InsertMovie(movie)
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
+	// insert the movie and its genres together, so a failed genre leaves no movie behind
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		newID, err := repo.InsertMovie(r.Context(), movie)
+		if err != nil {
+			return err
+		}
 
-	// now handle genres
-	err = app.DB.UpdateMovieGenres(newID, movie.GenresArray)
+		// now handle genres
+		return repo.UpdateMovieGenres(r.Context(), newID, movie.GenresArray)
+	})
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -254,7 +522,9 @@ func (app *application) InsertMovie(w http.ResponseWriter, r *http.Request){
 	app.writeJSON(w, http.StatusAccepted, resp)
 }
 
-func (app *application) getPoster(movie models.Movie) models.Movie {
+// getPoster looks the movie up at The Movie Database and sets its image to the first
+// result's poster. Failures are logged and leave the movie as it was.
+func (app *application) getPoster(ctx context.Context, movie models.Movie) models.Movie {
 	type TheMovieDB struct {
 		Page int `json:"page"`
 		Results []struct {
@@ -263,35 +533,53 @@ func (app *application) getPoster(movie models.Movie) models.Movie {
 		TotalPages int `json:"total_pages"`
 	}
 
-	client := &http.Client{}
-	theUrl := fmt.Sprintf("https://api.themoviedb.org/3/search/movie?api_key=%s",app.APIKey)
+	client := &http.Client{Transport: tracing.Transport(http.DefaultTransport)}
+	theUrl := fmt.Sprintf("%s/search/movie?api_key=%s", tmdbBaseURL, app.config.TMDBAPIKey)
 
-	req, err := http.NewRequest("GET", theUrl + "&query="+url.QueryEscape(movie.Title), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", theUrl + "&query="+url.QueryEscape(movie.Title), nil)
 	if err != nil {
-		log.Println(err)
+		app.logger.ErrorContext(ctx, "building the TMDB request", "error", err)
 		return movie
 	}
 
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("Content-Type","application/json")
+	req.Header.Set(requestIDHeader, logging.RequestID(ctx))
+
+	start := time.Now()
+	outcome := metrics.OutcomeError
+	defer func() {
+		app.metrics.ObserveTMDB(outcome, time.Since(start))
+	}()
 
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println(err)
+		app.logger.WarnContext(ctx, "TMDB poster lookup failed", "error", redactURLError(err))
 		return movie
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		app.logger.WarnContext(ctx, "TMDB poster lookup failed", "status", resp.StatusCode)
+		return movie
+	}
+
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Println(err)
+		app.logger.WarnContext(ctx, "TMDB poster lookup failed", "error", err)
 		return movie
 	}
 
 	var responseObject TheMovieDB
-	json.Unmarshal(bodyBytes, &responseObject) //unmarshal bodyBytes into responseObject
+	err = json.Unmarshal(bodyBytes, &responseObject) //unmarshal bodyBytes into responseObject
+	if err != nil {
+		app.logger.WarnContext(ctx, "TMDB poster lookup returned invalid JSON", "error", err)
+		return movie
+	}
 
+	outcome = metrics.OutcomeNotFound
 	if len(responseObject.Results) > 0 {
+		outcome = metrics.OutcomeOK
 		movie.Image = responseObject.Results[0].PosterPath
 	}
 
@@ -308,9 +596,9 @@ func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// get existing movie from DB
-	movie, err := app.DB.OneMovie(payload.ID)
+	movie, err := app.DB.OneMovie(r.Context(), payload.ID)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -320,16 +608,19 @@ func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
 	movie.MPAARating = payload.MPAARating
 	movie.RunTime = payload.RunTime
 	movie.UpdatedAt = time.Now()
+	movie.UpdatedBy, _ = app.contextGetUserID(r)
 
-	err = app.DB.UpdateMovie(*movie)
-	if err != nil {
-		app.errorJSON(w, err)
-		return
-	}
+	// update the movie and replace its genres in one transaction
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		err := repo.UpdateMovie(r.Context(), *movie)
+		if err != nil {
+			return err
+		}
 
-	err = app.DB.UpdateMovieGenres(movie.ID, payload.GenresArray)
+		return repo.UpdateMovieGenres(r.Context(), movie.ID, payload.GenresArray)
+	})
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -342,15 +633,15 @@ func (app *application) UpdateMovie(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request){
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	id, err := app.readIDParam(r)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	err = app.DB.DeleteMovie(id)
+	err = app.DB.DeleteMovie(r.Context(), id)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -363,15 +654,15 @@ func (app *application) DeleteMovie(w http.ResponseWriter, r *http.Request){
 }
 
 func (app *application) AllMoviesByGenre(w http.ResponseWriter, r *http.Request){
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	id, err := app.readIDParam(r)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	movies, err := app.DB.AllMovies(id)
+	movies, err := app.DB.AllMovies(r.Context(), id)
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -380,10 +671,18 @@ func (app *application) AllMoviesByGenre(w http.ResponseWriter, r *http.Request)
 
 func (app *application) moviesGraphQL(w http.ResponseWriter, r *http.Request){
 	// we need to populate our Graph type with the movies
-	movies, _ := app.DB.AllMovies()
+	movies, err := app.DB.AllMovies(r.Context())
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
 
 	// get the query from the request
-	q, _ := io.ReadAll(r.Body)
+	q, err := io.ReadAll(r.Body)
+	if err != nil {
+		app.errorJSON(w, invalidBody("body could not be read"))
+		return
+	}
 	query := string(q)
 
 	// create a new variable of type *graph.Graph
@@ -392,16 +691,22 @@ func (app *application) moviesGraphQL(w http.ResponseWriter, r *http.Request){
 	// set the query string on the variable
 	g.QueryString = query
 
+	// full-text search goes to the database rather than the movie list
+	g.Search = func(ctx context.Context, query string, limit int) ([]*models.MovieSearchResult, error) {
+		return app.DB.SearchMovies(ctx, query, limit)
+	}
+
 	// perform the query
-	resp, err := g.Query()
+	resp, err := g.Query(r.Context())
+	if errors.Is(err, graph.ErrQuery) {
+		app.errorJSON(w, repository.Validation("invalid_graphql_query", err.Error()))
+		return
+	}
 	if err != nil {
-		app.errorJSON(w, err)
+		app.errorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
 
 	// send the response
-	j, _ := json.MarshalIndent(resp, "", "\t")
-	w.Header().Set("Content-Type","application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(j)
+	_ = app.writeJSON(w, http.StatusOK, resp)
 }
\ No newline at end of file
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func titles(movies []*models.Movie) []string {
+	var t []string
+	for _, m := range movies {
+		t = append(t, m.Title)
+	}
+	return t
+}
+
+func TestPublicMovieRoutes(t *testing.T) {
+	app := newTestApp(t)
+
+	t.Run("list sorted by title", func(t *testing.T) {
+		rr := doRequest(t, app, http.MethodGet, "/movies", nil, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status: got %d, body %s", rr.Code, rr.Body)
+		}
+
+		var resp struct {
+			Movies   []*models.Movie     `json:"movies"`
+			Metadata models.PageMetadata `json:"metadata"`
+		}
+		decodeBody(t, rr, &resp)
+
+		want := []string{"Highlander", "Raiders of the Lost Ark", "The Godfather"}
+		if got := titles(resp.Movies); !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		if resp.Metadata.TotalRecords != 3 {
+			t.Errorf("total records: got %d, want 3", resp.Metadata.TotalRecords)
+		}
+	})
+
+	t.Run("by genre", func(t *testing.T) {
+		// genre 11 is Adventure
+		rr := doRequest(t, app, http.MethodGet, "/movies/genres/11", nil, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status: got %d, body %s", rr.Code, rr.Body)
+		}
+
+		var movies []*models.Movie
+		decodeBody(t, rr, &movies)
+		if got, want := titles(movies), []string{"Raiders of the Lost Ark"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("one movie", func(t *testing.T) {
+		rr := doRequest(t, app, http.MethodGet, "/movies/1", nil, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status: got %d, body %s", rr.Code, rr.Body)
+		}
+
+		var movie models.Movie
+		decodeBody(t, rr, &movie)
+
+		var genres []string
+		for _, g := range movie.Genres {
+			genres = append(genres, g.Genre)
+		}
+		if movie.Title != "Highlander" || !reflect.DeepEqual(genres, []string{"Action", "Fantasy"}) {
+			t.Errorf("got %q with genres %q", movie.Title, genres)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		code   string
+	}{
+		{"missing movie", "/movies/999", http.StatusNotFound, "movie_not_found"},
+		{"id that is not a number", "/movies/abc", http.StatusBadRequest, "invalid_id"},
+		{"id that is not positive", "/movies/0", http.StatusBadRequest, "invalid_id"},
+		{"unknown route", "/nowhere", http.StatusNotFound, "route_not_found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, app, http.MethodGet, tt.path, nil, nil)
+			checkProblem(t, rr, tt.status, tt.code)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	app := newTestApp(t)
+	user := addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+
+	t.Run("right password", func(t *testing.T) {
+		rr := doRequest(t, app, http.MethodPost, "/authenticate", map[string]string{
+			"email": user.Email, "password": testPassword,
+		}, nil)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("status: got %d, body %s", rr.Code, rr.Body)
+		}
+
+		var tokens TokenPairs
+		decodeBody(t, rr, &tokens)
+
+		claims, err := app.auth.ValidateToken(tokens.Token, tokenUseAccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.Subject != "2" || claims.Role != models.RoleViewer {
+			t.Errorf("got subject %q, role %q", claims.Subject, claims.Role)
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		rr := doRequest(t, app, http.MethodPost, "/authenticate", map[string]string{
+			"email": user.Email, "password": "wrong",
+		}, nil)
+		checkProblem(t, rr, http.StatusBadRequest, "invalid_credentials")
+	})
+
+	t.Run("unknown email", func(t *testing.T) {
+		rr := doRequest(t, app, http.MethodPost, "/authenticate", map[string]string{
+			"email": "nobody@example.com", "password": testPassword,
+		}, nil)
+		checkProblem(t, rr, http.StatusBadRequest, "invalid_credentials")
+	})
+}
+
+func TestEditMovie(t *testing.T) {
+	app := newTestApp(t)
+	editor := addTestUser(t, app, "editor@example.com", models.RoleEditor)
+	viewer := addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+	editorAuth := bearer(loginTokens(t, app, editor).Token)
+	viewerAuth := bearer(loginTokens(t, app, viewer).Token)
+
+	update := func(id int, genres []int) map[string]interface{} {
+		return map[string]interface{}{
+			"id":           id,
+			"title":        "Highlander II",
+			"release_date": "1991-11-01T00:00:00Z",
+			"runtime":      91,
+			"mpaa_rating":  "R",
+			"description":  "The Quickening.",
+			"genres_array": genres,
+		}
+	}
+
+	t.Run("viewer may not edit", func(t *testing.T) {
+		rr := doRequest(t, app, http.MethodPatch, "/admin/movies/1", update(1, []int{5}), viewerAuth)
+		checkProblem(t, rr, http.StatusForbidden, "insufficient_role")
+	})
+
+	t.Run("without a token", func(t *testing.T) {
+		rr := doRequest(t, app, http.MethodPatch, "/admin/movies/1", update(1, []int{5}), nil)
+		checkProblem(t, rr, http.StatusUnauthorized, "authentication_required")
+	})
+
+	t.Run("unknown genre", func(t *testing.T) {
+		rr := doRequest(t, app, http.MethodPatch, "/admin/movies/1", update(1, []int{5, 999}), editorAuth)
+		checkProblem(t, rr, http.StatusBadRequest, "unknown_genre")
+
+		// the movie was not changed either
+		movie, err := app.DB.OneMovie(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if movie.Title != "Highlander" {
+			t.Errorf("title: got %q, want the old one", movie.Title)
+		}
+	})
+
+	t.Run("missing movie", func(t *testing.T) {
+		rr := doRequest(t, app, http.MethodPatch, "/admin/movies/999", update(999, []int{5}), editorAuth)
+		checkProblem(t, rr, http.StatusNotFound, "movie_not_found")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		rr := doRequest(t, app, http.MethodPatch, "/admin/movies/1", update(1, []int{5, 12}), editorAuth)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("status: got %d, body %s", rr.Code, rr.Body)
+		}
+
+		rr = doRequest(t, app, http.MethodGet, "/admin/movies/1", nil, editorAuth)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status: got %d, body %s", rr.Code, rr.Body)
+		}
+
+		var resp struct {
+			Movie  models.Movie    `json:"movie"`
+			Genres []*models.Genre `json:"genres"`
+		}
+		decodeBody(t, rr, &resp)
+
+		if resp.Movie.Title != "Highlander II" || resp.Movie.RunTime != 91 {
+			t.Errorf("got %q, %d minutes", resp.Movie.Title, resp.Movie.RunTime)
+		}
+		var checked []string
+		for _, g := range resp.Genres {
+			if g.Checked {
+				checked = append(checked, g.Genre)
+			}
+		}
+		if want := []string{"Action", "Fantasy"}; !reflect.DeepEqual(checked, want) {
+			t.Errorf("checked genres: got %q, want %q", checked, want)
+		}
+	})
+}
+
+// failingRepo makes the repository methods named in fail return errDatabase, inside
+// transactions too, to stand in for a database outage.
+type failingRepo struct {
+	repository.DatabaseRepo
+	fail map[string]bool
+}
+
+var errDatabase = errors.New("connection refused")
+
+func (f failingRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return f.DatabaseRepo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		return fn(failingRepo{DatabaseRepo: tx, fail: f.fail})
+	})
+}
+
+func (f failingRepo) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	if f.fail["GetRefreshToken"] {
+		return nil, errDatabase
+	}
+	return f.DatabaseRepo.GetRefreshToken(ctx, id)
+}
+
+func (f failingRepo) MarkRefreshTokenUsed(ctx context.Context, id string) (bool, error) {
+	if f.fail["MarkRefreshTokenUsed"] {
+		return false, errDatabase
+	}
+	return f.DatabaseRepo.MarkRefreshTokenUsed(ctx, id)
+}
+
+func (f failingRepo) DeleteUserPasswordResets(ctx context.Context, userID int) error {
+	if f.fail["DeleteUserPasswordResets"] {
+		return errDatabase
+	}
+	return f.DatabaseRepo.DeleteUserPasswordResets(ctx, userID)
+}
+
+// refreshCookie returns the Cookie header that sends token as the refresh cookie.
+func refreshCookie(app *application, token string) http.Header {
+	return http.Header{"Cookie": {app.auth.CookieName + "=" + token}}
+}
+
+// refresh exchanges a refresh token and returns the response and the new token pair.
+func refresh(t *testing.T, app *application, token string) (*httptest.ResponseRecorder, TokenPairs) {
+	t.Helper()
+
+	rr := doRequest(t, app, http.MethodGet, "/refresh", nil, refreshCookie(app, token))
+
+	var tokens TokenPairs
+	if rr.Code == http.StatusOK {
+		decodeBody(t, rr, &tokens)
+	}
+
+	return rr, tokens
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	app := newTestApp(t)
+	user := addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+	first := loginTokens(t, app, user)
+
+	rr, second := refresh(t, app, first.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("first refresh: got %d, body %s", rr.Code, rr.Body)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("the refresh token was not rotated")
+	}
+
+	claims, err := app.auth.ParseRefreshToken(second.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := app.DB.GetRefreshToken(context.Background(), claims.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.FamilyID != first.refresh.FamilyID {
+		t.Errorf("family: got %q, want %q", stored.FamilyID, first.refresh.FamilyID)
+	}
+
+	// replaying the first token revokes the family, including the token that replaced it
+	rr, _ = refresh(t, app, first.RefreshToken)
+	checkProblem(t, rr, http.StatusUnauthorized, "unauthorized")
+
+	rr, _ = refresh(t, app, second.RefreshToken)
+	checkProblem(t, rr, http.StatusUnauthorized, "unauthorized")
+
+	// another session of the user is not affected
+	other := loginTokens(t, app, user)
+	if rr, _ := refresh(t, app, other.RefreshToken); rr.Code != http.StatusOK {
+		t.Errorf("other session: got %d, body %s", rr.Code, rr.Body)
+	}
+}
+
+func TestRefreshTokenRejected(t *testing.T) {
+	app := newTestApp(t)
+	user := addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+
+	revoked := loginTokens(t, app, user)
+	err := app.DB.RevokeRefreshTokenFamily(context.Background(), revoked.refresh.FamilyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := app.auth
+	expired.RefreshExpiry = -time.Hour
+	expiredTokens, err := expired.GenerateTokenPair(&jwtUser{ID: user.ID}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a valid token whose record was never stored
+	unknown, err := app.auth.GenerateTokenPair(&jwtUser{ID: user.ID}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{"no cookie", nil},
+		{"garbage", refreshCookie(app, "garbage")},
+		{"access token", refreshCookie(app, revoked.Token)},
+		{"revoked", refreshCookie(app, revoked.RefreshToken)},
+		{"expired", refreshCookie(app, expiredTokens.RefreshToken)},
+		{"not stored", refreshCookie(app, unknown.RefreshToken)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, app, http.MethodGet, "/refresh", nil, tt.header)
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("status: got %d, want 401 (body %s)", rr.Code, rr.Body)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenDatabaseErrors(t *testing.T) {
+	for _, method := range []string{"GetRefreshToken", "MarkRefreshTokenUsed"} {
+		t.Run(method, func(t *testing.T) {
+			app := newTestApp(t)
+			user := addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+			tokens := loginTokens(t, app, user)
+
+			app.DB = failingRepo{DatabaseRepo: app.DB, fail: map[string]bool{method: true}}
+
+			rr, _ := refresh(t, app, tokens.RefreshToken)
+			checkProblem(t, rr, http.StatusInternalServerError, "internal_server_error")
+			if strings.Contains(rr.Body.String(), errDatabase.Error()) {
+				t.Errorf("the database error reached the client: %s", rr.Body)
+			}
+		})
+	}
+}
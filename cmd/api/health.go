@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Build information, set at link time:
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)" ./cmd/api
+//
+// Without them, the commit and time come from the version control information the go tool
+// records when building in a checkout.
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+// tmdbBaseURL is the root of The Movie Database API. Tests point it at a fake server.
+var tmdbBaseURL = "https://api.themoviedb.org/3"
+
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+var (
+	buildInfoOnce sync.Once
+	buildInfoData buildInfo
+)
+
+// readBuildInfo returns the build information, combining the link time values with the
+// go tool's.
+func readBuildInfo() buildInfo {
+	buildInfoOnce.Do(func() {
+		buildInfoData = collectBuildInfo()
+	})
+	return buildInfoData
+}
+
+func collectBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = setting.Value
+				}
+			case "vcs.modified":
+				info.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	return info
+}
+
+// versionInfo writes the build information.
+func (app *application) versionInfo(w http.ResponseWriter, r *http.Request) {
+	_ = app.writeJSON(w, http.StatusOK, readBuildInfo())
+}
+
+// healthz is the liveness check: it answers as long as the process serves requests.
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	payload := struct {
+		Status string `json:"status"`
+	}{
+		Status: "ok",
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+type checkResult struct {
+	Status   string      `json:"status"`
+	Duration string      `json:"duration"`
+	Error    string      `json:"error,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+type poolStats struct {
+	MaxOpen      int    `json:"max_open"`
+	Open         int    `json:"open"`
+	InUse        int    `json:"in_use"`
+	Idle         int    `json:"idle"`
+	WaitCount    int64  `json:"wait_count"`
+	WaitDuration string `json:"wait_duration"`
+}
+
+// readyz is the readiness check: it is OK when the server is not shutting down and its
+// dependencies answer, each within -health-check-timeout.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(ctx context.Context) (interface{}, error){
+		"database": app.checkDatabase,
+	}
+	if app.config.Health.CheckTMDB {
+		checks["tmdb"] = app.checkTMDB
+	}
+
+	results := make(map[string]checkResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) (interface{}, error)) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(r.Context(), app.config.Health.CheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := check(ctx)
+
+			result := checkResult{Status: "ok", Duration: time.Since(start).String(), Details: details}
+			if err != nil {
+				// anyone can read this, and errors can name hosts and addresses: they go to
+				// the log only
+				app.logger.WarnContext(r.Context(), "readiness check failed", "check", name, "error", err)
+				result.Status = "failing"
+				result.Error = "unavailable"
+				if ctx.Err() == context.DeadlineExceeded {
+					result.Error = "timeout"
+				}
+			}
+
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+
+	wg.Wait()
+
+	payload := struct {
+		Status string                 `json:"status"`
+		Checks map[string]checkResult `json:"checks"`
+	}{
+		Status: "ready",
+		Checks: results,
+	}
+
+	status := http.StatusOK
+	for _, result := range results {
+		if result.Status != "ok" {
+			payload.Status = "not ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	// shutting down, or not yet serving, overrides the checks
+	if !app.ready.Load() {
+		payload.Status = "shutting down"
+		status = http.StatusServiceUnavailable
+	}
+
+	_ = app.writeJSON(w, status, payload)
+}
+
+// checkDatabase pings the database and reports the connection pool statistics.
+func (app *application) checkDatabase(ctx context.Context) (interface{}, error) {
+	conn := app.DB.Connection()
+	if conn == nil {
+		// the in-memory database is always there
+		return nil, nil
+	}
+
+	stats := conn.Stats()
+	pool := poolStats{
+		MaxOpen:      stats.MaxOpenConnections,
+		Open:         stats.OpenConnections,
+		InUse:        stats.InUse,
+		Idle:         stats.Idle,
+		WaitCount:    stats.WaitCount,
+		WaitDuration: stats.WaitDuration.String(),
+	}
+
+	return pool, conn.PingContext(ctx)
+}
+
+// checkTMDB checks that The Movie Database answers and accepts our API key.
+func (app *application) checkTMDB(ctx context.Context) (interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		tmdbBaseURL+"/configuration?api_key="+url.QueryEscape(app.config.TMDBAPIKey), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// the error quotes the URL, which holds the key
+		return nil, fmt.Errorf("themoviedb.org is unreachable: %w", redactURLError(err))
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("themoviedb.org returned %s", resp.Status)
+	}
+
+	return nil, nil
+}
+
+// redactURLError drops the URL from a *url.Error.
+func redactURLError(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"backend/internal/logging"
+	"backend/internal/repository"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubConnector is a database/sql connector whose connections fail with connect.
+type stubConnector struct {
+	connect func(ctx context.Context) (driver.Conn, error)
+}
+
+func (c stubConnector) Connect(ctx context.Context) (driver.Conn, error) { return c.connect(ctx) }
+func (c stubConnector) Driver() driver.Driver                            { return nil }
+
+// connRepo is a MemoryDBRepo that reports db as its connection, for the database check.
+type connRepo struct {
+	repository.DatabaseRepo
+	db *sql.DB
+}
+
+func (c connRepo) Connection() *sql.DB { return c.db }
+
+func stubDB(t *testing.T, connect func(ctx context.Context) (driver.Conn, error)) *sql.DB {
+	t.Helper()
+
+	db := sql.OpenDB(stubConnector{connect: connect})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+type readiness struct {
+	Status string `json:"status"`
+	Checks map[string]struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"checks"`
+}
+
+func TestHealthz(t *testing.T) {
+	app := newTestApp(t)
+
+	// liveness does not depend on readiness
+	rr := doRequest(t, app, http.MethodGet, "/healthz", nil, nil)
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"status":"ok"}` {
+		t.Errorf("got %d %s", rr.Code, rr.Body)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	app := newTestApp(t)
+
+	rr := doRequest(t, app, http.MethodGet, "/version", nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d, body %s", rr.Code, rr.Body)
+	}
+
+	var info buildInfo
+	decodeBody(t, rr, &info)
+	if info.Version != version || info.GoVersion != runtime.Version() {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tmdb := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/configuration" || r.URL.Query().Get("api_key") != "good-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer tmdb.Close()
+
+	defaultURL := tmdbBaseURL
+	tmdbBaseURL = tmdb.URL
+	defer func() { tmdbBaseURL = defaultURL }()
+
+	refused := func(ctx context.Context) (driver.Conn, error) {
+		return nil, errors.New("dial tcp db.internal:5432: connection refused")
+	}
+	hanging := func(ctx context.Context) (driver.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	tests := []struct {
+		name     string
+		setup    func(app *application)
+		status   int
+		ready    string
+		failures map[string]string
+	}{
+		{"memory database", func(app *application) {}, http.StatusOK, "ready", nil},
+		{"shutting down", func(app *application) { app.ready.Store(false) }, http.StatusServiceUnavailable, "shutting down", nil},
+		{"database down", func(app *application) {
+			app.DB = connRepo{DatabaseRepo: app.DB, db: stubDB(t, refused)}
+		}, http.StatusServiceUnavailable, "not ready", map[string]string{"database": "unavailable"}},
+		{"database too slow", func(app *application) {
+			app.config.Health.CheckTimeout = 50 * time.Millisecond
+			app.DB = connRepo{DatabaseRepo: app.DB, db: stubDB(t, hanging)}
+		}, http.StatusServiceUnavailable, "not ready", map[string]string{"database": "timeout"}},
+		{"tmdb up", func(app *application) {
+			app.config.Health.CheckTMDB = true
+			app.config.TMDBAPIKey = "good-key"
+		}, http.StatusOK, "ready", nil},
+		{"tmdb refuses the key", func(app *application) {
+			app.config.Health.CheckTMDB = true
+			app.config.TMDBAPIKey = "revoked-key"
+		}, http.StatusServiceUnavailable, "not ready", map[string]string{"tmdb": "unavailable"}},
+		{"tmdb and database down", func(app *application) {
+			app.config.Health.CheckTMDB = true
+			app.config.TMDBAPIKey = "revoked-key"
+			app.DB = connRepo{DatabaseRepo: app.DB, db: stubDB(t, refused)}
+		}, http.StatusServiceUnavailable, "not ready", map[string]string{"database": "unavailable", "tmdb": "unavailable"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t)
+			var log bytes.Buffer
+			logger, err := logging.New(&log, "json", "info")
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.logger = logger
+			app.ready.Store(true)
+			tt.setup(app)
+
+			start := time.Now()
+			rr := doRequest(t, app, http.MethodGet, "/readyz", nil, nil)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("took %s", elapsed)
+			}
+			if rr.Code != tt.status {
+				t.Errorf("got %d, want %d (body %s)", rr.Code, tt.status, rr.Body)
+			}
+
+			var got readiness
+			decodeBody(t, rr, &got)
+			if got.Status != tt.ready {
+				t.Errorf("status: got %q, want %q", got.Status, tt.ready)
+			}
+
+			for name, check := range got.Checks {
+				want := tt.failures[name]
+				if want == "" && (check.Status != "ok" || check.Error != "") {
+					t.Errorf("%s: got %+v, want ok", name, check)
+				}
+				if want != "" && (check.Status != "failing" || check.Error != want) {
+					t.Errorf("%s: got %+v, want failing with %q", name, check, want)
+				}
+			}
+			if _, ok := got.Checks["tmdb"]; ok != app.config.Health.CheckTMDB {
+				t.Errorf("tmdb check run: %v, want %v", ok, app.config.Health.CheckTMDB)
+			}
+
+			// the causes go to the log, with the request ID, and not to the client
+			for _, secret := range []string{"db.internal", "revoked-key", "401"} {
+				if strings.Contains(rr.Body.String(), secret) {
+					t.Errorf("the response shows %q: %s", secret, rr.Body)
+				}
+			}
+			if len(tt.failures) > 0 {
+				requestID := rr.Header().Get("X-Request-ID")
+				if !strings.Contains(log.String(), "readiness check failed") || requestID == "" || !strings.Contains(log.String(), requestID) {
+					t.Errorf("the failure was not logged with request ID %q: %s", requestID, log.String())
+				}
+			}
+		})
+	}
+}
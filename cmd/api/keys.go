@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is an asymmetric key pair used to sign and verify tokens. ID is sent as the kid
+// header of every token it signs, so verifiers can pick the matching public key.
+type SigningKey struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.Signer
+	PublicKey  crypto.PublicKey
+}
+
+// LoadSigningKeys reads PEM encoded private keys from a comma separated list of files. The
+// key id of each key is its file name without the extension, e.g. keys/2026-10.pem has the
+// id 2026-10. The first key signs new tokens; all of them verify tokens, so a retired key
+// can stay listed until the tokens it signed have expired.
+func LoadSigningKeys(paths string) ([]SigningKey, error) {
+	var keys []SigningKey
+	seen := make(map[string]bool)
+
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if seen[kid] {
+			return nil, fmt.Errorf("duplicate signing key id %q", kid)
+		}
+		seen[kid] = true
+
+		key, err := ParseSigningKey(kid, pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// ParseSigningKey parses a PEM encoded RSA, ECDSA (P-256, P-384 or P-521) or Ed25519 private
+// key, in PKCS #8, PKCS #1 or SEC 1 form, and picks the matching signing method.
+func ParseSigningKey(kid string, pemBytes []byte) (SigningKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return SigningKey{}, errors.New("no PEM data found")
+	}
+
+	var key interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	signingKey := SigningKey{ID: kid}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < 2048 {
+			return SigningKey{}, errors.New("RSA keys must be at least 2048 bits")
+		}
+		signingKey.Method = jwt.SigningMethodRS256
+		signingKey.PrivateKey = k
+		signingKey.PublicKey = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			signingKey.Method = jwt.SigningMethodES256
+		case elliptic.P384():
+			signingKey.Method = jwt.SigningMethodES384
+		case elliptic.P521():
+			signingKey.Method = jwt.SigningMethodES512
+		default:
+			return SigningKey{}, errors.New("unsupported elliptic curve")
+		}
+		signingKey.PrivateKey = k
+		signingKey.PublicKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		signingKey.Method = jwt.SigningMethodEdDSA
+		signingKey.PrivateKey = k
+		signingKey.PublicKey = k.Public()
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	return signingKey, nil
+}
+
+// JWK returns the public half of the key as a JSON Web Key (RFC 7517).
+func (k SigningKey) JWK() map[string]string {
+	jwk := map[string]string{
+		"kid": k.ID,
+		"use": "sig",
+		"alg": k.Method.Alg(),
+	}
+
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	switch pub := k.PublicKey.(type) {
+	case *rsa.PublicKey:
+		jwk["kty"] = "RSA"
+		jwk["n"] = b64(pub.N.Bytes())
+		jwk["e"] = b64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// coordinates are padded to the size of the curve
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk["kty"] = "EC"
+		jwk["crv"] = pub.Curve.Params().Name
+		jwk["x"] = b64(pub.X.FillBytes(make([]byte, size)))
+		jwk["y"] = b64(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk["kty"] = "OKP"
+		jwk["crv"] = "Ed25519"
+		jwk["x"] = b64(pub)
+	}
+
+	return jwk
+}
+
+// jwks publishes the public keys that verify our tokens, so that other services can check
+// them without sharing a secret.
+func (app *application) jwks(w http.ResponseWriter, r *http.Request) {
+	var payload = struct {
+		Keys []map[string]string `json:"keys"`
+	}{
+		Keys: []map[string]string{},
+	}
+
+	for _, key := range app.auth.Keys {
+		payload.Keys = append(payload.Keys, key.JWK())
+	}
+
+	headers := http.Header{}
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	_ = app.writeJSON(w, http.StatusOK, payload, headers)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// writeKey writes a PKCS #8 PEM file for key to dir/name.pem and returns its path.
+func writeKey(t *testing.T, dir, name string, key interface{}) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name+".pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// testKeys writes an Ed25519 key "old" and an ES256 key "new" and returns their paths.
+func testKeys(t *testing.T) (oldPath, newPath string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return writeKey(t, dir, "old", edKey), writeKey(t, dir, "new", ecKey)
+}
+
+// authWithKeys returns the test application's Auth, signing with the keys in paths.
+func authWithKeys(t *testing.T, paths ...string) Auth {
+	t.Helper()
+
+	keys, err := LoadSigningKeys(strings.Join(paths, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := newTestApp(t).auth
+	auth.Keys = keys
+	return auth
+}
+
+func accessTokenFor(t *testing.T, auth Auth) string {
+	t.Helper()
+
+	tokens, err := auth.GenerateTokenPair(&jwtUser{ID: 1, Role: "viewer"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens.Token
+}
+
+func TestLoadSigningKeys(t *testing.T) {
+	oldPath, newPath := testKeys(t)
+
+	keys, err := LoadSigningKeys(newPath + ", " + oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != "new" || keys[1].ID != "old" {
+		t.Fatalf("got %+v", keys)
+	}
+	if keys[0].Method != jwt.SigningMethodES256 || keys[1].Method != jwt.SigningMethodEdDSA {
+		t.Errorf("methods: got %v and %v", keys[0].Method.Alg(), keys[1].Method.Alg())
+	}
+
+	_, err = LoadSigningKeys(oldPath + "," + oldPath)
+	if err == nil {
+		t.Error("a duplicate key id was accepted")
+	}
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadSigningKeys(writeKey(t, t.TempDir(), "weak", weak))
+	if err == nil {
+		t.Error("a 1024 bit RSA key was accepted")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldPath, newPath := testKeys(t)
+
+	before := authWithKeys(t, oldPath)
+	during := authWithKeys(t, newPath, oldPath)
+	after := authWithKeys(t, newPath)
+
+	oldToken := accessTokenFor(t, before)
+	newToken := accessTokenFor(t, during)
+
+	header := func(token string) map[string]interface{} {
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Header
+	}
+	if kid := header(newToken)["kid"]; kid != "new" {
+		t.Errorf("new tokens are signed with %v, want the first key", kid)
+	}
+
+	tests := []struct {
+		name  string
+		auth  Auth
+		token string
+		err   error
+	}{
+		{"old token before rotation", before, oldToken, nil},
+		{"old token during the overlap", during, oldToken, nil},
+		{"new token during the overlap", during, newToken, nil},
+		{"new token after the old key is gone", after, newToken, nil},
+		{"old token after the old key is gone", after, oldToken, ErrTokenSignature},
+		{"new token on a server that has not rotated", before, newToken, ErrTokenSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.auth.ValidateToken(tt.token, tokenUseAccess)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerificationKeyFollowsKid(t *testing.T) {
+	oldPath, newPath := testKeys(t)
+	auth := authWithKeys(t, newPath, oldPath)
+
+	claims := jwt.MapClaims{
+		"sub":       "1",
+		"aud":       auth.Audience,
+		"iss":       auth.Issuer,
+		"token_use": tokenUseAccess,
+	}
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		// signed by the ES256 key but naming the Ed25519 one
+		{"kid of another key", sign(jwt.SigningMethodES256, "old", auth.Keys[0].PrivateKey)},
+		{"unknown kid", sign(jwt.SigningMethodES256, "retired", auth.Keys[0].PrivateKey)},
+		{"no kid", sign(jwt.SigningMethodES256, "", auth.Keys[0].PrivateKey)},
+		// HS256 with the public key as the secret, the classic algorithm confusion
+		{"HS256 with a key's kid", sign(jwt.SigningMethodHS256, "old", []byte(auth.Keys[1].PublicKey.(ed25519.PublicKey)))},
+		{"HS256 with the fallback secret", sign(jwt.SigningMethodHS256, "", []byte(auth.Secret))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := auth.ValidateToken(tt.token, tokenUseAccess)
+			if !errors.Is(err, ErrTokenSignature) {
+				t.Errorf("got %v, want %v", err, ErrTokenSignature)
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	oldPath, newPath := testKeys(t)
+
+	app := newTestApp(t)
+	app.auth = authWithKeys(t, newPath, oldPath)
+
+	rr := doRequest(t, app, http.MethodGet, "/.well-known/jwks.json", nil, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status: got %d, body %s", rr.Code, rr.Body)
+	}
+
+	var jwks struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	decodeBody(t, rr, &jwks)
+
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("got %d keys, want both", len(jwks.Keys))
+	}
+	for i, want := range []map[string]string{
+		{"kid": "new", "kty": "EC", "alg": "ES256", "crv": "P-256"},
+		{"kid": "old", "kty": "OKP", "alg": "EdDSA", "crv": "Ed25519"},
+	} {
+		for field, value := range want {
+			if jwks.Keys[i][field] != value {
+				t.Errorf("key %d %s: got %q, want %q", i, field, jwks.Keys[i][field], value)
+			}
+		}
+		if _, ok := jwks.Keys[i]["d"]; ok {
+			t.Errorf("key %d publishes its private part", i)
+		}
+	}
+}
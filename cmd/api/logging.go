@@ -0,0 +1,154 @@
+package main
+
+import (
+	"backend/internal/logging"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// requestIDHeader carries the request ID in requests and responses, and on to TMDB.
+const requestIDHeader = "X-Request-ID"
+
+// requestID gives every request an ID: the client's X-Request-ID if it is a sensible one,
+// or a new one. The ID is sent back in the response and is part of every log record made
+// with the request context.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// loggingResponseWriter records the status and size of a response, and keeps the request
+// context for writeJSON.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	status int
+	bytes  int
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// requestContext returns the context of the request answered through w, which may be
+// wrapped by other middleware, or context.Background if the access log did not wrap it.
+func requestContext(w http.ResponseWriter) context.Context {
+	for {
+		switch ww := w.(type) {
+		case *loggingResponseWriter:
+			return ww.ctx
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = ww.Unwrap()
+		default:
+			return context.Background()
+		}
+	}
+}
+
+// accessLog logs every request once it is answered, with its route, status, latency and
+// authenticated user.
+func (app *application) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw := &loggingResponseWriter{ResponseWriter: w, ctx: r.Context()}
+		start := time.Now()
+
+		next.ServeHTTP(lw, r)
+
+		status := lw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		route := ""
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			route = rctx.RoutePattern()
+		}
+
+		app.logger.LogAttrs(r.Context(), level, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Int("bytes", lw.bytes),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("ip", app.clientIP(r)),
+		)
+	})
+}
+
+// recoverPanic turns a panic in a handler into a logged 500 response.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			// the server uses this panic to abort a response on purpose
+			if rvr == http.ErrAbortHandler {
+				panic(rvr)
+			}
+
+			app.logger.ErrorContext(r.Context(), "panic", "panic", fmt.Sprint(rvr), "stack", string(debug.Stack()))
+
+			w.Header().Set("Connection", "close")
+			app.errorJSON(w, errors.New("internal server error"), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logDBCall logs failed repository calls, and all of them at debug level. It is a
+// dbrepo.Hook.
+func (app *application) logDBCall(ctx context.Context, method string) (context.Context, func(err error)) {
+	start := time.Now()
+
+	return ctx, func(err error) {
+		switch {
+		case err != nil && !errors.Is(err, sql.ErrNoRows):
+			app.logger.ErrorContext(ctx, "database call failed", "method", method,
+				"duration", time.Since(start), "error", err)
+		case app.logger.Enabled(ctx, slog.LevelDebug):
+			app.logger.DebugContext(ctx, "database call", "method", method,
+				"duration", time.Since(start), "not_found", err != nil)
+		}
+	}
+}
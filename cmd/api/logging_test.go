@@ -0,0 +1,156 @@
+package main
+
+import (
+	"backend/internal/logging"
+	"backend/internal/models"
+	"backend/internal/repository/dbrepo"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logRecords returns the JSON records written to buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		err := json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// newLoggedTestApp returns a test app that logs JSON to the returned buffer.
+func newLoggedTestApp(t *testing.T) (*application, *bytes.Buffer) {
+	t.Helper()
+
+	app := newTestApp(t)
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.logger = logger
+
+	return app, &buf
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		name string
+		sent string
+		// kept is whether the response carries the sent ID rather than a new one
+		kept bool
+	}{
+		{"none", "", false},
+		{"valid", "abc-123_X.y", true},
+		{"too long", strings.Repeat("a", 129), false},
+		{"forged log line", "a\" level=ERROR msg=\"forged", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.sent != "" {
+				header.Set(requestIDHeader, tt.sent)
+			}
+
+			rr := doRequest(t, app, http.MethodGet, "/healthz", nil, header)
+			got := rr.Header().Get(requestIDHeader)
+			if (got == tt.sent) != tt.kept || !logging.ValidRequestID(got) {
+				t.Errorf("got request ID %q for %q", got, tt.sent)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	app, buf := newLoggedTestApp(t)
+	user := addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+	auth := bearer(loginTokens(t, app, user).Token)
+	auth.Set(requestIDHeader, "client-id-1")
+
+	doRequest(t, app, http.MethodGet, "/me/", nil, auth)
+	doRequest(t, app, http.MethodGet, "/movies/9999", nil, nil)
+
+	records := logRecords(t, buf)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want 2:\n%s", len(records), buf)
+	}
+
+	// the user is set by authRequired, inside the access log, and still reaches its record
+	me := records[0]
+	if me["msg"] != "request" || me["request_id"] != "client-id-1" || me["user_id"] != float64(user.ID) ||
+		me["route"] != "/me" || me["status"] != 200.0 || me["method"] != "GET" {
+		t.Errorf("got %v", me)
+	}
+
+	anonymous := records[1]
+	if _, ok := anonymous["user_id"]; ok || anonymous["request_id"] == nil || anonymous["route"] != "/movies/{id}" || anonymous["status"] != 404.0 {
+		t.Errorf("got %v", anonymous)
+	}
+}
+
+func TestHandlerLogsCarryTheRequest(t *testing.T) {
+	app, buf := newLoggedTestApp(t)
+	user := addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+	tokens := loginTokens(t, app, user)
+	app.DB = dbrepo.Observe(failingRepo{DatabaseRepo: app.DB, fail: map[string]bool{"GetRefreshToken": true}}, app.logDBCall)
+
+	rr := doRequest(t, app, http.MethodGet, "/refresh", nil, refreshCookie(app, tokens.RefreshToken))
+	id := rr.Header().Get(requestIDHeader)
+
+	var found bool
+	for _, record := range logRecords(t, buf) {
+		if record["msg"] == "database call failed" {
+			found = true
+			if record["request_id"] != id || record["method"] != "GetRefreshToken" {
+				t.Errorf("got %v, want request ID %s", record, id)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("the database error was not logged:\n%s", buf)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	ctx := logging.WithRequestID(context.Background(), "req-1")
+	lw := &loggingResponseWriter{ResponseWriter: httptest.NewRecorder(), ctx: ctx}
+
+	if got := requestContext(lw); logging.RequestID(got) != "req-1" {
+		t.Errorf("direct: got request ID %q", logging.RequestID(got))
+	}
+
+	// other middleware wraps the writer further in
+	wrapped := &unwrappingWriter{ResponseWriter: lw}
+	if got := requestContext(wrapped); logging.RequestID(got) != "req-1" {
+		t.Errorf("wrapped: got request ID %q", logging.RequestID(got))
+	}
+
+	if got := requestContext(httptest.NewRecorder()); got != context.Background() {
+		t.Errorf("without the access log: got %v", got)
+	}
+}
+
+type unwrappingWriter struct {
+	http.ResponseWriter
+}
+
+func (w *unwrappingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -1,77 +1,215 @@
 package main
 
 import (
+	"backend/internal/config"
+	"backend/internal/logging"
+	"backend/internal/mailer"
+	"backend/internal/metrics"
+	"backend/internal/oidc"
 	"backend/internal/repository"
 	"backend/internal/repository/dbrepo"
+	"backend/internal/tracing"
+	"context"
+	"errors"
 	"flag"
-	"fmt"
+	"io/fs"
 	"log"
-	"net/http"
+	"log/slog"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
-const port = 8080
-
 type application struct {
-	DSN string
-	Domain string
+	config *config.Config
 	DB repository.DatabaseRepo
+	logger *slog.Logger
 	auth Auth
-	JWTSecret string
-	JWTIssuer string
-	JWTAudience string
-	CookieDomain string
-	APIKey string
+	loginLimits LoginLimits
+	trustedProxies TrustedProxies
+	mailer mailer.Mailer
+	oidc *oidc.Provider
+	metrics *metrics.Metrics
+	// ready is false until the server accepts requests and again once it starts shutting down.
+	ready atomic.Bool
+	// background tracks goroutines that shutdown waits for.
+	background sync.WaitGroup
 }
 
 func main() {
 	// set application config
 	var app application
 
+	// a .env file is optional; variables already in the environment win over it
 	err := godotenv.Load(".env")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Fatal(err)
+	}
+
+	cfg, args, err := config.Load(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	app.config = cfg
+
+	app.logger, err = logging.New(os.Stdout, cfg.Log.Format, cfg.Log.Level)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// read from command line
-	flag.StringVar(&app.DSN, "dsn", "host=localhost port=5432 user=" + os.Getenv("DB_USER") + " password=" + os.Getenv("DB_PASSWORD") + " dbname=" + os.Getenv("DB_NAME") + " sslmode=disable timezone=UTC connect_timeout=5","Postgres connection string")
-	flag.StringVar(&app.JWTSecret, "jwt-secret","verysecret","signing secret")
-	flag.StringVar(&app.JWTIssuer, "jwt-issuer","example.com","signing issuer")
-	flag.StringVar(&app.JWTAudience, "jwt-audience","example.com","signing audience")
-	flag.StringVar(&app.CookieDomain, "cookie-domain","localhost","cookie domain")
-	flag.StringVar(&app.Domain, "domain","example.com","domain")
-	flag.StringVar(&app.APIKey, "api-key", os.Getenv("API_KEY"),"api key")
-	flag.Parse()
+	// subcommands
+	if len(args) > 0 && args[0] == "migrate" {
+		err := app.runMigrate(args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if len(args) > 0 && args[0] == "config" {
+		err := app.runConfig(args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	// connect to the database
-	conn, err := app.connectToDB()
+	err = cfg.Validate()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	app.DB = &dbrepo.PostgresDBRepo{DB: conn}
-	defer app.DB.Connection().Close()
+	// what is still logged with the log package, by libraries for instance, goes there too
+	slog.SetDefault(app.logger)
+
+	if cfg.Mode == config.ModeDev && cfg.JWT.Keys == "" && cfg.JWT.Secret == config.DefaultJWTSecret {
+		app.logger.Warn("signing tokens with the default JWT secret, which is only allowed in dev mode")
+	}
+
+	app.metrics = metrics.New()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter: cfg.Trace.Exporter,
+		OTLPEndpoint: cfg.Trace.OTLPEndpoint,
+		SampleRatio: cfg.Trace.SampleRatio,
+		ServiceName: "gomovies",
+		ServiceVersion: version,
+	}, app.logger)
+	if err != nil {
+		app.fatal("setting up tracing", err)
+	}
+
+	dbSystem := "postgresql"
+
+	if cfg.InMemory {
+		// local development without Postgres
+		app.DB = dbrepo.NewSeededMemoryDBRepo()
+		dbSystem = "memory"
+		app.logger.Info("using in-memory database")
+	} else {
+		// connect to the database
+		conn, err := app.connectToDB()
+		if err != nil {
+			app.fatal("connecting to the database", err)
+		}
+
+		app.DB = &dbrepo.PostgresDBRepo{DB: conn, Timeout: cfg.DB.Timeout}
+		app.metrics.RegisterDB(conn, "movies")
+
+		// do not run against a schema this binary does not expect
+		err = app.checkSchema(context.Background())
+		if err != nil {
+			app.fatal("checking the database schema", err)
+		}
+	}
+
+	// time, trace and log every repository call
+	app.DB = dbrepo.Observe(app.DB, app.metrics.DBHook, tracing.DBHook(dbSystem), app.logDBCall)
+
+	signingKeys, err := LoadSigningKeys(cfg.JWT.Keys)
+	if err != nil {
+		app.fatal("loading signing keys", err)
+	}
 
 	app.auth = Auth{
-		Issuer: app.JWTIssuer,
-		Audience: app.JWTAudience,
-		Secret: app.JWTSecret,
-		TokenExpiry: time.Minute * 15,
-		RefreshExpiry: time.Hour * 24,
-		CookiePath: "/",
-		CookieName: "refresh_token",
-		CookieDomain: app.CookieDomain,
+		Issuer: cfg.JWT.Issuer,
+		Audience: cfg.JWT.Audience,
+		Secret: cfg.JWT.Secret,
+		Keys: signingKeys,
+		TokenExpiry: cfg.JWT.TokenExpiry,
+		RefreshExpiry: cfg.JWT.RefreshExpiry,
+		ChallengeExpiry: cfg.JWT.ChallengeExpiry,
+		Leeway: cfg.JWT.Leeway,
+		CookiePath: cfg.Cookie.Path,
+		CookieName: cfg.Cookie.Name,
+		CookieDomain: cfg.Cookie.Domain,
+	}
+
+	if cfg.Mail.SMTPHost != "" {
+		app.mailer = &mailer.SMTP{
+			Host: cfg.Mail.SMTPHost,
+			Port: cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From: cfg.Mail.From,
+		}
+	} else {
+		app.mailer = &mailer.Local{Dir: cfg.Mail.Dir, From: cfg.Mail.From, Logger: app.logger}
 	}
 
-	log.Println("Starting application on port", port)
+	if cfg.OIDC.Issuer != "" {
+		app.oidc = oidc.NewProvider(oidc.Config{
+			Issuer: cfg.OIDC.Issuer,
+			ClientID: cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL: cfg.OIDC.RedirectURL,
+		})
+	}
+
+	app.loginLimits = LoginLimits{
+		MaxAccountFailures: cfg.Login.MaxAccountFailures,
+		MaxIPFailures: cfg.Login.MaxIPFailures,
+		BaseDelay: cfg.Login.BaseDelay,
+		Lockout: cfg.Login.Lockout,
+		ResetAfter: cfg.Login.ResetAfter,
+	}
+
+	app.trustedProxies, err = ParseTrustedProxies(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		app.fatal("parsing trusted proxies", err)
+	}
 
 	// start a web server
-	err = http.ListenAndServe(fmt.Sprintf(":%d", port), app.routes())
+	err = app.serve()
+	if err != nil {
+		app.logger.Error("server stopped with an error", "error", err)
+	}
+
+	// requests may use the database until the server has stopped
+	if conn := app.DB.Connection(); conn != nil {
+		conn.Close()
+	}
+
+	// send the last spans
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if tracingErr := shutdownTracing(ctx); tracingErr != nil {
+		app.logger.Error("flushing traces", "error", tracingErr)
+	}
 
 	if err != nil {
-		log.Fatal(err)
+		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
+
+// fatal logs a startup error and exits.
+func (app *application) fatal(msg string, err error) {
+	app.logger.Error(msg, "error", err)
+	os.Exit(1)
+}
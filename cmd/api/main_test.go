@@ -0,0 +1,186 @@
+package main
+
+import (
+	"backend/internal/config"
+	"backend/internal/mailer"
+	"backend/internal/metrics"
+	"backend/internal/models"
+	"backend/internal/repository/dbrepo"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword is the password of the users made by addTestUser.
+const testPassword = "correct horse battery staple"
+
+// newTestApp returns an application on a seeded MemoryDBRepo, signing tokens with an HS256
+// secret and keeping outgoing mail in a testMailer.
+func newTestApp(t *testing.T) *application {
+	t.Helper()
+
+	cfg := config.Default()
+	cfg.Mode = config.ModeDev
+	cfg.InMemory = true
+
+	app := &application{
+		config:  cfg,
+		DB:      dbrepo.NewSeededMemoryDBRepo(),
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		mailer:  &testMailer{},
+		metrics: metrics.New(),
+		auth: Auth{
+			Issuer:          cfg.JWT.Issuer,
+			Audience:        cfg.JWT.Audience,
+			Secret:          "a test secret that is long enough to sign with",
+			TokenExpiry:     cfg.JWT.TokenExpiry,
+			RefreshExpiry:   cfg.JWT.RefreshExpiry,
+			ChallengeExpiry: cfg.JWT.ChallengeExpiry,
+			Leeway:          cfg.JWT.Leeway,
+			CookiePath:      cfg.Cookie.Path,
+			CookieName:      cfg.Cookie.Name,
+			CookieDomain:    cfg.Cookie.Domain,
+		},
+		loginLimits: LoginLimits{
+			MaxAccountFailures: cfg.Login.MaxAccountFailures,
+			MaxIPFailures:      cfg.Login.MaxIPFailures,
+			BaseDelay:          cfg.Login.BaseDelay,
+			Lockout:            cfg.Login.Lockout,
+			ResetAfter:         cfg.Login.ResetAfter,
+		},
+	}
+
+	// mail goes out in the background; let it finish before the test ends
+	t.Cleanup(app.background.Wait)
+
+	return app
+}
+
+// addTestUser stores a user with testPassword and the role, and returns it.
+func addTestUser(t *testing.T, app *application, email, role string) *models.User {
+	t.Helper()
+
+	// the lowest cost keeps the tests fast
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := models.User{
+		FirstName: "Test",
+		LastName:  "User",
+		Email:     email,
+		Password:  string(hash),
+		Role:      role,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	user.ID, err = app.DB.InsertUser(context.Background(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &user
+}
+
+// loginTokens issues a token pair for user, as a successful login does.
+func loginTokens(t *testing.T, app *application, user *models.User) TokenPairs {
+	t.Helper()
+
+	tokens, err := app.issueTokens(context.Background(), user, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tokens
+}
+
+// bearer returns the Authorization header of an access token.
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
+
+// doRequest sends a request through the application's routes. body, if not nil, is sent as
+// JSON.
+func doRequest(t *testing.T, app *application, method, path string, body interface{}, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+
+	return rr
+}
+
+// decodeBody decodes the JSON response body into v.
+func decodeBody(t *testing.T, rr *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(rr.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("decoding %q: %v", rr.Body.String(), err)
+	}
+}
+
+// checkProblem fails the test unless the response is a problem with the status and code.
+func checkProblem(t *testing.T, rr *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+
+	if rr.Code != status {
+		t.Errorf("status: got %d, want %d (body %s)", rr.Code, status, rr.Body)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("content type: got %q, want application/problem+json", ct)
+	}
+
+	var p problem
+	decodeBody(t, rr, &p)
+	if p.Code != code {
+		t.Errorf("code: got %q, want %q (detail %q)", p.Code, code, p.Detail)
+	}
+}
+
+// testMailer keeps the messages it is given.
+type testMailer struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// sent returns the messages sent so far.
+func (m *testMailer) sent() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]mailer.Message(nil), m.messages...)
+}
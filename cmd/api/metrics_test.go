@@ -0,0 +1,152 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository/dbrepo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// dbCalls returns the number of repository calls of method with outcome the metrics saw.
+func dbCalls(t *testing.T, app *application, method, outcome string) uint64 {
+	t.Helper()
+
+	families, err := app.metrics.Gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != "gomovies_db_call_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["method"] == method && labels["outcome"] == outcome {
+				return metric.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+
+	return 0
+}
+
+func TestHTTPMetricsUseRoutePatterns(t *testing.T) {
+	app := newTestApp(t)
+
+	for _, path := range []string{"/movies/1", "/movies/2", "/movies/2", "/no/such/route", "/metrics"} {
+		doRequest(t, app, http.MethodGet, path, nil, nil)
+	}
+	doRequest(t, app, http.MethodDelete, "/movies/1", nil, nil)
+
+	// the ids do not become labels, and neither do paths that match no route
+	expected := `
+# HELP gomovies_http_requests_total HTTP requests by method, route pattern and status code.
+# TYPE gomovies_http_requests_total counter
+gomovies_http_requests_total{method="DELETE",route="unmatched",status="405"} 1
+gomovies_http_requests_total{method="GET",route="/movies/{id}",status="200"} 3
+gomovies_http_requests_total{method="GET",route="unmatched",status="404"} 2
+`
+	err := testutil.GatherAndCompare(app.metrics.Gatherer(), strings.NewReader(expected), "gomovies_http_requests_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	count, err := testutil.GatherAndCount(app.metrics.Gatherer(), "gomovies_http_request_duration_seconds")
+	if err != nil || count != 3 {
+		t.Errorf("got %d latency series, want 3 (%v)", count, err)
+	}
+}
+
+func TestMetricsRoutes(t *testing.T) {
+	app := newTestApp(t)
+	doRequest(t, app, http.MethodGet, "/movies/1", nil, nil)
+
+	// the API port does not serve the metrics, the internal listener does
+	rr := doRequest(t, app, http.MethodGet, "/metrics", nil, nil)
+	checkProblem(t, rr, http.StatusNotFound, "route_not_found")
+
+	srv := httptest.NewServer(app.metricsRoutes())
+	defer srv.Close()
+
+	err := testutil.ScrapeAndCompare(srv.URL+"/metrics", strings.NewReader(`
+# HELP gomovies_http_requests_total HTTP requests by method, route pattern and status code.
+# TYPE gomovies_http_requests_total counter
+gomovies_http_requests_total{method="GET",route="/movies/{id}",status="200"} 1
+gomovies_http_requests_total{method="GET",route="unmatched",status="404"} 1
+`), "gomovies_http_requests_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDBMetrics(t *testing.T) {
+	app := newTestApp(t)
+	user := addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+	tokens := loginTokens(t, app, user)
+
+	app.DB = dbrepo.Observe(failingRepo{DatabaseRepo: app.DB, fail: map[string]bool{"GetRefreshToken": true}}, app.metrics.DBHook)
+
+	doRequest(t, app, http.MethodGet, "/movies/1", nil, nil)
+	doRequest(t, app, http.MethodGet, "/movies/9999", nil, nil)
+	refresh(t, app, tokens.RefreshToken)
+
+	tests := []struct {
+		method, outcome string
+		want            uint64
+	}{
+		{"OneMovie", "ok", 1},
+		{"OneMovie", "not_found", 1},
+		{"OneMovie", "error", 0},
+		{"GetRefreshToken", "error", 1},
+	}
+	for _, tt := range tests {
+		if got := dbCalls(t, app, tt.method, tt.outcome); got != tt.want {
+			t.Errorf("%s %s: got %d calls, want %d", tt.method, tt.outcome, got, tt.want)
+		}
+	}
+
+	// missing rows are not errors
+	expected := `
+# HELP gomovies_db_call_errors_total Repository calls that failed, by method. Rows not found are not counted.
+# TYPE gomovies_db_call_errors_total counter
+gomovies_db_call_errors_total{method="GetRefreshToken"} 1
+`
+	err := testutil.GatherAndCompare(app.metrics.Gatherer(), strings.NewReader(expected), "gomovies_db_call_errors_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoginMetrics(t *testing.T) {
+	app := newTestApp(t)
+	addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+
+	login := func(password string) {
+		doRequest(t, app, http.MethodPost, "/authenticate", map[string]string{"email": "viewer@example.com", "password": password}, nil)
+	}
+
+	login(testPassword)
+	login("wrong")
+	// within the backoff after the failure
+	login(testPassword)
+
+	expected := `
+# HELP gomovies_logins_total Login attempts by method (password, totp, oidc) and result (success, failure, throttled, or challenge when a second factor is required).
+# TYPE gomovies_logins_total counter
+gomovies_logins_total{method="password",result="failure"} 1
+gomovies_logins_total{method="password",result="success"} 1
+gomovies_logins_total{method="password",result="throttled"} 1
+`
+	err := testutil.GatherAndCompare(app.metrics.Gatherer(), strings.NewReader(expected), "gomovies_logins_total")
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -1,32 +1,220 @@
 package main
 
-import "net/http"
+import (
+	"backend/internal/cors"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 
-func (app *application) enableCORS(h http.Handler) http.Handler {
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	errInvalidAPIKey = repository.Unauthorized("invalid_api_key", "invalid api key")
+	errInsufficientScope = repository.Forbidden("insufficient_scope", "the api key does not have the scope for this request")
+	errSessionRequired = repository.Forbidden("session_required", "api keys may not be used for this request")
+	errMFARequired = repository.Forbidden("mfa_required", "admin accounts must enable two-factor authentication")
+	errInsufficientRole = repository.Forbidden("insufficient_role", "your role does not allow this request")
+)
+
+// corsPolicies returns the CORS policies for the routes. Only the origins of our own
+// frontends may send credentials; the public catalog can be opened to more origins, since it
+// is read without them.
+func (app *application) corsPolicies() *cors.Mux {
+	cfg := app.config.CORS
+
+	policies := &cors.Mux{
+		Default: &cors.Policy{
+			AllowedOrigins: cfg.AllowedOrigins,
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Accept", "Content-Type", "X-CSRF-Token", "Authorization", "X-API-Key", requestIDHeader},
+			ExposedHeaders: []string{"Retry-After", "WWW-Authenticate", requestIDHeader},
+			AllowCredentials: true,
+			MaxAge: cfg.MaxAge,
+		},
+		Reject: func(w http.ResponseWriter, r *http.Request, err error) {
+			// the cors errors have fixed messages
+			app.errorJSON(w, repository.Forbidden("cors_rejected", err.Error()))
+		},
+	}
+
+	// the public catalog; /movies/... also holds the search and genre listings
+	public := app.publicCORSPolicy()
+	for _, prefix := range []string{"/movies", "/genres", "/graph", "/.well-known"} {
+		policies.Handle(prefix, public)
+	}
+
+	return policies
+}
+
+// publicCORSPolicy returns the policy for routes anyone may read without logging in.
+func (app *application) publicCORSPolicy() *cors.Policy {
+	origins := app.config.CORS.PublicAllowedOrigins
+	if len(origins) == 0 {
+		origins = app.config.CORS.AllowedOrigins
+	}
+
+	return &cors.Policy{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Accept", "Content-Type", requestIDHeader},
+		ExposedHeaders: []string{requestIDHeader},
+		MaxAge: app.config.CORS.MaxAge,
+	}
+}
+
+// authRequired rejects requests without a valid access token or API key, and makes the
+// claims and user id available to the handlers through the request context.
+func (app *application) authRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
-		//w.Header().Set("Access-Control-Allow-Origin","http://localhost:3000")
-		w.Header().Set("Access-Control-Allow-Origin","http://192.18.136.71")
-		w.Header().Set("Access-Control-Allow-Credentials","true")
-		
-		if r.Method == "OPTIONS" {
-			w.Header().Set("Access-Control-Allow-Methods","GET,POST,PUT,PATCH,DELETE,OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers","Accept, Content-Type, X-CSRF-Token, Authorization")
+		if r.Header.Get("X-API-Key") != "" {
+			app.apiKeyRequired(next).ServeHTTP(w, r)
+			return
+		}
 
+		_, claims, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
+		if err != nil {
+			app.authChallenge(w, err)
 			return
-		}else{
-			h.ServeHTTP(w, r)
 		}
+
+		userID, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			app.authChallenge(w, ErrTokenMalformed)
+			return
+		}
+
+		next.ServeHTTP(w, app.contextSetAuth(r, claims, userID))
 	})
 }
 
-func (app *application) authRequired(next http.Handler) http.Handler {
+// apiKeyRequired authenticates a request by its X-API-Key header. The key acts with the
+// current role of its owner, further limited by requireScope.
+func (app *application) apiKeyRequired(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
-		_, _, err := app.auth.GetTokenFromHeaderAndVerify(w, r)
+		key, err := app.DB.GetAPIKeyByHash(r.Context(), models.HashAPIKey(r.Header.Get("X-API-Key")))
+		if errors.Is(err, repository.ErrAPIKeyNotFound) {
+			app.errorJSON(w, errInvalidAPIKey)
+			return
+		}
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		user, err := app.DB.GetUserById(r.Context(), key.UserID)
+		if errors.Is(err, repository.ErrUserNotFound) {
+			app.errorJSON(w, errInvalidAPIKey)
+			return
+		}
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		err = app.DB.TouchAPIKey(r.Context(), key.ID, time.Now())
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), "recording api key use", "error", err)
+		}
+
+		// the claims an access token of the user would carry, except that the key only counts
+		// as 2FA-verified if it was created in a verified session and 2FA is still on
+		claims := &Claims{
+			Role: user.Role,
+			TokenUse: "api_key",
+			MFA: key.MFA && user.TOTPEnabled,
+			RegisteredClaims: jwt.RegisteredClaims{
+				Subject: strconv.Itoa(user.ID),
+			},
+		}
+
+		r = app.contextSetAuth(r, claims, user.ID)
+		next.ServeHTTP(w, app.contextSetAPIKey(r, key))
+	})
+}
+
+// requireScope only lets requests authenticated with an API key through if the key has
+// scope. Access tokens are not limited by scopes. It must run after authRequired.
+func (app *application) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+			key, ok := app.contextGetAPIKey(r)
+			if ok && !key.HasScope(scope) {
+				app.errorJSON(w, errInsufficientScope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// sessionRequired rejects requests authenticated with an API key, for account management
+// that needs the user themselves. It must run after authRequired.
+func (app *application) sessionRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+		if _, ok := app.contextGetAPIKey(r); ok {
+			app.errorJSON(w, errSessionRequired)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
+}
+
+// requireRole only lets requests through whose access token carries one of the given roles.
+// The role claim is set when the token is issued, so a role change applies from the next
+// token refresh. It must run after authRequired.
+func (app *application) requireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request){
+			claims, ok := app.contextGetClaims(r)
+			if !ok {
+				app.authChallenge(w, ErrNoToken)
+				return
+			}
+
+			// admins must have 2FA; until they enroll they can only manage their account
+			if claims.Role == models.RoleAdmin && !claims.MFA {
+				app.errorJSON(w, errMFARequired)
+				return
+			}
+
+			for _, role := range roles {
+				if claims.Role == role {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm=%q, error="insufficient_scope"`, app.auth.Issuer))
+			app.errorJSON(w, errInsufficientRole)
+		})
+	}
+}
+
+// authChallenge rejects a request whose access token failed validation, with the
+// WWW-Authenticate challenge RFC 6750 defines for the reason.
+func (app *application) authChallenge(w http.ResponseWriter, err error) {
+	challenge := fmt.Sprintf("Bearer realm=%q", app.auth.Issuer)
+	var reason *repository.Error
+
+	switch {
+	case errors.Is(err, ErrNoToken):
+		// no error code when the client did not try to authenticate
+		reason = repository.Unauthorized("authentication_required", "authentication required")
+	case errors.Is(err, ErrInvalidAuthHeader):
+		challenge += `, error="invalid_request"`
+		reason = repository.Validation("invalid_request", err.Error())
+	default:
+		challenge += fmt.Sprintf(`, error="invalid_token", error_description=%q`, err.Error())
+		reason = repository.Unauthorized("invalid_token", err.Error())
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	app.errorJSON(w, reason.Wrap(err))
 }
\ No newline at end of file
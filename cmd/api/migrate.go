@@ -0,0 +1,134 @@
+package main
+
+import (
+	"backend/internal/migrations"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const migrateUsage = `usage:
+  migrate up             apply all pending migrations
+  migrate down N         roll back the N most recent migrations
+  migrate status         list migrations and whether they are applied
+  migrate create NAME    add an empty migration to ` + migrations.SourceDir
+
+// runMigrate executes a `migrate` subcommand. args are the arguments after "migrate".
+func (app *application) runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	// create only writes files, so it does not need a database
+	if args[0] == "create" {
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+
+		up, down, err := migrations.Create(migrations.SourceDir, args[1])
+		if err != nil {
+			return err
+		}
+
+		fmt.Println("created", up)
+		fmt.Println("created", down)
+		return nil
+	}
+
+	switch args[0] {
+	case "up", "status":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+	case "down":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	conn, err := app.connectToDB()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	migrator, err := migrations.New(conn)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("database schema is up to date")
+		}
+
+	case "down":
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errors.New(migrateUsage)
+		}
+
+		rolledBack, err := migrator.Down(ctx, n)
+		for _, m := range rolledBack {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		tw.Flush()
+	}
+
+	return nil
+}
+
+// checkSchema refuses to serve requests against a database that is missing migrations. It
+// does not change the database: one that was never migrated is just behind.
+func (app *application) checkSchema(ctx context.Context) error {
+	migrator, err := migrations.New(app.DB.Connection())
+	if err != nil {
+		return err
+	}
+
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind: %d pending migration(s), starting with %04d_%s; run `migrate up` first",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+
+	return nil
+}
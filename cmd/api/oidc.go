@@ -0,0 +1,284 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/oidc"
+	"backend/internal/repository"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	// oidcStateExpiry is how long the user has to log in at the provider
+	oidcStateExpiry = 10 * time.Minute
+)
+
+var (
+	errOIDCNotConfigured    = repository.NotFound("oidc_not_configured", "oidc login is not configured")
+	errOIDCLinkRefused      = repository.Forbidden("oidc_link_refused", "an account with this email address exists and cannot be linked to an oidc login automatically")
+	errOIDCLoginFailed      = repository.Unauthorized("oidc_login_failed", "oidc login failed")
+	errOIDCStateExpired     = repository.Validation("oidc_state_expired", "oidc login has expired, start again")
+	errOIDCInvalidState     = repository.Validation("invalid_oidc_state", "invalid oidc state")
+	errOIDCEmailNotVerified = repository.Forbidden("oidc_email_not_verified", "the oidc provider did not confirm a verified email address")
+)
+
+// oidcLogin starts an OIDC login: it remembers state, nonce and PKCE verifier in a signed
+// cookie and redirects the browser to the provider.
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.errorJSON(w, errOIDCNotConfigured, http.StatusNotFound)
+		return
+	}
+
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	stateToken, err := app.auth.GenerateOIDCStateToken(state, nonce, verifier, oidcStateExpiry)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	redirectURL, err := app.oidc.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "oidc provider is unavailable", "error", err)
+		app.errorJSON(w, errors.New("oidc provider is unavailable"), http.StatusBadGateway)
+		return
+	}
+
+	http.SetCookie(w, app.oidcStateCookie(stateToken, oidcStateExpiry))
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// oidcCallback finishes an OIDC login. The user linked to the provider account is logged in;
+// without one, the viewer with the provider's verified email address is linked, or a new
+// viewer account is created. The response is the same as from authenticate, or a redirect
+// to -oidc-post-login-url if that is set.
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.errorJSON(w, errOIDCNotConfigured, http.StatusNotFound)
+		return
+	}
+
+	// the state cookie is good for one attempt
+	http.SetCookie(w, app.oidcStateCookie("", -1))
+
+	qs := r.URL.Query()
+
+	if providerErr := qs.Get("error"); providerErr != "" {
+		// the query comes from whoever sent the browser here, so it is only logged
+		app.logger.WarnContext(r.Context(), "oidc provider returned an error", "error", providerErr, "description", qs.Get("error_description"))
+		app.metrics.CountLogin("oidc", "failure")
+		app.errorJSON(w, errOIDCLoginFailed)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		app.errorJSON(w, errOIDCStateExpired)
+		return
+	}
+
+	claims, err := app.auth.ValidateToken(cookie.Value, tokenUseOIDCState)
+	if err != nil || subtle.ConstantTimeCompare([]byte(claims.ID), []byte(qs.Get("state"))) != 1 {
+		app.errorJSON(w, errOIDCInvalidState)
+		return
+	}
+
+	idToken, err := app.oidc.Exchange(r.Context(), qs.Get("code"), claims.PKCEVerifier, claims.Nonce)
+	if err != nil {
+		app.logger.WarnContext(r.Context(), "oidc code exchange failed", "error", err)
+		app.metrics.CountLogin("oidc", "failure")
+		app.errorJSON(w, errOIDCLoginFailed.Wrap(err))
+		return
+	}
+
+	if idToken.Email == "" || !idToken.EmailVerified {
+		app.metrics.CountLogin("oidc", "failure")
+		app.errorJSON(w, errOIDCEmailNotVerified)
+		return
+	}
+
+	user, err := app.oidcUser(r, idToken)
+	if errors.Is(err, errOIDCLinkRefused) {
+		app.logger.WarnContext(r.Context(), "refused to link an oidc login to a privileged account", "issuer", idToken.Issuer, "subject", idToken.Subject, "email", idToken.Email)
+		app.metrics.CountLogin("oidc", "failure")
+		app.errorJSON(w, err)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// 2FA still applies: the provider only replaces the password
+	if user.TOTPEnabled {
+		challenge, err := app.auth.GenerateChallengeToken(user.ID)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		app.metrics.CountLogin("oidc", "challenge")
+
+		if app.config.OIDC.PostLoginURL != "" {
+			// in the fragment, which browsers do not send to servers
+			http.Redirect(w, r, app.config.OIDC.PostLoginURL+"#challenge_token="+url.QueryEscape(challenge), http.StatusFound)
+			return
+		}
+
+		resp := struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			ChallengeToken    string `json:"challenge_token"`
+		}{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		}
+
+		_ = app.writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+
+	tokens, err := app.issueTokens(r.Context(), user, "")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	tokens.TwoFactorEnrollmentRequired = user.Role == models.RoleAdmin
+
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokens.RefreshToken))
+
+	app.metrics.CountLogin("oidc", "success")
+
+	// the frontend gets its access token from /refresh, as on any page load
+	if app.config.OIDC.PostLoginURL != "" {
+		http.Redirect(w, r, app.config.OIDC.PostLoginURL, http.StatusFound)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, tokens)
+}
+
+// oidcUser finds or creates the user for a verified ID token. Only viewers are linked by
+// their email address: an editor or admin account would be as safe as the provider's email
+// verification, so for those it returns errOIDCLinkRefused.
+func (app *application) oidcUser(r *http.Request, idToken *oidc.IDToken) (*models.User, error) {
+	var user *models.User
+
+	err := app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		var err error
+
+		// an account that logged in through the provider before
+		user, err = repo.GetUserByOIDCIdentity(r.Context(), idToken.Issuer, idToken.Subject)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		// an existing account with the same address
+		user, err = repo.GetUserByEmail(r.Context(), models.NormalizeEmail(idToken.Email))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if user != nil && user.Role != models.RoleViewer {
+			return errOIDCLinkRefused
+		}
+
+		// or a new one
+		if user == nil {
+			user, err = newOIDCUser(idToken)
+			if err != nil {
+				return err
+			}
+
+			user.ID, err = repo.InsertUser(r.Context(), *user)
+			if err != nil {
+				return err
+			}
+		}
+
+		return repo.LinkOIDCIdentity(r.Context(), idToken.Issuer, idToken.Subject, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// newOIDCUser returns a viewer for the owner of idToken. The account gets a random password
+// nobody knows; a password can be set with the password reset flow.
+func newOIDCUser(idToken *oidc.IDToken) (*models.User, error) {
+	firstName, lastName := idToken.GivenName, idToken.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(idToken.Name), " ")
+	}
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(idToken.Email, "@")
+	}
+	if lastName == "" {
+		lastName = "-"
+	}
+
+	user := &models.User{
+		FirstName: strings.TrimSpace(firstName),
+		LastName:  strings.TrimSpace(lastName),
+		Email:     models.NormalizeEmail(idToken.Email),
+		Role:      models.RoleViewer,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	password, err := oidc.RandomString()
+	if err != nil {
+		return nil, err
+	}
+
+	// bcrypt only looks at the first 72 bytes
+	err = user.SetPassword(password[:models.PasswordMaxLength/2])
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// oidcStateCookie returns the cookie carrying an OIDC login to the callback. It is SameSite
+// Lax, unlike the refresh cookie, because the callback is a redirect from the provider's site.
+func (app *application) oidcStateCookie(value string, maxAge time.Duration) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/auth/oidc",
+		Value:    value,
+		MaxAge:   int(maxAge.Seconds()),
+		Expires:  time.Now().Add(maxAge),
+		SameSite: http.SameSiteLaxMode,
+		Domain:   app.auth.CookieDomain,
+		HttpOnly: true,
+		Secure:   true,
+	}
+
+	if maxAge < 0 {
+		cookie.MaxAge = -1
+		cookie.Expires = time.Unix(0, 0)
+	}
+
+	return cookie
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/oidc"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testOIDCClientID    = "gomovies"
+	testOIDCRedirectURL = "http://localhost:8080/auth/oidc/callback"
+)
+
+// testIdentity is the account a user logs in with at the mock provider.
+type testIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// mockProvider is an OIDC provider with discovery, a key set and a token endpoint that
+// checks PKCE. Authorizations are made by authorize instead of a login page.
+type mockProvider struct {
+	*httptest.Server
+	t   *testing.T
+	key SigningKey
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	identity  testIdentity
+	nonce     string
+	challenge string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &mockProvider{
+		t:     t,
+		key:   SigningKey{ID: "provider-key", Method: jwt.SigningMethodEdDSA, PrivateKey: private, PublicKey: public},
+		codes: make(map[string]mockAuthorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, oidc.Metadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSURI:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]interface{}{"keys": []map[string]string{p.key.JWK()}})
+	})
+	mux.HandleFunc("/token", p.token)
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	return p
+}
+
+func writeTestJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// authorize logs identity in for the authorization request at authURL and returns the
+// callback query the provider redirects the browser back with.
+func (p *mockProvider) authorize(authURL string, identity testIdentity) url.Values {
+	p.t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	q := u.Query()
+
+	if q.Get("client_id") != testOIDCClientID || q.Get("redirect_uri") != testOIDCRedirectURL || q.Get("code_challenge_method") != "S256" {
+		p.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	code, err := oidc.RandomString()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+
+	p.mu.Lock()
+	p.codes[code] = mockAuthorization{identity: identity, nonce: q.Get("nonce"), challenge: q.Get("code_challenge")}
+	p.mu.Unlock()
+
+	return url.Values{"code": {code}, "state": {q.Get("state")}}
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	auth, ok := p.codes[r.PostFormValue("code")]
+	delete(p.codes, r.PostFormValue("code"))
+	p.mu.Unlock()
+
+	if !ok || r.PostFormValue("redirect_uri") != testOIDCRedirectURL ||
+		oidc.CodeChallenge(r.PostFormValue("code_verifier")) != auth.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		writeTestJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	token := jwt.NewWithClaims(p.key.Method, jwt.MapClaims{
+		"iss":            p.URL,
+		"aud":            testOIDCClientID,
+		"sub":            auth.identity.Subject,
+		"email":          auth.identity.Email,
+		"email_verified": auth.identity.EmailVerified,
+		"nonce":          auth.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = p.key.ID
+
+	signed, err := token.SignedString(p.key.PrivateKey)
+	if err != nil {
+		p.t.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeTestJSON(w, map[string]string{"access_token": "unused", "token_type": "Bearer", "id_token": signed})
+}
+
+// startOIDCLogin requests /auth/oidc/login and returns the provider URL it redirects to and
+// the state cookie.
+func startOIDCLogin(t *testing.T, app *application) (string, *http.Cookie) {
+	t.Helper()
+
+	rr := doRequest(t, app, http.MethodGet, "/auth/oidc/login", nil, nil)
+	if rr.Code != http.StatusFound {
+		t.Fatalf("login: got %d, body %s", rr.Code, rr.Body)
+	}
+
+	for _, cookie := range rr.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			return rr.Header().Get("Location"), cookie
+		}
+	}
+
+	t.Fatal("login did not set the state cookie")
+	return "", nil
+}
+
+func oidcCallback(t *testing.T, app *application, query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var header http.Header
+	if cookie != nil {
+		header = http.Header{"Cookie": {(&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String()}}
+	}
+
+	return doRequest(t, app, http.MethodGet, "/auth/oidc/callback?"+query.Encode(), nil, header)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	provider := newMockProvider(t)
+
+	app := newTestApp(t)
+	app.oidc = oidc.NewProvider(oidc.Config{
+		Issuer:      provider.URL,
+		ClientID:    testOIDCClientID,
+		RedirectURL: testOIDCRedirectURL,
+	})
+
+	viewer := addTestUser(t, app, "viewer@example.com", models.RoleViewer)
+	addTestUser(t, app, "editor@example.com", models.RoleEditor)
+
+	// login logs identity in and returns the callback response and the user it logged in as
+	login := func(identity testIdentity) (*httptest.ResponseRecorder, int) {
+		authURL, cookie := startOIDCLogin(t, app)
+		rr := oidcCallback(t, app, provider.authorize(authURL, identity), cookie)
+
+		var tokens TokenPairs
+		if rr.Code != http.StatusAccepted {
+			return rr, 0
+		}
+		decodeBody(t, rr, &tokens)
+
+		claims, err := app.auth.ValidateToken(tokens.Token, tokenUseAccess)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rr, id
+	}
+
+	t.Run("new account", func(t *testing.T) {
+		rr, id := login(testIdentity{Subject: "new-1", Email: "Newcomer@example.com", EmailVerified: true})
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("got %d, body %s", rr.Code, rr.Body)
+		}
+
+		user, err := app.DB.GetUserById(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Email != "newcomer@example.com" || user.Role != models.RoleViewer {
+			t.Errorf("got %s with role %s", user.Email, user.Role)
+		}
+
+		// the next login finds the account by the identity, even if the address changed
+		_, again := login(testIdentity{Subject: "new-1", Email: "renamed@example.com", EmailVerified: true})
+		if again != id {
+			t.Errorf("second login: got user %d, want %d", again, id)
+		}
+	})
+
+	t.Run("existing viewer", func(t *testing.T) {
+		_, id := login(testIdentity{Subject: "viewer-1", Email: "viewer@example.com", EmailVerified: true})
+		if id != viewer.ID {
+			t.Errorf("got user %d, want %d", id, viewer.ID)
+		}
+	})
+
+	t.Run("existing editor", func(t *testing.T) {
+		rr, _ := login(testIdentity{Subject: "editor-1", Email: "editor@example.com", EmailVerified: true})
+		checkProblem(t, rr, http.StatusForbidden, "oidc_link_refused")
+	})
+
+	t.Run("existing admin", func(t *testing.T) {
+		rr, _ := login(testIdentity{Subject: "admin-1", Email: "admin@example.com", EmailVerified: true})
+		checkProblem(t, rr, http.StatusForbidden, "oidc_link_refused")
+	})
+
+	t.Run("unverified email", func(t *testing.T) {
+		rr, _ := login(testIdentity{Subject: "unverified-1", Email: "viewer@example.com"})
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("got %d, body %s", rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("without the state cookie", func(t *testing.T) {
+		authURL, _ := startOIDCLogin(t, app)
+		rr := oidcCallback(t, app, provider.authorize(authURL, testIdentity{Subject: "s", Email: "s@example.com", EmailVerified: true}), nil)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("got %d, body %s", rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("wrong state", func(t *testing.T) {
+		authURL, cookie := startOIDCLogin(t, app)
+		query := provider.authorize(authURL, testIdentity{Subject: "s", Email: "s@example.com", EmailVerified: true})
+		query.Set("state", "forged")
+
+		rr := oidcCallback(t, app, query, cookie)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("got %d, body %s", rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("code of another login", func(t *testing.T) {
+		// the victim's code with the attacker's own state cookie and state: the PKCE
+		// verifier in the cookie does not match the challenge the code was issued for
+		victimURL, _ := startOIDCLogin(t, app)
+		victim := provider.authorize(victimURL, testIdentity{Subject: "s", Email: "s@example.com", EmailVerified: true})
+
+		attackerURL, attackerCookie := startOIDCLogin(t, app)
+		u, _ := url.Parse(attackerURL)
+		query := url.Values{"code": {victim.Get("code")}, "state": {u.Query().Get("state")}}
+
+		rr := oidcCallback(t, app, query, attackerCookie)
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("got %d, body %s", rr.Code, rr.Body)
+		}
+	})
+
+	t.Run("provider error", func(t *testing.T) {
+		_, cookie := startOIDCLogin(t, app)
+		query := url.Values{"error": {"access_denied"}, "error_description": {"<script>alert(1)</script>"}}
+
+		// anyone can send the browser here with any error, so it is not repeated back
+		rr := oidcCallback(t, app, query, cookie)
+		checkProblem(t, rr, http.StatusUnauthorized, "oidc_login_failed")
+		if strings.Contains(rr.Body.String(), "script") || strings.Contains(rr.Body.String(), "access_denied") {
+			t.Errorf("the response repeats the query: %s", rr.Body)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"backend/internal/mailer"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// passwordResetExpiry is how long a password reset link works.
+const passwordResetExpiry = time.Hour
+
+var errInvalidResetToken = repository.Validation("invalid_reset_token", "invalid or expired reset token")
+
+// forgotPassword emails a password reset link to the address, if it belongs to a user. The
+// response is the same either way, so it does not reveal which addresses are registered.
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "if the address belongs to an account, a reset link has been sent to it",
+	}
+
+	user, err := app.DB.GetUserByEmail(r.Context(), models.NormalizeEmail(requestPayload.Email))
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = app.writeJSON(w, http.StatusAccepted, resp)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	token, reset, err := models.NewPasswordReset(user.ID, passwordResetExpiry)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.InsertPasswordReset(r.Context(), reset)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Someone asked to reset the password of your account. To choose a new password, open\n\n"+
+			"%s?token=%s\n\n"+
+			"The link works once, for %d minutes. If you did not ask for it, you can ignore this email.\n",
+			user.FirstName, app.config.Mail.PasswordResetURL, url.QueryEscape(token), int(passwordResetExpiry.Minutes())),
+	}
+
+	// sending in the background keeps the response time the same as for unknown addresses
+	// the request's values, such as its ID, but not its cancellation
+	ctx := context.WithoutCancel(r.Context())
+
+	app.runInBackground(func() {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		err := app.mailer.Send(ctx, msg)
+		if err != nil {
+			app.logger.ErrorContext(ctx, "sending password reset email", "error", err)
+		}
+	})
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// resetPassword sets a new password with a token from forgotPassword. All of the user's
+// sessions and other reset tokens end with it.
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var user *models.User
+	var invalidPassword error
+
+	// a rejected password rolls back, so the token can be used again with a better one
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		userID, err := repo.UsePasswordReset(r.Context(), models.HashPasswordResetToken(requestPayload.Token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return errInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		user, err = repo.GetUserById(r.Context(), userID)
+		if err != nil {
+			return err
+		}
+
+		err = models.ValidatePassword(requestPayload.Password, user.Email)
+		if err != nil {
+			invalidPassword = repository.Validation("invalid_password", err.Error())
+			return invalidPassword
+		}
+
+		err = user.SetPassword(requestPayload.Password)
+		if err != nil {
+			return err
+		}
+
+		err = repo.UpdateUserPassword(r.Context(), user.ID, user.Password)
+		if err != nil {
+			return err
+		}
+
+		err = repo.RevokeUserRefreshTokens(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		return repo.DeleteUserPasswordResets(r.Context(), user.ID)
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidResetToken), err == invalidPassword:
+			app.errorJSON(w, err)
+		default:
+			app.errorJSON(w, err, http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// the owner of the address has proven themselves, so lift any login lockout
+	err = app.DB.ClearLoginThrottle(r.Context(), accountThrottleKey(user.Email))
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "clearing the login throttle", "error", err)
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password changed",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"backend/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var resetLink = regexp.MustCompile(`\?token=(\S+)`)
+
+func TestPasswordResetRoundTrip(t *testing.T) {
+	app := newTestApp(t)
+	mail := app.mailer.(*testMailer)
+
+	user := addTestUser(t, app, "forgetful@example.com", models.RoleViewer)
+	oldSession := loginTokens(t, app, user)
+
+	forgot := func(email string) {
+		t.Helper()
+
+		rr := doRequest(t, app, http.MethodPost, "/password/forgot", map[string]string{"email": email}, nil)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("forgot: got %d, body %s", rr.Code, rr.Body)
+		}
+		app.background.Wait()
+	}
+	reset := func(token, password string) *httptest.ResponseRecorder {
+		return doRequest(t, app, http.MethodPost, "/password/reset", map[string]string{"token": token, "password": password}, nil)
+	}
+
+	// unknown addresses get the same answer and no mail
+	forgot("nobody@example.com")
+	if n := len(mail.sent()); n != 0 {
+		t.Fatalf("got %d messages for an unknown address", n)
+	}
+
+	forgot("Forgetful@Example.com")
+	sent := mail.sent()
+	if len(sent) != 1 || sent[0].To != user.Email {
+		t.Fatalf("got %+v, want one message to %s", sent, user.Email)
+	}
+
+	match := resetLink.FindStringSubmatch(sent[0].Body)
+	if match == nil {
+		t.Fatalf("no reset link in %q", sent[0].Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(sent[0].Body, "Hello Test,") {
+		t.Errorf("body: got %q", sent[0].Body)
+	}
+
+	const newPassword = "a new password 2026"
+
+	checkProblem(t, reset("not-the-token", newPassword), http.StatusBadRequest, "invalid_reset_token")
+
+	// a rejected password leaves the token usable
+	if rr := reset(token, "short"); rr.Code != http.StatusBadRequest {
+		t.Errorf("weak password: got %d, body %s", rr.Code, rr.Body)
+	}
+	if rr := reset(token, newPassword); rr.Code != http.StatusAccepted {
+		t.Fatalf("reset: got %d, body %s", rr.Code, rr.Body)
+	}
+	checkProblem(t, reset(token, "another password 2027"), http.StatusBadRequest, "invalid_reset_token")
+
+	login := func(password string) int {
+		payload := map[string]string{"email": user.Email, "password": password}
+		return doRequest(t, app, http.MethodPost, "/authenticate", payload, nil).Code
+	}
+	if code := login(newPassword); code != http.StatusAccepted {
+		t.Errorf("login with the new password: got %d", code)
+	}
+
+	// the sessions from before the reset are over
+	rr, _ := refresh(t, app, oldSession.RefreshToken)
+	checkProblem(t, rr, http.StatusUnauthorized, "unauthorized")
+}
@@ -1,26 +1,53 @@
 package main
 
 import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/tracing"
+	"errors"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 )
 
+var errRouteNotFound = repository.NotFound("route_not_found", "no such route")
+
 func (app *application) routes() http.Handler {
 	// create a router mux
 	mux := chi.NewRouter()
 
-	mux.Use(middleware.Recoverer)
-	mux.Use(app.enableCORS)
+	mux.Use(app.requestID)
+	mux.Use(tracing.Middleware)
+	mux.Use(app.accessLog)
+	mux.Use(app.metrics.Middleware)
+	mux.Use(app.recoverPanic)
+	mux.Use(app.corsPolicies().Handler)
+
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		app.errorJSON(w, errRouteNotFound)
+	})
+	mux.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		app.errorJSON(w, errors.New("method not allowed"), http.StatusMethodNotAllowed)
+	})
 
 	mux.Get("/", app.Home)
+	mux.Get("/healthz", app.healthz)
+	mux.Get("/readyz", app.readyz)
+	mux.Get("/version", app.versionInfo)
+	mux.Get("/.well-known/jwks.json", app.jwks)
 
+	mux.Post("/register", app.register)
 	mux.Post("/authenticate", app.authenticate)
+	mux.Post("/authenticate/2fa", app.authenticate2FA)
+	mux.Get("/auth/oidc/login", app.oidcLogin)
+	mux.Get("/auth/oidc/callback", app.oidcCallback)
+	mux.Post("/password/forgot", app.forgotPassword)
+	mux.Post("/password/reset", app.resetPassword)
 	mux.Get("/refresh",app.refreshToken)
 	mux.Get("/logout",app.logout)
 
 	mux.Get("/movies", app.AllMovies)
+	mux.Get("/movies/search", app.SearchMovies)
 	mux.Get("/movies/{id}", app.GetMovie)
 
 	mux.Get("/genres", app.AllGenres)
@@ -29,15 +56,60 @@ func (app *application) routes() http.Handler {
 	mux.Post("/graph", app.moviesGraphQL)
 
 
+	mux.Route("/me", func(mux chi.Router){
+		mux.Use(app.authRequired)
+		mux.Use(app.sessionRequired)
+
+		mux.Get("/", app.getMe)
+		mux.Patch("/", app.updateMe)
+		mux.Delete("/", app.deleteMe)
+		mux.Post("/password", app.changePassword)
+		mux.Post("/logout-all", app.logoutEverywhere)
+
+		mux.Get("/api-keys", app.listAPIKeys)
+		mux.Post("/api-keys", app.createAPIKey)
+		mux.Delete("/api-keys/{id}", app.deleteAPIKey)
+
+		mux.Post("/2fa/enroll", app.enrollTOTP)
+		mux.Post("/2fa/verify", app.verifyTOTP)
+		mux.Delete("/2fa", app.disableTOTP)
+	})
+
 	mux.Route("/admin", func(mux chi.Router){
 		mux.Use(app.authRequired)
+
+		// who may do what
+		anyRole := app.requireRole(models.RoleAdmin, models.RoleEditor, models.RoleViewer)
+		editors := app.requireRole(models.RoleAdmin, models.RoleEditor)
+		admins := app.requireRole(models.RoleAdmin)
+
+		// what API keys may do
+		read := app.requireScope(models.ScopeMoviesRead)
+		write := app.requireScope(models.ScopeMoviesWrite)
 		
-		mux.Get("/movies", app.MovieCatalog)
-		mux.Get("/movies/{id}", app.MovieForEdit)
-		mux.Put("/movies/0", app.InsertMovie)
-		mux.Patch("/movies/{id}", app.UpdateMovie)
-		mux.Delete("/movies/{id}", app.DeleteMovie)
+		mux.With(anyRole, read).Get("/movies", app.MovieCatalog)
+		mux.With(anyRole, read).Get("/movies/{id}", app.MovieForEdit)
+		mux.With(editors, write).Put("/movies/0", app.InsertMovie)
+		mux.With(editors, write).Patch("/movies/{id}", app.UpdateMovie)
+		mux.With(admins, write).Delete("/movies/{id}", app.DeleteMovie)
+
+		mux.With(admins, app.sessionRequired).Put("/users/{id}/role", app.setUserRole)
+		mux.With(admins, app.sessionRequired).Delete("/users/{id}/login-lock", app.unlockLogin)
+	})
+
+	return mux
+}
+
+// metricsRoutes serves the Prometheus metrics. It listens on -metrics-addr, which is meant to
+// be reachable only from inside the deployment, rather than on the public API port.
+func (app *application) metricsRoutes() http.Handler {
+	mux := chi.NewRouter()
+
+	mux.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		app.errorJSON(w, errRouteNotFound)
 	})
 
+	mux.Method(http.MethodGet, "/metrics", app.metrics.Handler())
+
 	return mux
-}
\ No newline at end of file
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+)
+
+// serve runs the web server until SIGINT or SIGTERM, then shuts it down gracefully: the
+// server reports not-ready, stops accepting connections and waits for in-flight requests and
+// background work, such as sending emails, to finish within the shutdown timeout.
+func (app *application) serve() error {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", app.config.Port))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		// a second signal stops at once
+		defer signal.Stop(quit)
+
+		select {
+		case s := <-quit:
+			cancel(fmt.Errorf("received %s", s))
+		case <-ctx.Done():
+		}
+	}()
+
+	return app.serveUntil(ctx, ln)
+}
+
+// serveUntil serves requests on ln until ctx is done, then shuts down as serve describes.
+func (app *application) serveUntil(ctx context.Context, ln net.Listener) error {
+	cfg := app.config.HTTP
+
+	srv := &http.Server{
+		Handler:           app.routes(),
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelWarn),
+	}
+
+	// metrics are served on their own, internal, address; they stop with the API server
+	var metricsSrv *http.Server
+	if app.config.Metrics.Addr != "" {
+		metricsSrv = &http.Server{
+			Handler:           app.metricsRoutes(),
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelWarn),
+		}
+
+		metricsLn, err := net.Listen("tcp", app.config.Metrics.Addr)
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("metrics listener: %w", err)
+		}
+
+		go func() {
+			err := metricsSrv.Serve(metricsLn)
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.logger.Error("metrics server stopped", "error", err)
+			}
+		}()
+	}
+
+	// stopped on shutdown, before waiting for the background tasks
+	pruneCtx, stopPruning := context.WithCancel(context.Background())
+	defer stopPruning()
+	app.runInBackground(func() {
+		app.pruneLoginThrottles(pruneCtx)
+	})
+
+	shutdownErr := make(chan error)
+	// closed when the server stops by itself, so that shutdown does not wait for ctx
+	stopped := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+			return
+		}
+
+		app.logger.Info("shutting down", "reason", context.Cause(ctx).Error(), "drain_timeout", (cfg.ShutdownDelay + cfg.ShutdownTimeout).String())
+		app.ready.Store(false)
+
+		// keep serving while load balancers notice that we are not ready
+		time.Sleep(cfg.ShutdownDelay)
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+
+		err := srv.Shutdown(ctx)
+		if metricsSrv != nil {
+			_ = metricsSrv.Shutdown(ctx)
+		}
+		if err != nil {
+			shutdownErr <- err
+			return
+		}
+
+		stopPruning()
+
+		done := make(chan struct{})
+		go func() {
+			app.background.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+			shutdownErr <- nil
+		case <-ctx.Done():
+			shutdownErr <- errors.New("shutdown timed out waiting for background tasks")
+		}
+	}()
+
+	app.logger.Info("starting application", "addr", ln.Addr().String(), "metrics_addr", app.config.Metrics.Addr, "mode", app.config.Mode, "version", version)
+
+	app.ready.Store(true)
+
+	err := srv.Serve(ln)
+	if !errors.Is(err, http.ErrServerClosed) {
+		close(stopped)
+		if metricsSrv != nil {
+			_ = metricsSrv.Close()
+		}
+		return err
+	}
+
+	err = <-shutdownErr
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("server stopped")
+	return nil
+}
+
+// runInBackground runs fn in a goroutine that shutdown waits for.
+func (app *application) runInBackground(fn func()) {
+	app.background.Add(1)
+
+	go func() {
+		defer app.background.Done()
+
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error("background task panicked", "panic", fmt.Sprint(err), "stack", string(debug.Stack()))
+			}
+		}()
+
+		fn()
+	}()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// blockingRepo holds ListMovies calls until release is closed, to keep a request in flight.
+type blockingRepo struct {
+	repository.DatabaseRepo
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b blockingRepo) ListMovies(ctx context.Context, filter models.MovieFilter) ([]*models.Movie, models.PageMetadata, error) {
+	b.started <- struct{}{}
+	<-b.release
+	return b.DatabaseRepo.ListMovies(ctx, filter)
+}
+
+func TestServeShutsDownGracefully(t *testing.T) {
+	app := newTestApp(t)
+	app.config.Metrics.Addr = ""
+	app.config.HTTP.ShutdownDelay = 300 * time.Millisecond
+	app.config.HTTP.ShutdownTimeout = 5 * time.Second
+
+	blocking := blockingRepo{DatabaseRepo: app.DB, started: make(chan struct{}), release: make(chan struct{})}
+	app.DB = blocking
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + ln.Addr().String()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- app.serveUntil(ctx, ln)
+	}()
+
+	// without keep-alives: a spare connection the client dialed but never used would hold up
+	// the shutdown for five seconds, which is how long net/http waits for new connections
+	client := &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+	readyz := func() int {
+		resp, err := client.Get(base + "/readyz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// waitFor polls /readyz until it answers with status
+	waitFor := func(status int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for readyz() != status {
+			if time.Now().After(deadline) {
+				t.Fatalf("/readyz never answered %d", status)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor(http.StatusOK)
+
+	// a request that is still running when the shutdown starts
+	inFlight := make(chan int, 1)
+	go func() {
+		resp, err := client.Get(base + "/movies")
+		if err != nil {
+			t.Error(err)
+			inFlight <- 0
+			return
+		}
+		resp.Body.Close()
+		inFlight <- resp.StatusCode
+	}()
+	<-blocking.started
+
+	cancel(errors.New("test"))
+
+	// during the shutdown delay the server still answers, but is not ready
+	waitFor(http.StatusServiceUnavailable)
+
+	close(blocking.release)
+	if status := <-inFlight; status != http.StatusOK {
+		t.Errorf("in-flight request: got %d, want %d", status, http.StatusOK)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return")
+	}
+
+	// and no longer accepts connections
+	_, err = client.Get(base + "/healthz")
+	if err == nil {
+		t.Error("the server still accepts requests after shutting down")
+	}
+}
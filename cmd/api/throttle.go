@@ -0,0 +1,347 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoginLimits controls how failed logins slow down further attempts. Every failure for an
+// email address doubles the wait before the next attempt, starting at BaseDelay, and after
+// MaxAccountFailures the address is locked for Lockout. A client IP is locked after
+// MaxIPFailures, whichever addresses it tried. Unknown addresses are throttled like real
+// ones, so the responses do not reveal which accounts exist; their counters are pruned once
+// stale, like all others.
+type LoginLimits struct {
+	MaxAccountFailures int
+	MaxIPFailures      int
+	BaseDelay          time.Duration
+	Lockout            time.Duration
+	// ResetAfter is how long after its last failure a counter starts over.
+	ResetAfter time.Duration
+}
+
+var errTooManyLogins = repository.TooManyRequests("too_many_logins", "too many failed login attempts, try again later")
+
+func accountThrottleKey(email string) string {
+	return "account:" + models.NormalizeEmail(email)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// TrustedProxies are the reverse proxies in front of the server, whose forwarding headers
+// name the client.
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR ranges.
+func ParseTrustedProxies(list []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+
+	for _, s := range list {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR range", s)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+func (p TrustedProxies) contains(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address the request came from. Forwarding headers are only believed
+// when the connection comes from a trusted proxy, since any client can set them: the client
+// is then the last address in X-Forwarded-For that is not a trusted proxy itself, or
+// X-Real-IP if there is no X-Forwarded-For.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remote := net.ParseIP(host)
+	if remote == nil || !p.contains(remote) {
+		return host
+	}
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+
+	if len(forwarded) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+		return host
+	}
+
+	// each proxy appends the address it got the request from, so walk back from our own
+	// proxy until an address was not added by one of them
+	client := remote
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !p.contains(ip) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// clientIP returns the address the request came from, see TrustedProxies.ClientIP.
+func (app *application) clientIP(r *http.Request) string {
+	return app.trustedProxies.ClientIP(r)
+}
+
+// accountPolicy is the throttle of an email address: exponential backoff, then a lockout.
+func (l LoginLimits) accountPolicy() models.LoginThrottlePolicy {
+	return models.LoginThrottlePolicy{BaseDelay: l.BaseDelay, MaxAttempts: l.MaxAccountFailures, Lockout: l.Lockout}
+}
+
+// clientPolicy is the throttle of a client address: a lockout only, so that users behind a
+// shared address are not slowed down.
+func (l LoginLimits) clientPolicy() models.LoginThrottlePolicy {
+	return models.LoginThrottlePolicy{MaxAttempts: l.MaxIPFailures, Lockout: l.Lockout}
+}
+
+// loginAttempt is a login attempt reserved by reserveLogin, with the counters of the email
+// address and the client after it.
+type loginAttempt struct {
+	email   string
+	ip      string
+	account *models.LoginThrottle
+	client  *models.LoginThrottle
+}
+
+// reserveLogin counts a login attempt for email from ip before the password or code is
+// checked, so that parallel guesses are throttled like one after the other. If the account
+// or the client is locked, nothing is counted and the time the lock ends is returned
+// instead. Every reserved attempt ends in loginFailed, loginSucceeded or releaseLogin.
+func (app *application) reserveLogin(ctx context.Context, email, ip string) (*loginAttempt, time.Time, error) {
+	limits := app.loginLimits
+	now := time.Now()
+	resetBefore := now.Add(-limits.ResetAfter)
+
+	account, ok, err := app.DB.ReserveLoginAttempt(ctx, accountThrottleKey(email), now, resetBefore, limits.accountPolicy())
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if !ok {
+		return nil, account.LockedUntil, nil
+	}
+
+	client, ok, err := app.DB.ReserveLoginAttempt(ctx, ipThrottleKey(ip), now, resetBefore, limits.clientPolicy())
+	if err == nil && !ok {
+		err = app.DB.ReleaseLoginAttempt(ctx, accountThrottleKey(email), account.LockedUntil, now)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return nil, client.LockedUntil, nil
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return &loginAttempt{email: email, ip: ip, account: account, client: client}, time.Time{}, nil
+}
+
+// loginFailed keeps the attempt counted and writes the audit event when it locked the
+// account or the client. userID is zero if no user has the email address.
+func (app *application) loginFailed(ctx context.Context, attempt *loginAttempt, userID int) {
+	limits := app.loginLimits
+
+	if attempt.account.Failures == limits.MaxAccountFailures {
+		app.audit(ctx, models.AuditEvent{
+			Event:  models.AuditLoginLocked,
+			UserID: userID,
+			Email:  models.NormalizeEmail(attempt.email),
+			IP:     attempt.ip,
+			Detail: fmt.Sprintf("account locked for %s after %d failed logins", limits.Lockout, attempt.account.Failures),
+		})
+	}
+
+	if attempt.client.Failures == limits.MaxIPFailures {
+		app.audit(ctx, models.AuditEvent{
+			Event:  models.AuditLoginLocked,
+			IP:     attempt.ip,
+			Detail: fmt.Sprintf("client locked for %s after %d failed logins", limits.Lockout, attempt.client.Failures),
+		})
+	}
+}
+
+// loginSucceeded ends the backoff of the account, but not of the client, whose attempt is
+// taken back.
+func (app *application) loginSucceeded(ctx context.Context, attempt *loginAttempt) {
+	err := app.DB.ClearLoginThrottle(ctx, accountThrottleKey(attempt.email))
+	if err != nil {
+		app.logger.ErrorContext(ctx, "clearing the login throttle", "error", err)
+	}
+
+	app.releaseClient(ctx, attempt)
+}
+
+// releaseLogin takes the attempt back without ending the backoff of earlier failures, for a
+// right password that is not the whole login yet, such as the first step of a 2FA login.
+func (app *application) releaseLogin(ctx context.Context, attempt *loginAttempt) {
+	err := app.DB.ReleaseLoginAttempt(ctx, accountThrottleKey(attempt.email), attempt.account.LockedUntil, attempt.account.LastFailureAt)
+	if err != nil {
+		app.logger.ErrorContext(ctx, "releasing a login attempt", "error", err)
+	}
+
+	app.releaseClient(ctx, attempt)
+}
+
+func (app *application) releaseClient(ctx context.Context, attempt *loginAttempt) {
+	err := app.DB.ReleaseLoginAttempt(ctx, ipThrottleKey(attempt.ip), attempt.client.LockedUntil, attempt.client.LastFailureAt)
+	if err != nil {
+		app.logger.ErrorContext(ctx, "releasing a login attempt", "error", err)
+	}
+}
+
+// confirmPassword checks the password of the authenticated user for a request that has to
+// confirm it. The check counts towards the login throttle of the account, so that a stolen
+// access token cannot be used to guess the password. Unless the password is right, it sends
+// wrong or the throttle error and returns false.
+func (app *application) confirmPassword(w http.ResponseWriter, r *http.Request, user *models.User, password string, wrong error) bool {
+	attempt, allowedAt, err := app.reserveLogin(r.Context(), user.Email, app.clientIP(r))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return false
+	}
+	if attempt == nil {
+		app.tooManyLogins(w, allowedAt)
+		return false
+	}
+
+	valid, err := user.PasswordMatches(password)
+	if err != nil || !valid {
+		app.loginFailed(r.Context(), attempt, user.ID)
+		app.errorJSON(w, wrong)
+		return false
+	}
+
+	app.releaseLogin(r.Context(), attempt)
+	return true
+}
+
+// throttlePruneInterval is how often stale login counters are deleted.
+const throttlePruneInterval = 10 * time.Minute
+
+// pruneLoginThrottles deletes stale login counters every throttlePruneInterval until ctx is
+// done. Failed logins for addresses nobody has are counted too, so without pruning the table
+// would keep every address an attacker ever tried.
+func (app *application) pruneLoginThrottles(ctx context.Context) {
+	ticker := time.NewTicker(throttlePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		n, err := app.DB.PruneLoginThrottles(ctx, now.Add(-app.loginLimits.ResetAfter), now)
+		if err != nil {
+			app.logger.ErrorContext(ctx, "pruning login throttles", "error", err)
+			continue
+		}
+		app.logger.DebugContext(ctx, "pruned login throttles", "count", n)
+	}
+}
+
+// tooManyLogins rejects a login attempt made before allowedAt.
+func (app *application) tooManyLogins(w http.ResponseWriter, allowedAt time.Time) {
+	wait := time.Until(allowedAt)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	app.errorJSON(w, errTooManyLogins)
+}
+
+// audit records a security event in the audit log and the application log.
+func (app *application) audit(ctx context.Context, event models.AuditEvent) {
+	event.CreatedAt = time.Now()
+
+	app.logger.LogAttrs(ctx, slog.LevelInfo, "audit", slog.Group("audit",
+		slog.String("event", event.Event),
+		slog.Int("user_id", event.UserID),
+		slog.Int("actor_id", event.ActorID),
+		slog.String("email", event.Email),
+		slog.String("ip", event.IP),
+		slog.String("detail", event.Detail),
+	))
+
+	err := app.DB.InsertAuditEvent(ctx, event)
+	if err != nil {
+		app.logger.ErrorContext(ctx, "writing the audit log", "error", err)
+	}
+}
+
+// unlockLogin lifts the login lockout and backoff of a user's email address.
+func (app *application) unlockLogin(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.ClearLoginThrottle(r.Context(), accountThrottleKey(user.Email))
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	adminID, _ := app.contextGetUserID(r)
+
+	app.audit(r.Context(), models.AuditEvent{
+		Event:   models.AuditLoginUnlocked,
+		UserID:  user.ID,
+		ActorID: adminID,
+		Email:   user.Email,
+		IP:      app.clientIP(r),
+	})
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "login unlocked",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"backend/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8:ffff::/48"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		proxies TrustedProxies
+		remote  string
+		header  http.Header
+		want    string
+	}{
+		{"no proxies", nil, "203.0.113.7:4000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"untrusted remote", proxies, "203.0.113.7:4000", http.Header{"X-Forwarded-For": {"198.51.100.1"}, "X-Real-Ip": {"198.51.100.2"}}, "203.0.113.7"},
+		{"trusted proxy without headers", proxies, "10.0.0.1:4000", nil, "10.0.0.1"},
+		{"single trusted address", proxies, "192.0.2.1:4000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"address next to the trusted one", proxies, "192.0.2.2:4000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "192.0.2.2"},
+		{"forwarded for", proxies, "10.0.0.1:4000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed addresses before the client", proxies, "10.0.0.1:4000", http.Header{"X-Forwarded-For": {"1.1.1.1, 198.51.100.1, 10.0.0.2"}}, "198.51.100.1"},
+		{"several headers", proxies, "10.0.0.1:4000", http.Header{"X-Forwarded-For": {"1.1.1.1", "198.51.100.1,10.0.0.2"}}, "198.51.100.1"},
+		{"only proxies", proxies, "10.0.0.1:4000", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"garbage after the client", proxies, "10.0.0.1:4000", http.Header{"X-Forwarded-For": {"198.51.100.1, nonsense"}}, "10.0.0.1"},
+		{"real ip", proxies, "10.0.0.1:4000", http.Header{"X-Real-Ip": {"198.51.100.1"}}, "198.51.100.1"},
+		{"garbage real ip", proxies, "10.0.0.1:4000", http.Header{"X-Real-Ip": {"nonsense"}}, "10.0.0.1"},
+		{"ipv6 proxy", proxies, "[2001:db8:ffff::1]:4000", http.Header{"X-Forwarded-For": {"2001:db8::5"}}, "2001:db8::5"},
+		{"ipv6 client", proxies, "[2001:db8::5]:4000", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "2001:db8::5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			req.Header = tt.header
+
+			if got := tt.proxies.ClientIP(req); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, proxy := range []string{"proxy.example.com", "10.0.0.0/33", ""} {
+		_, err := ParseTrustedProxies([]string{proxy})
+		if err == nil {
+			t.Errorf("%q was accepted", proxy)
+		}
+	}
+}
+
+func TestLoginThrottleFollowsForwardedClient(t *testing.T) {
+	app := newTestApp(t)
+	app.loginLimits.MaxIPFailures = 2
+
+	// httptest requests come from 192.0.2.1
+	var err error
+	app.trustedProxies, err = ParseTrustedProxies([]string{"192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login := func(email, client string) *httptest.ResponseRecorder {
+		payload := map[string]string{"email": email, "password": "wrong"}
+		return doRequest(t, app, http.MethodPost, "/authenticate", payload, http.Header{"X-Forwarded-For": {client}})
+	}
+
+	// different addresses, so only the client counter adds up
+	checkProblem(t, login("a@example.com", "198.51.100.1"), http.StatusBadRequest, "invalid_credentials")
+	checkProblem(t, login("b@example.com", "198.51.100.1"), http.StatusBadRequest, "invalid_credentials")
+
+	rr := login("c@example.com", "198.51.100.1")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("locked client: got %d, body %s", rr.Code, rr.Body)
+	}
+
+	// another client behind the same proxy is not affected
+	checkProblem(t, login("c@example.com", "198.51.100.2"), http.StatusBadRequest, "invalid_credentials")
+}
+
+func TestConcurrentLoginsAreThrottled(t *testing.T) {
+	app := newTestApp(t)
+	// no backoff, so only the lockout holds the guesses back
+	app.loginLimits.BaseDelay = 0
+	app.loginLimits.MaxAccountFailures = 3
+	addTestUser(t, app, "target@example.com", models.RoleViewer)
+
+	const guesses = 20
+	codes := make(chan int, guesses)
+
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payload := map[string]string{"email": "target@example.com", "password": "wrong"}
+			codes <- doRequest(t, app, http.MethodPost, "/authenticate", payload, nil).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	// only the guesses that got past the throttle had their password compared
+	compared := 0
+	for code := range codes {
+		switch code {
+		case http.StatusBadRequest:
+			compared++
+		case http.StatusTooManyRequests:
+		default:
+			t.Errorf("got %d", code)
+		}
+	}
+	if compared > app.loginLimits.MaxAccountFailures {
+		t.Errorf("%d guesses were compared, want at most %d", compared, app.loginLimits.MaxAccountFailures)
+	}
+}
+
+func TestPasswordConfirmationsAreThrottled(t *testing.T) {
+	app := newTestApp(t)
+	app.loginLimits.BaseDelay = 0
+	app.loginLimits.MaxAccountFailures = 2
+
+	user := addTestUser(t, app, "stolen@example.com", models.RoleViewer)
+	auth := bearer(loginTokens(t, app, user).Token)
+
+	deleteMe := func(password string) *httptest.ResponseRecorder {
+		return doRequest(t, app, http.MethodDelete, "/me/", map[string]string{"password": password}, auth)
+	}
+
+	// a stolen access token does not allow guessing the password without limit
+	checkProblem(t, deleteMe("guess 1"), http.StatusForbidden, "incorrect_password")
+	checkProblem(t, deleteMe("guess 2"), http.StatusForbidden, "incorrect_password")
+	checkProblem(t, deleteMe(testPassword), http.StatusTooManyRequests, "too_many_logins")
+
+	// and the lockout holds for logins as well
+	payload := map[string]string{"email": user.Email, "password": testPassword}
+	checkProblem(t, doRequest(t, app, http.MethodPost, "/authenticate", payload, nil), http.StatusTooManyRequests, "too_many_logins")
+}
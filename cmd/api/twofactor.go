@@ -0,0 +1,283 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/totp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	errInvalidCode = repository.Validation("invalid_code", "invalid code")
+	// errInvalidChallenge does not tell why the challenge token was refused; the cause is
+	// wrapped for the logs.
+	errInvalidChallenge = repository.Unauthorized("invalid_challenge_token", "invalid or expired challenge token")
+	errTOTPEnabled      = repository.Conflict("totp_enabled", "two-factor authentication is already enabled")
+	errTOTPNotEnrolled  = repository.Validation("totp_not_enrolled", "two-factor authentication has not been enrolled")
+	errAdminNeedsTOTP   = repository.Forbidden("admin_totp_required", "admin accounts must keep two-factor authentication")
+)
+
+// enrollTOTP starts 2FA enrollment for the authenticated user: it creates a TOTP secret and
+// recovery codes, which are only shown in this response. 2FA is enabled once verifyTOTP
+// accepts a code from the authenticator.
+func (app *application) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		Password string `json:"password"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.confirmPassword(w, r, user, requestPayload.Password, errIncorrectPassword) {
+		return
+	}
+
+	// replacing an active secret would switch 2FA off until the new one is verified
+	if user.TOTPEnabled {
+		app.errorJSON(w, errTOTPEnabled)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	codes, hashes, err := models.NewRecoveryCodes()
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.EnrollUserTOTP(r.Context(), user.ID, secret, hashes)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := struct {
+		Secret        string   `json:"secret"`
+		URI           string   `json:"otpauth_uri"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		Secret:        secret,
+		URI:           totp.URI(app.auth.Issuer, user.Email, secret),
+		RecoveryCodes: codes,
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// verifyTOTP enables 2FA once the user proves their authenticator produces valid codes.
+func (app *application) verifyTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		Code string `json:"code"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		app.errorJSON(w, errTOTPNotEnrolled)
+		return
+	}
+	if user.TOTPEnabled {
+		app.errorJSON(w, errTOTPEnabled)
+		return
+	}
+
+	ok, err := app.checkTOTP(r, user, requestPayload.Code)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		app.errorJSON(w, errInvalidCode)
+		return
+	}
+
+	err = app.DB.EnableUserTOTP(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "two-factor authentication enabled; log in again to use it",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// disableTOTP switches 2FA off. It needs the password and a current code, and is not
+// available to admins, who must keep 2FA.
+func (app *application) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if user.Role == models.RoleAdmin {
+		app.errorJSON(w, errAdminNeedsTOTP)
+		return
+	}
+
+	if !app.confirmPassword(w, r, user, requestPayload.Password, errIncorrectPassword) {
+		return
+	}
+
+	if user.TOTPEnabled {
+		ok, err := app.checkTOTP(r, user, requestPayload.Code)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			app.errorJSON(w, repository.Forbidden(errInvalidCode.Code, errInvalidCode.Message))
+			return
+		}
+	}
+
+	err = app.DB.DisableUserTOTP(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "two-factor authentication disabled",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// authenticate2FA is the second step of logging in with 2FA: it exchanges the challenge token
+// from authenticate and a TOTP or recovery code for a token pair. Failed codes count
+// towards the login lockout like wrong passwords.
+func (app *application) authenticate2FA(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	claims, err := app.auth.ValidateToken(requestPayload.ChallengeToken, tokenUseChallenge)
+	if err != nil {
+		app.errorJSON(w, errInvalidChallenge.Wrap(err))
+		return
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		app.errorJSON(w, errInvalidChallenge.Wrap(ErrTokenMalformed))
+		return
+	}
+
+	user, err := app.DB.GetUserById(r.Context(), userID)
+	if err != nil || !user.TOTPEnabled {
+		app.errorJSON(w, errInvalidChallenge)
+		return
+	}
+
+	ip := app.clientIP(r)
+
+	attempt, allowedAt, err := app.reserveLogin(r.Context(), user.Email, ip)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	if attempt == nil {
+		app.metrics.CountLogin("totp", "throttled")
+		app.tooManyLogins(w, allowedAt)
+		return
+	}
+
+	ok, err := app.checkTOTP(r, user, requestPayload.Code)
+	if err != nil {
+		app.releaseLogin(r.Context(), attempt)
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// not a current code, so maybe a recovery code
+	if !ok {
+		ok, err = app.DB.UseRecoveryCode(r.Context(), user.ID, models.HashRecoveryCode(requestPayload.Code))
+		if err != nil {
+			app.releaseLogin(r.Context(), attempt)
+			app.errorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if !ok {
+		app.metrics.CountLogin("totp", "failure")
+		app.loginFailed(r.Context(), attempt, user.ID)
+		app.errorJSON(w, errInvalidCode)
+		return
+	}
+
+	app.loginSucceeded(r.Context(), attempt)
+
+	tokens, err := app.issueTokens(r.Context(), user, "")
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, app.auth.GetRefreshCookie(tokens.RefreshToken))
+
+	app.metrics.CountLogin("totp", "success")
+	_ = app.writeJSON(w, http.StatusAccepted, tokens)
+}
+
+// checkTOTP reports whether code is a valid TOTP code for the user that has not been used
+// before.
+func (app *application) checkTOTP(r *http.Request, user *models.User, code string) (bool, error) {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// a code is only good once, even within its time step
+	return app.DB.UpdateUserTOTPStep(r.Context(), user.ID, step)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/totp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enrollTestTOTP enrolls and verifies 2FA for user through the /me/2fa routes, and returns
+// the secret, the recovery codes and the time step of the code that verified it.
+func enrollTestTOTP(t *testing.T, app *application, user *models.User) (string, []string, int64) {
+	t.Helper()
+	auth := bearer(loginTokens(t, app, user).Token)
+
+	rr := doRequest(t, app, http.MethodPost, "/me/2fa/enroll", map[string]string{"password": testPassword}, auth)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("enrolling: got %d, body %s", rr.Code, rr.Body)
+	}
+
+	var enrollment struct {
+		Secret        string   `json:"secret"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decodeBody(t, rr, &enrollment)
+
+	step := totp.Step(time.Now())
+	rr = doRequest(t, app, http.MethodPost, "/me/2fa/verify", map[string]string{"code": testCode(t, enrollment.Secret, step)}, auth)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("verifying: got %d, body %s", rr.Code, rr.Body)
+	}
+
+	return enrollment.Secret, enrollment.RecoveryCodes, step
+}
+
+func testCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// login2FA logs user in with the password and then code, and returns the response of the
+// second step.
+func login2FA(t *testing.T, app *application, user *models.User, code string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rr := doRequest(t, app, http.MethodPost, "/authenticate", map[string]string{"email": user.Email, "password": testPassword}, nil)
+	var challenge struct {
+		ChallengeToken string `json:"challenge_token"`
+	}
+	decodeBody(t, rr, &challenge)
+	if challenge.ChallengeToken == "" {
+		t.Fatalf("no challenge: got %d, body %s", rr.Code, rr.Body)
+	}
+
+	return doRequest(t, app, http.MethodPost, "/authenticate/2fa", map[string]string{"challenge_token": challenge.ChallengeToken, "code": code}, nil)
+}
+
+func TestTOTPCodesAreSingleUse(t *testing.T) {
+	app := newTestApp(t)
+	// failed codes must not make the next attempt wait
+	app.loginLimits.BaseDelay = time.Nanosecond
+
+	user := addTestUser(t, app, "totp@example.com", models.RoleViewer)
+	secret, _, step := enrollTestTOTP(t, app, user)
+
+	tests := []struct {
+		name   string
+		code   string
+		status int
+	}{
+		{"the code that enabled 2FA", testCode(t, secret, step), http.StatusBadRequest},
+		{"the next code", testCode(t, secret, step+1), http.StatusAccepted},
+		{"the same code again", testCode(t, secret, step+1), http.StatusBadRequest},
+		{"an earlier code that was never used", testCode(t, secret, step-1), http.StatusBadRequest},
+		{"a code too far ahead", testCode(t, secret, step+3), http.StatusBadRequest},
+		{"not a code", "abcdef", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := login2FA(t, app, user, tt.code)
+			if tt.status == http.StatusBadRequest {
+				checkProblem(t, rr, tt.status, "invalid_code")
+			} else if rr.Code != tt.status {
+				t.Errorf("status: got %d, want %d (body %s)", rr.Code, tt.status, rr.Body)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	app := newTestApp(t)
+	app.loginLimits.BaseDelay = time.Nanosecond
+
+	user := addTestUser(t, app, "recovery@example.com", models.RoleViewer)
+	_, codes, _ := enrollTestTOTP(t, app, user)
+	if len(codes) != models.RecoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), models.RecoveryCodeCount)
+	}
+
+	other := addTestUser(t, app, "other@example.com", models.RoleViewer)
+	_, otherCodes, _ := enrollTestTOTP(t, app, other)
+
+	tests := []struct {
+		name   string
+		code   string
+		status int
+	}{
+		{"a recovery code", codes[0], http.StatusAccepted},
+		{"the same code again", codes[0], http.StatusBadRequest},
+		{"another code written differently", strings.ToUpper(strings.ReplaceAll(codes[1], "-", " ")), http.StatusAccepted},
+		{"a code of another user", otherCodes[0], http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := login2FA(t, app, user, tt.code)
+			if tt.status == http.StatusBadRequest {
+				checkProblem(t, rr, tt.status, "invalid_code")
+			} else if rr.Code != tt.status {
+				t.Errorf("status: got %d, want %d (body %s)", rr.Code, tt.status, rr.Body)
+			}
+		})
+	}
+
+	// the other user's code was not used up by the attempt
+	rr := login2FA(t, app, other, otherCodes[0])
+	if rr.Code != http.StatusAccepted {
+		t.Errorf("other user's code: got %d, body %s", rr.Code, rr.Body)
+	}
+}
+
+func TestAdminNeedsTOTPForAdminRoutes(t *testing.T) {
+	app := newTestApp(t)
+	admin := addTestUser(t, app, "new-admin@example.com", models.RoleAdmin)
+
+	rr := doRequest(t, app, http.MethodGet, "/admin/movies", nil, bearer(loginTokens(t, app, admin).Token))
+	checkProblem(t, rr, http.StatusForbidden, "mfa_required")
+
+	secret, _, step := enrollTestTOTP(t, app, admin)
+	rr = login2FA(t, app, admin, testCode(t, secret, step+1))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("login: got %d, body %s", rr.Code, rr.Body)
+	}
+
+	var tokens TokenPairs
+	decodeBody(t, rr, &tokens)
+
+	rr = doRequest(t, app, http.MethodGet, "/admin/movies", nil, bearer(tokens.Token))
+	if rr.Code != http.StatusOK {
+		t.Errorf("after 2FA: got %d, body %s", rr.Code, rr.Body)
+	}
+}
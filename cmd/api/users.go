@@ -0,0 +1,350 @@
+package main
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// userProfile is the part of models.User that is shown to the user themselves.
+type userProfile struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+}
+
+func newUserProfile(user *models.User) userProfile {
+	return userProfile{
+		ID:        user.ID,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+		Role:      user.Role,
+
+		TwoFactorEnabled: user.TOTPEnabled,
+	}
+}
+
+var (
+	errUnknownUser  = repository.Unauthorized("unknown_user", "unknown user")
+	errNameRequired = repository.Validation("invalid_name", "first_name and last_name are required")
+	// errIncorrectPassword is for requests that must confirm the password, and are refused
+	// rather than unauthenticated when it is wrong.
+	errIncorrectPassword        = repository.Forbidden("incorrect_password", "password is incorrect")
+	errIncorrectCurrentPassword = repository.Forbidden("incorrect_password", "current password is incorrect")
+	errInvalidRole              = repository.Validation("invalid_role", fmt.Sprintf("role must be one of %s, %s or %s", models.RoleAdmin, models.RoleEditor, models.RoleViewer))
+)
+
+// register creates a new user account.
+func (app *application) register(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user := models.User{
+		FirstName: strings.TrimSpace(requestPayload.FirstName),
+		LastName:  strings.TrimSpace(requestPayload.LastName),
+		Email:     models.NormalizeEmail(requestPayload.Email),
+		Role:      models.RoleViewer,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	// validate the payload
+	if user.FirstName == "" || user.LastName == "" {
+		app.errorJSON(w, errNameRequired)
+		return
+	}
+
+	err = models.ValidateEmail(user.Email)
+	if err != nil {
+		app.errorJSON(w, repository.Validation("invalid_email", err.Error()))
+		return
+	}
+
+	err = models.ValidatePassword(requestPayload.Password, user.Email)
+	if err != nil {
+		app.errorJSON(w, repository.Validation("invalid_password", err.Error()))
+		return
+	}
+
+	err = user.SetPassword(requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// the unique index on email settles races between two registrations of the same address
+	user.ID, err = app.DB.InsertUser(r.Context(), user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "user registered",
+		Data:    newUserProfile(&user),
+	}
+
+	_ = app.writeJSON(w, http.StatusCreated, resp)
+}
+
+// currentUser loads the user authenticated by authRequired.
+func (app *application) currentUser(r *http.Request) (*models.User, error) {
+	userID, ok := app.contextGetUserID(r)
+	if !ok {
+		return nil, errUnauthorized
+	}
+
+	user, err := app.DB.GetUserById(r.Context(), userID)
+	if err != nil {
+		return nil, errUnknownUser
+	}
+
+	return user, nil
+}
+
+// getMe returns the profile of the authenticated user.
+func (app *application) getMe(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, newUserProfile(user))
+}
+
+// updateMe changes the name and/or email address of the authenticated user. Fields left out
+// of the payload are not changed. A new email address needs the current password, since
+// password reset links go to it: otherwise a leaked access token could take the account over.
+func (app *application) updateMe(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		FirstName *string `json:"first_name"`
+		LastName  *string `json:"last_name"`
+		Email     *string `json:"email"`
+
+		CurrentPassword string `json:"current_password"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if requestPayload.FirstName != nil {
+		user.FirstName = strings.TrimSpace(*requestPayload.FirstName)
+	}
+	if requestPayload.LastName != nil {
+		user.LastName = strings.TrimSpace(*requestPayload.LastName)
+	}
+	email := user.Email
+	if requestPayload.Email != nil {
+		email = models.NormalizeEmail(*requestPayload.Email)
+	}
+	emailChanged := email != user.Email
+
+	if user.FirstName == "" || user.LastName == "" {
+		app.errorJSON(w, errNameRequired)
+		return
+	}
+
+	err = models.ValidateEmail(email)
+	if err != nil {
+		app.errorJSON(w, repository.Validation("invalid_email", err.Error()))
+		return
+	}
+
+	// throttled under the current address, which is the one logins use
+	if emailChanged && !app.confirmPassword(w, r, user, requestPayload.CurrentPassword, errIncorrectCurrentPassword) {
+		return
+	}
+	user.Email = email
+
+	user.UpdatedAt = time.Now()
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		err := repo.UpdateUser(r.Context(), *user)
+		if err != nil || !emailChanged {
+			return err
+		}
+
+		// reset links sent to the old address stop working
+		return repo.DeleteUserPasswordResets(r.Context(), user.ID)
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, newUserProfile(user))
+}
+
+// changePassword sets a new password for the authenticated user, who must also supply the
+// current one. All of the user's sessions and password reset tokens end with it.
+func (app *application) changePassword(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.confirmPassword(w, r, user, requestPayload.CurrentPassword, errIncorrectCurrentPassword) {
+		return
+	}
+
+	err = models.ValidatePassword(requestPayload.NewPassword, user.Email)
+	if err != nil {
+		app.errorJSON(w, repository.Validation("invalid_password", err.Error()))
+		return
+	}
+
+	err = user.SetPassword(requestPayload.NewPassword)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.WithTx(r.Context(), func(repo repository.DatabaseRepo) error {
+		err := repo.UpdateUserPassword(r.Context(), user.ID, user.Password)
+		if err != nil {
+			return err
+		}
+
+		// sessions started with the old password end here, and so do reset links sent
+		// before
+		err = repo.RevokeUserRefreshTokens(r.Context(), user.ID)
+		if err != nil {
+			return err
+		}
+
+		return repo.DeleteUserPasswordResets(r.Context(), user.ID)
+	})
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "password changed",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// deleteMe deletes the authenticated user's account. The current password is required, so
+// that a leaked access token alone cannot delete an account.
+func (app *application) deleteMe(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	var requestPayload struct {
+		Password string `json:"password"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !app.confirmPassword(w, r, user, requestPayload.Password, errIncorrectPassword) {
+		return
+	}
+
+	err = app.DB.DeleteUser(r.Context(), user.ID)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// log the browser out as well
+	http.SetCookie(w, app.auth.GetExpiredRefreshCookie())
+
+	resp := JSONResponse{
+		Error:   false,
+		Message: "account deleted",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, resp)
+}
+
+// setUserRole changes the role of any user. The user's current access token keeps the old
+// role until it is refreshed.
+func (app *application) setUserRole(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var requestPayload struct {
+		Role string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !models.ValidRole(requestPayload.Role) {
+		app.errorJSON(w, errInvalidRole)
+		return
+	}
+
+	user, err := app.DB.GetUserById(r.Context(), id)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.DB.UpdateUserRole(r.Context(), user.ID, requestPayload.Role)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	user.Role = requestPayload.Role
+
+	_ = app.writeJSON(w, http.StatusOK, newUserProfile(user))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"backend/internal/models"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpdateMeEmailNeedsPassword(t *testing.T) {
+	app := newTestApp(t)
+	// wrong passwords back the account off; keep that out of the way of the next case
+	app.loginLimits.BaseDelay = time.Nanosecond
+	user := addTestUser(t, app, "me@example.com", models.RoleViewer)
+	auth := bearer(loginTokens(t, app, user).Token)
+
+	_, reset, err := models.NewPasswordReset(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.DB.InsertPasswordReset(context.Background(), reset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		payload map[string]string
+		status  int
+		email   string
+	}{
+		{"name only", map[string]string{"first_name": "New"}, http.StatusOK, "me@example.com"},
+		{"same email in another case", map[string]string{"email": "ME@example.com"}, http.StatusOK, "me@example.com"},
+		{"email without the password", map[string]string{"email": "thief@example.com"}, http.StatusForbidden, "me@example.com"},
+		{"email with a wrong password", map[string]string{"email": "thief@example.com", "current_password": "wrong"}, http.StatusForbidden, "me@example.com"},
+		{"email with the password", map[string]string{"email": "new@example.com", "current_password": testPassword}, http.StatusOK, "new@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, app, http.MethodPatch, "/me/", tt.payload, auth)
+			if rr.Code != tt.status {
+				t.Errorf("status: got %d, want %d (body %s)", rr.Code, tt.status, rr.Body)
+			}
+
+			stored, err := app.DB.GetUserById(context.Background(), user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Email != tt.email {
+				t.Errorf("email: got %q, want %q", stored.Email, tt.email)
+			}
+		})
+	}
+
+	// the reset link that went to the old address no longer works
+	_, err = app.DB.UsePasswordReset(context.Background(), reset.Hash)
+	if err == nil {
+		t.Error("a password reset survived the email change")
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	app := newTestApp(t)
+	user := addTestUser(t, app, "me@example.com", models.RoleViewer)
+	tokens := loginTokens(t, app, user)
+
+	_, reset, err := models.NewPasswordReset(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.DB.InsertPasswordReset(context.Background(), reset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := map[string]string{"current_password": testPassword, "new_password": "a brand new passphrase 2"}
+
+	// a failure half way leaves the old password, sessions and reset links in place
+	db := app.DB
+	app.DB = failingRepo{DatabaseRepo: db, fail: map[string]bool{"DeleteUserPasswordResets": true}}
+	rr := doRequest(t, app, http.MethodPost, "/me/password", payload, bearer(tokens.Token))
+	app.DB = db
+	checkProblem(t, rr, http.StatusInternalServerError, "internal_server_error")
+
+	stored, err := app.DB.GetUserById(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := stored.PasswordMatches(testPassword); !ok {
+		t.Error("the password changed although the request failed")
+	}
+	if rr, _ := refresh(t, app, tokens.RefreshToken); rr.Code != http.StatusOK {
+		t.Fatalf("the session ended although the request failed: %d %s", rr.Code, rr.Body)
+	}
+
+	tokens = loginTokens(t, app, user)
+	rr = doRequest(t, app, http.MethodPost, "/me/password", payload, bearer(tokens.Token))
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("got %d, body %s", rr.Code, rr.Body)
+	}
+
+	stored, err = app.DB.GetUserById(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := stored.PasswordMatches(payload["new_password"]); !ok {
+		t.Error("the password did not change")
+	}
+	if rr, _ := refresh(t, app, tokens.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refresh after the change: got %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	_, err = app.DB.UsePasswordReset(context.Background(), reset.Hash)
+	if err == nil {
+		t.Error("a password reset survived the password change")
+	}
+}
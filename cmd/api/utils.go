@@ -1,41 +1,69 @@
 package main
 
 import (
+	"backend/internal/logging"
+	"backend/internal/repository"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// errInvalidID is the error for an {id} URL parameter that is not an id.
+var errInvalidID = repository.Validation("invalid_id", "id must be a positive integer")
+
+// invalidBody returns the error for a request body readJSON cannot decode. message must not
+// repeat any of the body back.
+func invalidBody(message string) error {
+	return repository.Validation("invalid_body", message)
+}
+
 type JSONResponse struct {
 	Error bool `json:"error"`
 	Message string `json:"message"`
 	Data interface{} `json:"data,omitempty"`
 }
 
+// writeJSON sends data as JSON. Errors are logged here, so handlers may ignore them: by the
+// time one happens there is nothing better to answer.
 func (app *application) writeJSON(w http.ResponseWriter, status int , data interface{}, headers ...http.Header) error{
 	out, err := json.Marshal(data)
 	if err != nil {
+		app.logger.ErrorContext(requestContext(w), "encoding the response", "error", err)
+		w.Header().Set("Content-Type","application/problem+json")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = io.WriteString(w, `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"internal server error","code":"internal_server_error","error":true,"message":"internal server error"}`)
 		return err
 	}	
 
+	// headers may override the content type
+	w.Header().Set("Content-Type","application/json")
+
 	if len(headers) > 0 {
 		for key, value := range headers[0] {
 			w.Header()[key] = value
 		}
 	}
 
-	w.Header().Set("Content-Type","application/json")
 	w.WriteHeader(status)
 	_, err = w.Write(out)
 
 	if err != nil {
+		app.logger.WarnContext(requestContext(w), "writing the response", "error", err)
 		return err
 	}
 
 	return nil
 }
 
+// readJSON decodes the request body into data. Its errors describe what is wrong with the
+// body in terms of the JSON document rather than the Go types it is decoded into.
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
 	maxBytes := 1024 * 1024 // 1 MB
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes)) //limit request body size to 1MB
@@ -46,18 +74,57 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data in
 
 	err := dec.Decode(data)
 	if err != nil {
-		return err
+		var syntaxError *json.SyntaxError
+		var typeError *json.UnmarshalTypeError
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return invalidBody(fmt.Sprintf("body contains badly-formed JSON (at character %d)", syntaxError.Offset))
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return invalidBody("body contains badly-formed JSON")
+		case errors.As(err, &typeError):
+			return invalidBody(fmt.Sprintf("body contains an invalid value (at character %d)", typeError.Offset))
+		case errors.Is(err, io.EOF):
+			return invalidBody("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			// the field name is the client's, so it is not repeated back
+			return invalidBody("body contains an unknown field")
+		case errors.As(err, &maxBytesError):
+			return invalidBody(fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit))
+		default:
+			// a time.Time field with a value that is not a date, for instance
+			return invalidBody("body contains an invalid value")
+		}
 	}
 
 	err = dec.Decode(&struct{}{})
 	if err != io.EOF {
 		// there is more than one json file
-		return errors.New("body must only contain a single JSON value")
+		return invalidBody("body must only contain a single JSON value")
 	}
 
 	return nil
 }
 
+// problem is an RFC 7807 problem details object. Code is a stable identifier of the problem
+// for clients to act on. Error and Message repeat what error responses used to carry, for
+// clients written against them.
+type problem struct {
+	Type string `json:"type"`
+	Title string `json:"title"`
+	Status int `json:"status"`
+	Detail string `json:"detail"`
+	Code string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	Error bool `json:"error"`
+	Message string `json:"message"`
+}
+
+// errorJSON sends err as an application/problem+json response. A domain error, such as
+// repository.ErrMovieNotFound, brings its own status, code and message, and its cause is not
+// shown. Other errors are sent with status, 400 by default, and a code named after it. The
+// details of server errors are logged rather than sent.
 func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) error {
 	statusCode := http.StatusBadRequest
 	
@@ -65,9 +132,98 @@ func (app *application) errorJSON(w http.ResponseWriter, err error, status ...in
 		statusCode = status[0]
 	}
 
-	var payload JSONResponse
-	payload.Error = true
-	payload.Message = err.Error()
+	// only domain errors have messages meant for clients; others may carry anything, even
+	// text from the request
+	detail := strings.ToLower(http.StatusText(statusCode))
+	code := ""
+
+	if domainErr := repository.ErrorOf(err); domainErr != nil {
+		statusCode = kindStatus(domainErr.Kind)
+		detail = domainErr.Message
+		code = domainErr.Code
+	}
+
+	if statusCode >= http.StatusInternalServerError {
+		app.logger.ErrorContext(requestContext(w), "request failed", "status", statusCode, "error", err)
+		detail = "internal server error"
+		code = ""
+	}
+
+	if code == "" {
+		code = strings.ToLower(strings.ReplaceAll(http.StatusText(statusCode), " ", "_"))
+	}
+
+	payload := problem{
+		Type: "about:blank",
+		Title: http.StatusText(statusCode),
+		Status: statusCode,
+		Detail: detail,
+		Code: code,
+		RequestID: logging.RequestID(requestContext(w)),
+		Error: true,
+		Message: detail,
+	}
 
-	return app.writeJSON(w, statusCode, payload)
-}
\ No newline at end of file
+	return app.writeJSON(w, statusCode, payload, http.Header{"Content-Type": {"application/problem+json"}})
+}
+
+// kindStatus returns the HTTP status of domain errors of kind k.
+func kindStatus(k repository.Kind) int {
+	switch k {
+	case repository.KindNotFound:
+		return http.StatusNotFound
+	case repository.KindConflict:
+		return http.StatusConflict
+	case repository.KindValidation:
+		return http.StatusBadRequest
+	case repository.KindUnauthorized:
+		return http.StatusUnauthorized
+	case repository.KindForbidden:
+		return http.StatusForbidden
+	case repository.KindTooManyRequests:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
+
+// readIDParam returns the {id} URL parameter, which must be a positive integer.
+func (app *application) readIDParam(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		return 0, errInvalidID
+	}
+
+	return id, nil
+}
+
+// readIntQuery returns the integer value of a query string parameter, or defaultValue if the parameter is absent.
+func (app *application) readIntQuery(qs url.Values, key string, defaultValue int) (int, error) {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return defaultValue, repository.Validation("invalid_query", key+" must be an integer")
+	}
+
+	return i, nil
+}
+
+// readCSVQuery returns the values of a query string parameter that may be repeated (?a=1&a=2),
+// comma separated (?a=1,2), or both.
+func (app *application) readCSVQuery(qs url.Values, key string) []string {
+	var values []string
+
+	for _, v := range qs[key] {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+
+	return values
+}
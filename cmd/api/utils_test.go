@@ -0,0 +1,61 @@
+package main
+
+import (
+	"backend/internal/repository"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorJSON(t *testing.T) {
+	app := newTestApp(t)
+
+	tests := []struct {
+		name   string
+		err    error
+		status []int
+		want   problem
+	}{
+		{"domain error", repository.Forbidden("nope", "you may not"), nil, problem{Status: http.StatusForbidden, Code: "nope", Detail: "you may not"}},
+		{"domain error wins over the status", repository.ErrMovieNotFound.Wrap(errors.New("sql: no rows")), []int{http.StatusInternalServerError}, problem{Status: http.StatusNotFound, Code: "movie_not_found", Detail: "movie not found"}},
+		{"untyped client error", errors.New("<script>alert(1)</script>"), nil, problem{Status: http.StatusBadRequest, Code: "bad_request", Detail: "bad request"}},
+		{"untyped error with a status", errors.New("echoed query"), []int{http.StatusUnauthorized}, problem{Status: http.StatusUnauthorized, Code: "unauthorized", Detail: "unauthorized"}},
+		{"server error", errors.New("pq: connection refused"), []int{http.StatusInternalServerError}, problem{Status: http.StatusInternalServerError, Code: "internal_server_error", Detail: "internal server error"}},
+		{"domain error at 5xx", repository.Validation("v", "not shown"), []int{http.StatusServiceUnavailable}, problem{Status: http.StatusBadRequest, Code: "v", Detail: "not shown"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			_ = app.errorJSON(rr, tt.err, tt.status...)
+
+			var got problem
+			decodeBody(t, rr, &got)
+			if rr.Code != tt.want.Status || got.Status != tt.want.Status || got.Code != tt.want.Code || got.Detail != tt.want.Detail || got.Message != tt.want.Detail {
+				t.Errorf("got %d %+v, want %+v", rr.Code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadJSONDoesNotEchoTheBody(t *testing.T) {
+	app := newTestApp(t)
+
+	for _, body := range []string{
+		`{"<script>": 1}`,
+		`{"email": ["<script>"]}`,
+		`{"email": "a@example.com"} {"<script>": 1}`,
+		`{"email": "<script>`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/authenticate", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+
+		checkProblem(t, rr, http.StatusBadRequest, "invalid_body")
+		if strings.Contains(rr.Body.String(), "script") {
+			t.Errorf("%s: the response repeats the body: %s", body, rr.Body)
+		}
+	}
+}
@@ -0,0 +1,564 @@
+// Package config loads the server configuration. Every option has a built-in default and can
+// be set, in increasing order of precedence, in a YAML or TOML config file, in an environment
+// variable and on the command line. The flag name is also the key in config files and, upper
+// cased with dashes turned into underscores, the name of the environment variable: -jwt-secret
+// is jwt-secret in a file and JWT_SECRET in the environment.
+package config
+
+import (
+	"backend/internal/cors"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Modes the server can run in. Development mode accepts insecure settings, such as the
+// default JWT secret, that production refuses. Production is the default, so a deployment
+// that forgets to set the mode cannot run with them.
+const (
+	ModeDev  = "dev"
+	ModeProd = "prod"
+)
+
+// DefaultJWTSecret is the JWT secret used when none is configured. It is only accepted in
+// development mode.
+const DefaultJWTSecret = "verysecret"
+
+// Config is the configuration of the API server.
+type Config struct {
+	Mode     string
+	Port     int
+	Domain   string
+	InMemory bool
+
+	Log struct {
+		Level  string
+		Format string
+	}
+
+	Trace struct {
+		// Exporter is where spans go: "otlp", "stdout" or "none".
+		Exporter string
+		// OTLPEndpoint is the collector's OTLP/HTTP address, such as localhost:4318. When
+		// empty the exporter reads the standard OTEL_EXPORTER_OTLP_* variables.
+		OTLPEndpoint string
+		// SampleRatio is the share of new traces recorded. Traces started upstream follow
+		// the caller's decision.
+		SampleRatio float64
+	}
+
+	HTTP struct {
+		ReadTimeout       time.Duration
+		ReadHeaderTimeout time.Duration
+		WriteTimeout      time.Duration
+		IdleTimeout       time.Duration
+		// ShutdownTimeout is how long in-flight requests get to finish on shutdown.
+		ShutdownTimeout time.Duration
+		// ShutdownDelay is how long the server keeps serving after reporting not-ready, so
+		// that load balancers stop sending it requests first.
+		ShutdownDelay time.Duration
+		// TrustedProxies are the addresses and CIDR ranges of reverse proxies whose
+		// X-Forwarded-For and X-Real-IP headers name the client.
+		TrustedProxies []string
+	}
+
+	DB struct {
+		DSN     string
+		Timeout time.Duration
+	}
+
+	JWT struct {
+		Secret          string
+		Keys            string
+		Issuer          string
+		Audience        string
+		Leeway          time.Duration
+		TokenExpiry     time.Duration
+		RefreshExpiry   time.Duration
+		ChallengeExpiry time.Duration
+	}
+
+	Cookie struct {
+		Domain string
+		Path   string
+		Name   string
+	}
+
+	CORS struct {
+		AllowedOrigins       []string
+		PublicAllowedOrigins []string
+		MaxAge               time.Duration
+	}
+
+	// TMDBAPIKey is the key for The Movie Database, which movie posters are fetched from.
+	TMDBAPIKey string
+
+	Health struct {
+		// CheckTimeout limits each dependency check of the readiness endpoint.
+		CheckTimeout time.Duration
+		// CheckTMDB makes readiness depend on reaching The Movie Database.
+		CheckTMDB bool
+	}
+
+	Metrics struct {
+		// Addr is the address /metrics is served on, apart from the API so that it is not
+		// public. Empty turns metrics off.
+		Addr string
+	}
+
+	Login struct {
+		MaxAccountFailures int
+		MaxIPFailures      int
+		BaseDelay          time.Duration
+		Lockout            time.Duration
+		ResetAfter         time.Duration
+	}
+
+	Mail struct {
+		SMTPHost         string
+		SMTPPort         int
+		SMTPUsername     string
+		SMTPPassword     string
+		From             string
+		Dir              string
+		PasswordResetURL string
+	}
+
+	OIDC struct {
+		Issuer       string
+		ClientID     string
+		ClientSecret string
+		RedirectURL  string
+		PostLoginURL string
+	}
+
+	// File is the config file the configuration was read from, if any.
+	File string
+
+	// sources records where each option's value came from, by flag name.
+	sources map[string]string
+}
+
+// secrets are the options that Print redacts.
+var secrets = map[string]bool{
+	"jwt-secret":         true,
+	"api-key":            true,
+	"smtp-password":      true,
+	"oidc-client-secret": true,
+}
+
+// Default returns the built-in configuration. Without a -dsn, the database user, password
+// and name are taken from DB_USER, DB_PASSWORD and DB_NAME.
+func Default() *Config {
+	c := &Config{Mode: ModeProd, Port: 8080, Domain: "example.com"}
+
+	c.Log.Level = "info"
+	c.Log.Format = "json"
+
+	c.Trace.Exporter = "none"
+	c.Trace.SampleRatio = 1
+
+	c.HTTP.ReadTimeout = 10 * time.Second
+	c.HTTP.ReadHeaderTimeout = 5 * time.Second
+	c.HTTP.WriteTimeout = 30 * time.Second
+	c.HTTP.IdleTimeout = 2 * time.Minute
+	c.HTTP.ShutdownTimeout = 20 * time.Second
+
+	c.DB.DSN = "host=localhost port=5432 user=" + os.Getenv("DB_USER") + " password=" + os.Getenv("DB_PASSWORD") +
+		" dbname=" + os.Getenv("DB_NAME") + " sslmode=disable timezone=UTC connect_timeout=5"
+	c.DB.Timeout = 3 * time.Second
+
+	c.JWT.Secret = DefaultJWTSecret
+	c.JWT.Issuer = "example.com"
+	c.JWT.Audience = "example.com"
+	c.JWT.Leeway = 30 * time.Second
+	c.JWT.TokenExpiry = 15 * time.Minute
+	c.JWT.RefreshExpiry = 24 * time.Hour
+	c.JWT.ChallengeExpiry = 5 * time.Minute
+
+	c.Cookie.Domain = "localhost"
+	c.Cookie.Path = "/"
+	c.Cookie.Name = "refresh_token"
+
+	c.CORS.AllowedOrigins = []string{"http://localhost:3000", "http://192.18.136.71"}
+	c.CORS.MaxAge = 10 * time.Minute
+
+	c.Health.CheckTimeout = 2 * time.Second
+
+	c.Metrics.Addr = "127.0.0.1:9090"
+
+	c.Login.MaxAccountFailures = 5
+	c.Login.MaxIPFailures = 50
+	c.Login.BaseDelay = time.Second
+	c.Login.Lockout = 15 * time.Minute
+	c.Login.ResetAfter = time.Hour
+
+	c.Mail.SMTPPort = 587
+	c.Mail.From = "Go Movies <no-reply@example.com>"
+	c.Mail.PasswordResetURL = "http://localhost:3000/reset-password"
+
+	c.OIDC.RedirectURL = "http://localhost:8080/auth/oidc/callback"
+
+	return c
+}
+
+// bind registers the options of c on fs, with the current values of c as defaults.
+func (c *Config) bind(fs *flag.FlagSet) {
+	fs.StringVar(&c.Mode, "mode", c.Mode, "prod or dev; prod refuses insecure settings such as the default JWT secret, so local development needs -mode=dev")
+	fs.IntVar(&c.Port, "port", c.Port, "port to listen on")
+	fs.StringVar(&c.Domain, "domain", c.Domain, "domain")
+	fs.BoolVar(&c.InMemory, "in-memory", c.InMemory, "use a seeded in-memory database instead of Postgres")
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "debug, info, warn or error; debug logs every database call")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "json or text")
+
+	fs.StringVar(&c.Trace.Exporter, "trace-exporter", c.Trace.Exporter, "where to send OpenTelemetry traces: otlp, stdout or none")
+	fs.StringVar(&c.Trace.OTLPEndpoint, "trace-otlp-endpoint", c.Trace.OTLPEndpoint, "OTLP/HTTP collector address (default from OTEL_EXPORTER_OTLP_ENDPOINT, or localhost:4318)")
+	fs.Float64Var(&c.Trace.SampleRatio, "trace-sample-ratio", c.Trace.SampleRatio, "share of new traces to record, from 0 to 1")
+
+	fs.DurationVar(&c.HTTP.ReadTimeout, "http-read-timeout", c.HTTP.ReadTimeout, "maximum duration for reading a whole request")
+	fs.DurationVar(&c.HTTP.ReadHeaderTimeout, "http-read-header-timeout", c.HTTP.ReadHeaderTimeout, "maximum duration for reading request headers")
+	fs.DurationVar(&c.HTTP.WriteTimeout, "http-write-timeout", c.HTTP.WriteTimeout, "maximum duration from the end of the request headers to the end of the response")
+	fs.DurationVar(&c.HTTP.IdleTimeout, "http-idle-timeout", c.HTTP.IdleTimeout, "how long idle keep-alive connections are kept open")
+	fs.DurationVar(&c.HTTP.ShutdownTimeout, "shutdown-timeout", c.HTTP.ShutdownTimeout, "how long in-flight requests get to finish after SIGINT or SIGTERM")
+	fs.DurationVar(&c.HTTP.ShutdownDelay, "shutdown-delay", c.HTTP.ShutdownDelay, "how long to keep serving after reporting not-ready on shutdown, for load balancers to notice")
+	fs.Var((*listValue)(&c.HTTP.TrustedProxies), "trusted-proxies", "comma separated addresses or CIDR ranges of reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted; if empty, the client is the connecting address")
+
+	fs.StringVar(&c.DB.DSN, "dsn", c.DB.DSN, "Postgres connection string")
+	fs.DurationVar(&c.DB.Timeout, "db-timeout", c.DB.Timeout, "maximum duration of a single database query")
+
+	fs.StringVar(&c.JWT.Secret, "jwt-secret", c.JWT.Secret, "signing secret")
+	fs.StringVar(&c.JWT.Keys, "jwt-keys", c.JWT.Keys, "comma separated PEM private key files for RS256/ES256/EdDSA signing; the first one signs, all verify. If empty, -jwt-secret signs with HS256")
+	fs.StringVar(&c.JWT.Issuer, "jwt-issuer", c.JWT.Issuer, "signing issuer")
+	fs.StringVar(&c.JWT.Audience, "jwt-audience", c.JWT.Audience, "signing audience")
+	fs.DurationVar(&c.JWT.Leeway, "jwt-leeway", c.JWT.Leeway, "allowed clock skew when checking token expiry and not-before times")
+	fs.DurationVar(&c.JWT.TokenExpiry, "jwt-token-expiry", c.JWT.TokenExpiry, "lifetime of access tokens")
+	fs.DurationVar(&c.JWT.RefreshExpiry, "jwt-refresh-expiry", c.JWT.RefreshExpiry, "lifetime of refresh tokens and the refresh cookie")
+	fs.DurationVar(&c.JWT.ChallengeExpiry, "jwt-challenge-expiry", c.JWT.ChallengeExpiry, "time allowed to enter a two-factor code after the password")
+
+	fs.StringVar(&c.Cookie.Domain, "cookie-domain", c.Cookie.Domain, "cookie domain")
+	fs.StringVar(&c.Cookie.Path, "cookie-path", c.Cookie.Path, "path of the refresh cookie")
+	fs.StringVar(&c.Cookie.Name, "cookie-name", c.Cookie.Name, "name of the refresh cookie")
+
+	fs.Var((*listValue)(&c.CORS.AllowedOrigins), "cors-allowed-origins", "comma separated origins allowed to call the API from a browser, with cookies and credentials; https://*.example.com allows all subdomains")
+	fs.Var((*listValue)(&c.CORS.PublicAllowedOrigins), "cors-public-allowed-origins", "comma separated origins allowed to read the public catalog without credentials; * allows any. If empty, -cors-allowed-origins")
+	fs.DurationVar(&c.CORS.MaxAge, "cors-max-age", c.CORS.MaxAge, "how long browsers may cache preflight responses")
+
+	fs.StringVar(&c.TMDBAPIKey, "api-key", c.TMDBAPIKey, "The Movie Database API key")
+
+	fs.DurationVar(&c.Health.CheckTimeout, "health-check-timeout", c.Health.CheckTimeout, "time limit of each dependency check of /readyz")
+	fs.BoolVar(&c.Health.CheckTMDB, "health-check-tmdb", c.Health.CheckTMDB, "make /readyz check that The Movie Database is reachable")
+
+	fs.StringVar(&c.Metrics.Addr, "metrics-addr", c.Metrics.Addr, "internal host:port to serve Prometheus metrics on at /metrics, separate from the API port; empty turns metrics off")
+
+	fs.IntVar(&c.Login.MaxAccountFailures, "login-max-account-failures", c.Login.MaxAccountFailures, "failed logins after which an email address is locked")
+	fs.IntVar(&c.Login.MaxIPFailures, "login-max-ip-failures", c.Login.MaxIPFailures, "failed logins after which a client address is locked")
+	fs.DurationVar(&c.Login.BaseDelay, "login-base-delay", c.Login.BaseDelay, "wait after the first failed login for an email address; it doubles with every failure")
+	fs.DurationVar(&c.Login.Lockout, "login-lockout", c.Login.Lockout, "duration of a login lockout")
+	fs.DurationVar(&c.Login.ResetAfter, "login-reset-after", c.Login.ResetAfter, "time after the last failed login after which the count starts over")
+
+	fs.StringVar(&c.Mail.SMTPHost, "smtp-host", c.Mail.SMTPHost, "SMTP server for outgoing mail. If empty, mail is written to -mail-dir, or only its recipient and subject are logged")
+	fs.IntVar(&c.Mail.SMTPPort, "smtp-port", c.Mail.SMTPPort, "SMTP port")
+	fs.StringVar(&c.Mail.SMTPUsername, "smtp-username", c.Mail.SMTPUsername, "SMTP username")
+	fs.StringVar(&c.Mail.SMTPPassword, "smtp-password", c.Mail.SMTPPassword, "SMTP password")
+	fs.StringVar(&c.Mail.From, "mail-from", c.Mail.From, "sender address of outgoing mail")
+	fs.StringVar(&c.Mail.Dir, "mail-dir", c.Mail.Dir, "directory to write outgoing mail to when no SMTP server is set")
+	fs.StringVar(&c.Mail.PasswordResetURL, "password-reset-url", c.Mail.PasswordResetURL, "frontend page that password reset emails link to; the token is added as ?token=")
+
+	fs.StringVar(&c.OIDC.Issuer, "oidc-issuer", c.OIDC.Issuer, "issuer URL of the OpenID Connect provider; OIDC login is off if empty")
+	fs.StringVar(&c.OIDC.ClientID, "oidc-client-id", c.OIDC.ClientID, "OIDC client id")
+	fs.StringVar(&c.OIDC.ClientSecret, "oidc-client-secret", c.OIDC.ClientSecret, "OIDC client secret, empty for public clients")
+	fs.StringVar(&c.OIDC.RedirectURL, "oidc-redirect-url", c.OIDC.RedirectURL, "OIDC redirect URL registered with the provider")
+	fs.StringVar(&c.OIDC.PostLoginURL, "oidc-post-login-url", c.OIDC.PostLoginURL, "where to send the browser after an OIDC login; if empty, the callback responds with the tokens")
+}
+
+// EnvName returns the environment variable for the option with the given flag name.
+func EnvName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load builds the configuration from the command line arguments args, which do not include
+// the program name, the environment and the config file named by -config or CONFIG_FILE. It
+// returns the arguments left after the flags. A -h or -help returns flag.ErrHelp.
+func Load(name string, args []string) (*Config, []string, error) {
+	c := Default()
+
+	// the flags are parsed into a copy first, so that they can be applied last
+	flags := *c
+	flags.CORS.AllowedOrigins = append([]string(nil), c.CORS.AllowedOrigins...)
+	flags.CORS.PublicAllowedOrigins = append([]string(nil), c.CORS.PublicAllowedOrigins...)
+	flags.HTTP.TrustedProxies = append([]string(nil), c.HTTP.TrustedProxies...)
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.bind(fs)
+	fs.StringVar(&c.File, "config", os.Getenv("CONFIG_FILE"), "YAML (.yaml, .yml) or TOML (.toml) config file")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options := flag.NewFlagSet(name, flag.ContinueOnError)
+	c.bind(options)
+
+	c.sources = make(map[string]string)
+	options.VisitAll(func(f *flag.Flag) {
+		c.sources[f.Name] = "default"
+	})
+
+	set := func(name, value, source string) error {
+		if options.Lookup(name) == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+
+		err := options.Set(name, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		c.sources[name] = source
+		return nil
+	}
+
+	if c.File != "" {
+		values, err := readFile(c.File)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for name, value := range values {
+			err = set(name, value, "file")
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", c.File, err)
+			}
+		}
+	}
+
+	options.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			err = set(f.Name, value, "env "+EnvName(f.Name))
+		}
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("environment: %w", err)
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" {
+			return
+		}
+		err = set(f.Name, f.Value.String(), "flag")
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, fs.Args(), nil
+}
+
+// Validate checks the configuration and returns an error listing everything wrong with it.
+func (c *Config) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Mode != ModeDev && c.Mode != ModeProd {
+		problem("mode must be %q or %q, not %q", ModeDev, ModeProd, c.Mode)
+	}
+	if c.Port < 1 || c.Port > 65535 {
+		problem("port %d is out of range", c.Port)
+	}
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		problem("log-level must be debug, info, warn or error, not %q", c.Log.Level)
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		problem("log-format must be json or text, not %q", c.Log.Format)
+	}
+	switch c.Trace.Exporter {
+	case "otlp", "stdout", "none":
+	default:
+		problem("trace-exporter must be otlp, stdout or none, not %q", c.Trace.Exporter)
+	}
+	if c.Trace.SampleRatio < 0 || c.Trace.SampleRatio > 1 {
+		problem("trace-sample-ratio must be between 0 and 1")
+	}
+
+	if c.HTTP.ReadTimeout <= 0 || c.HTTP.ReadHeaderTimeout <= 0 || c.HTTP.WriteTimeout <= 0 || c.HTTP.IdleTimeout <= 0 {
+		problem("http-read-timeout, http-read-header-timeout, http-write-timeout and http-idle-timeout must be positive")
+	}
+	if c.HTTP.ShutdownTimeout <= 0 || c.HTTP.ShutdownDelay < 0 {
+		problem("shutdown-timeout must be positive and shutdown-delay must not be negative")
+	}
+	for _, proxy := range c.HTTP.TrustedProxies {
+		_, _, err := net.ParseCIDR(proxy)
+		if err != nil && net.ParseIP(proxy) == nil {
+			problem("trusted-proxies: %q is not an IP address or CIDR range", proxy)
+		}
+	}
+
+	if !c.InMemory && c.DB.DSN == "" {
+		problem("dsn is required unless in-memory is set")
+	}
+	if c.DB.Timeout <= 0 {
+		problem("db-timeout must be positive")
+	}
+
+	if c.JWT.Keys == "" {
+		switch {
+		case c.JWT.Secret == "":
+			problem("jwt-secret or jwt-keys is required")
+		case c.Mode != ModeDev && c.JWT.Secret == DefaultJWTSecret:
+			problem("jwt-secret is the built-in default, which is only allowed in dev mode")
+		case c.Mode != ModeDev && len(c.JWT.Secret) < 32:
+			problem("jwt-secret must be at least 32 bytes outside dev mode")
+		}
+	}
+	if c.JWT.Issuer == "" {
+		problem("jwt-issuer is required")
+	}
+	if c.JWT.Audience == "" {
+		problem("jwt-audience is required")
+	}
+	if c.JWT.Leeway < 0 {
+		problem("jwt-leeway must not be negative")
+	}
+	if c.JWT.TokenExpiry <= 0 || c.JWT.ChallengeExpiry <= 0 {
+		problem("jwt-token-expiry and jwt-challenge-expiry must be positive")
+	}
+	if c.JWT.RefreshExpiry <= c.JWT.TokenExpiry {
+		problem("jwt-refresh-expiry must be longer than jwt-token-expiry")
+	}
+
+	if c.Cookie.Name == "" {
+		problem("cookie-name is required")
+	}
+	if !strings.HasPrefix(c.Cookie.Path, "/") {
+		problem("cookie-path must start with /")
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		err := cors.ValidateOrigin(origin)
+		switch {
+		case err != nil:
+			problem("cors-allowed-origins: %s", err)
+		case origin == "*":
+			problem("cors-allowed-origins: * is not allowed for credentialed requests, use cors-public-allowed-origins")
+		}
+	}
+	for _, origin := range c.CORS.PublicAllowedOrigins {
+		err := cors.ValidateOrigin(origin)
+		if err != nil {
+			problem("cors-public-allowed-origins: %s", err)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		problem("cors-max-age must not be negative")
+	}
+
+	if c.Health.CheckTimeout <= 0 {
+		problem("health-check-timeout must be positive")
+	}
+
+	if c.Metrics.Addr != "" {
+		_, port, err := net.SplitHostPort(c.Metrics.Addr)
+		n, portErr := strconv.Atoi(port)
+		switch {
+		case err != nil:
+			problem("metrics-addr: %s", err)
+		case portErr != nil || n < 0 || n > 65535:
+			problem("metrics-addr: port %q is out of range", port)
+		case n == c.Port:
+			problem("metrics-addr must not use the API port %d", c.Port)
+		}
+	}
+
+	if c.Login.MaxAccountFailures < 1 || c.Login.MaxIPFailures < 1 {
+		problem("login-max-account-failures and login-max-ip-failures must be at least 1")
+	}
+	if c.Login.BaseDelay <= 0 || c.Login.Lockout <= 0 || c.Login.ResetAfter <= 0 {
+		problem("login-base-delay, login-lockout and login-reset-after must be positive")
+	}
+
+	if c.Mail.SMTPPort < 1 || c.Mail.SMTPPort > 65535 {
+		problem("smtp-port %d is out of range", c.Mail.SMTPPort)
+	}
+	if c.Mail.From == "" {
+		problem("mail-from is required")
+	}
+	if c.Mode != ModeDev && c.Mail.SMTPHost == "" && c.Mail.Dir == "" {
+		problem("smtp-host or mail-dir is required outside dev mode, or password reset emails are lost")
+	}
+	checkURL := func(name, value string) {
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("%s: %q is not an absolute http(s) URL", name, value)
+		}
+	}
+	checkURL("password-reset-url", c.Mail.PasswordResetURL)
+
+	if c.OIDC.Issuer != "" {
+		checkURL("oidc-issuer", c.OIDC.Issuer)
+		checkURL("oidc-redirect-url", c.OIDC.RedirectURL)
+		if c.OIDC.ClientID == "" {
+			problem("oidc-client-id is required with oidc-issuer")
+		}
+		if c.OIDC.PostLoginURL != "" {
+			checkURL("oidc-post-login-url", c.OIDC.PostLoginURL)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+}
+
+// listValue is a flag.Value for a comma separated list.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// quote formats a value for Print so that it reads back from a YAML file unchanged.
+func quote(s string) string {
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return s
+	}
+	if s == "true" || s == "false" {
+		return s
+	}
+	return strconv.Quote(s)
+}
+
+// flagSetOf returns a flag set bound to the options of c.
+func flagSetOf(c *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	c.bind(fs)
+	return fs
+}
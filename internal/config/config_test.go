@@ -0,0 +1,90 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+// validDev returns a configuration that passes Validate, to change one option of.
+func validDev() *Config {
+	c := Default()
+	c.Mode = ModeDev
+	c.InMemory = true
+	return c
+}
+
+// toProd switches c to prod mode with a secret that prod accepts.
+func toProd(c *Config) {
+	c.Mode = ModeProd
+	c.JWT.Secret = strings.Repeat("s", 32)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(c *Config)
+		problem string
+	}{
+		{"default", func(c *Config) {}, ""},
+		{"trusted proxy address and range", func(c *Config) { c.HTTP.TrustedProxies = []string{"10.0.0.0/8", "::1"} }, ""},
+		{"trusted proxy host name", func(c *Config) { c.HTTP.TrustedProxies = []string{"proxy.example.com"} }, "trusted-proxies"},
+		{"trusted proxy bad range", func(c *Config) { c.HTTP.TrustedProxies = []string{"10.0.0.0/33"} }, "trusted-proxies"},
+		{"metrics on another port", func(c *Config) { c.Metrics.Addr = ":9100" }, ""},
+		{"metrics off", func(c *Config) { c.Metrics.Addr = "" }, ""},
+		{"metrics on the API port", func(c *Config) { c.Metrics.Addr = "0.0.0.0:8080" }, "metrics-addr"},
+		{"metrics without a port", func(c *Config) { c.Metrics.Addr = "localhost" }, "metrics-addr"},
+		{"prod without mail", func(c *Config) { toProd(c) }, "smtp-host or mail-dir"},
+		{"prod with smtp", func(c *Config) { toProd(c); c.Mail.SMTPHost = "smtp.example.com" }, ""},
+		{"prod with a mail dir", func(c *Config) { toProd(c); c.Mail.Dir = "/var/mail/gomovies" }, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validDev()
+			tt.change(c)
+
+			err := c.Validate()
+			switch {
+			case tt.problem == "" && err != nil:
+				t.Errorf("got %v, want no error", err)
+			case tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)):
+				t.Errorf("got %v, want a problem with %s", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestDefaultIsProd(t *testing.T) {
+	c := Default()
+	if c.Mode != ModeProd {
+		t.Fatalf("got mode %q, want %q", c.Mode, ModeProd)
+	}
+
+	// the defaults are for development, so prod must refuse them until they are replaced
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "jwt-secret is the built-in default") {
+		t.Errorf("got %v, want the default secret refused", err)
+	}
+
+	c, _, err = Load("test", []string{"-mode=dev", "-in-memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Validate(); err != nil {
+		t.Errorf("dev mode: got %v", err)
+	}
+}
+
+func TestLoadTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8")
+
+	c, _, err := Load("test", []string{"-trusted-proxies=127.0.0.1, 10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Join(c.HTTP.TrustedProxies, ",")
+	if got != "127.0.0.1,10.1.0.0/16" {
+		t.Errorf("got %s, want the flag to win over the environment", got)
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// readFile reads a YAML or TOML config file into option values by flag name. Options can be
+// given flat, as in
+//
+//	jwt-issuer: example.com
+//
+// or grouped in sections by the first words of their names:
+//
+//	jwt:
+//	  issuer: example.com
+//
+// Lists, such as cors-allowed-origins, can be written as lists or comma separated strings.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]interface{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("%s: config files must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]string)
+	err = flatten(values, "", tree)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+func flatten(values map[string]string, prefix string, tree map[string]interface{}) error {
+	for key, value := range tree {
+		name := key
+		if prefix != "" {
+			name = prefix + "-" + key
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			err := flatten(values, name, v)
+			if err != nil {
+				return err
+			}
+		case []interface{}:
+			items := make([]string, len(v))
+			for i, item := range v {
+				items[i] = fmt.Sprint(item)
+			}
+			values[name] = strings.Join(items, ",")
+		case nil:
+			values[name] = ""
+		default:
+			if _, ok := values[name]; ok {
+				return fmt.Errorf("%s is set twice", name)
+			}
+			values[name] = fmt.Sprint(v)
+		}
+	}
+
+	return nil
+}
+
+// Print writes the configuration to w in the config file format, with the source of every
+// value and secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	options := flagSetOf(c)
+
+	var names []string
+	for name := range c.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	if c.File != "" {
+		fmt.Fprintf(tw, "# read from %s\n", c.File)
+	}
+
+	for _, name := range names {
+		value := options.Lookup(name).Value.String()
+
+		switch {
+		case secrets[name] && value != "":
+			value = "[redacted]"
+		case name == "dsn":
+			value = redactDSN(value)
+		}
+
+		fmt.Fprintf(tw, "%s: %s\t# %s\n", name, quote(value), c.sources[name])
+	}
+
+	return tw.Flush()
+}
+
+var dsnPassword = regexp.MustCompile(`(password=)('(\\.|[^'])*'|\S*)`)
+
+// redactDSN hides the password in a Postgres connection string, in URL or key=value form.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" && u.User != nil {
+		return u.Redacted()
+	}
+
+	return dsnPassword.ReplaceAllString(dsn, "${1}[redacted]")
+}
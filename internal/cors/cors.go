@@ -0,0 +1,248 @@
+// Package cors implements Cross-Origin Resource Sharing: it answers preflight requests and
+// adds the headers that let browsers on other origins read responses, for the origins, methods
+// and headers a Policy allows. Requests from origins a policy does not allow are rejected.
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy says which cross-origin requests are allowed.
+type Policy struct {
+	// AllowedOrigins are origins such as https://example.com. An entry like
+	// https://*.example.com allows every subdomain of example.com, but not example.com itself.
+	// "*" allows any origin and cannot be used with AllowCredentials.
+	AllowedOrigins []string
+	AllowedMethods []string
+	// AllowedHeaders are the request headers a preflight may ask for, case-insensitive.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read beyond the safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets cookies and Authorization headers be sent.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// ValidateOrigin checks an AllowedOrigins entry.
+func ValidateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.Path != "" || u.RawQuery != "" || u.User != nil || u.Fragment != "" {
+		return fmt.Errorf("%q is not an origin like https://example.com or https://*.example.com", origin)
+	}
+
+	host := strings.TrimPrefix(u.Hostname(), "*.")
+	if strings.Contains(host, "*") || host == "" {
+		return fmt.Errorf("%q: only a leading *. is allowed as a wildcard", origin)
+	}
+
+	return nil
+}
+
+// AllowsOrigin reports whether the policy allows requests from origin, the value of an
+// Origin header.
+func (p *Policy) AllowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+
+	for _, allowed := range p.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+
+		// https://*.example.com:8443 matches https://<anything>.example.com:8443
+		scheme, rest, ok := strings.Cut(allowed, "://*.")
+		if !ok {
+			continue
+		}
+		prefix := scheme + "://"
+		if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, "."+rest) &&
+			len(origin) > len(prefix)+len(rest)+1 {
+			sub := origin[len(prefix) : len(origin)-len(rest)-1]
+			if !strings.ContainsAny(sub, "/:@") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *Policy) allowsMethod(method string) bool {
+	for _, allowed := range p.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) allowsHeader(header string) bool {
+	for _, allowed := range p.AllowedHeaders {
+		if strings.EqualFold(allowed, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mux applies a Policy to each request, chosen by the longest matching path prefix.
+type Mux struct {
+	// Default applies to paths no other policy was registered for.
+	Default *Policy
+	// Reject writes the response to requests from a disallowed origin. If nil, a plain 403
+	// is sent.
+	Reject func(w http.ResponseWriter, r *http.Request, err error)
+
+	prefixes []string
+	policies []*Policy
+}
+
+// ErrOriginNotAllowed is passed to Reject for requests from an origin the policy does not
+// allow.
+var ErrOriginNotAllowed = errors.New("cross-origin requests from this origin are not allowed")
+
+// ErrPreflightRejected is passed to Reject for preflight requests asking for a method or
+// header the policy does not allow.
+var ErrPreflightRejected = errors.New("cross-origin request method or headers are not allowed")
+
+// Handle registers the policy for paths equal to prefix or below it.
+func (m *Mux) Handle(prefix string, policy *Policy) {
+	m.prefixes = append(m.prefixes, strings.TrimSuffix(prefix, "/"))
+	m.policies = append(m.policies, policy)
+}
+
+// Policy returns the policy for a request path.
+func (m *Mux) Policy(path string) *Policy {
+	policy, longest := m.Default, -1
+
+	for i, prefix := range m.prefixes {
+		if (path == prefix || strings.HasPrefix(path, prefix+"/")) && len(prefix) > longest {
+			policy, longest = m.policies[i], len(prefix)
+		}
+	}
+
+	return policy
+}
+
+// Handler is middleware applying the policies to next.
+func (m *Mux) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// responses differ by origin, so caches must keep them apart
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || sameOrigin(r, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		policy := m.Policy(r.URL.Path)
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if policy == nil || !policy.AllowsOrigin(origin) {
+			m.reject(w, r, ErrOriginNotAllowed)
+			return
+		}
+
+		if preflight {
+			m.preflight(w, r, policy, origin)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", allowOriginValue(policy, origin))
+		if policy.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if len(policy.ExposedHeaders) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (m *Mux) preflight(w http.ResponseWriter, r *http.Request, policy *Policy, origin string) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if !policy.allowsMethod(method) {
+		m.reject(w, r, ErrPreflightRejected)
+		return
+	}
+
+	var headers []string
+	for _, field := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !policy.allowsHeader(field) {
+			m.reject(w, r, ErrPreflightRejected)
+			return
+		}
+		headers = append(headers, field)
+	}
+
+	h.Set("Access-Control-Allow-Origin", allowOriginValue(policy, origin))
+	if policy.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	h.Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods, ", "))
+	if len(headers) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if policy.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m *Mux) reject(w http.ResponseWriter, r *http.Request, err error) {
+	if m.Reject != nil {
+		m.Reject(w, r, err)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusForbidden)
+}
+
+// allowOriginValue returns the Access-Control-Allow-Origin header for an allowed origin.
+// Credentialed responses must name the origin; others can use "*" if any origin is allowed.
+func allowOriginValue(policy *Policy, origin string) string {
+	if !policy.AllowCredentials {
+		for _, allowed := range policy.AllowedOrigins {
+			if allowed == "*" {
+				return "*"
+			}
+		}
+	}
+	return origin
+}
+
+// sameOrigin reports whether origin is the server's own, in which case browsers need no CORS
+// headers. Browsers send Origin with same-origin POSTs too. Only the host is compared, since
+// behind a TLS-terminating proxy the request does not show the scheme the browser used.
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
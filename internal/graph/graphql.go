@@ -2,22 +2,43 @@ package graph
 
 import (
 	"backend/internal/models"
+	"context"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/graphql-go/graphql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer makes the spans of queries and their resolvers
+var tracer = otel.Tracer("backend/internal/graph")
+
+// ErrQuery is returned by Query when the query string is invalid or a resolver fails. The
+// messages of the underlying errors may quote the query, so they are only recorded in the span.
+var ErrQuery = errors.New("error executing query")
+
 type Graph struct {
 	Movies []*models.Movie
 	QueryString string
 	Config graphql.SchemaConfig
+	Search SearchFunc // backs the fullTextSearch field; the field returns an error if nil
 	fields graphql.Fields // defines the available actions on the data (in this case movieType data)
 	movieType *graphql.Object //describe the data as it exists in the database
 }
 
+// SearchFunc runs a full-text search over movies, like repository.DatabaseRepo.SearchMovies
+type SearchFunc func(ctx context.Context, query string, limit int) ([]*models.MovieSearchResult, error)
+
 // New define factory function to get the instance of variable of the type Graph
 func New(movies []*models.Movie) *Graph{
+	g := &Graph{
+		Movies: movies,
+	}
+
 	// describes the kinds of things we wanna expose from our database
 	var movieType = graphql.NewObject(
 		graphql.ObjectConfig{
@@ -54,6 +75,27 @@ func New(movies []*models.Movie) *Graph{
 		},
 	)
 
+	// a full-text search hit: the movie, its relevance, and highlighted matches
+	var searchResultType = graphql.NewObject(
+		graphql.ObjectConfig{
+			Name : "MovieSearchResult",
+			Fields : graphql.Fields{
+				"movie":&graphql.Field{
+					Type : movieType,
+				},
+				"rank":&graphql.Field{
+					Type : graphql.Float,
+				},
+				"title_highlight":&graphql.Field{
+					Type : graphql.String,
+				},
+				"description_highlight":&graphql.Field{
+					Type : graphql.String,
+				},
+			},
+		},
+	)
+
 	// fields gives me the kinds of actions I want to perform, and every entry in fields has a name for the action itself.
 	var fields = graphql.Fields{
 		"list" : &graphql.Field{
@@ -86,6 +128,33 @@ func New(movies []*models.Movie) *Graph{
 			},
 		},
 
+		"fullTextSearch": &graphql.Field{
+			Type : graphql.NewList(searchResultType),
+			Description: "Full-text search over titles and descriptions, ordered by relevance",
+			Args: graphql.FieldConfigArgument{
+				"query":&graphql.ArgumentConfig{
+					Type:graphql.NewNonNull(graphql.String),
+				},
+				"limit":&graphql.ArgumentConfig{
+					Type:graphql.Int,
+					DefaultValue: models.DefaultPageSize,
+				},
+			},
+			Resolve : func(params graphql.ResolveParams)(interface{}, error){
+				if g.Search == nil {
+					return nil, errors.New("full-text search is not available")
+				}
+
+				query, _ := params.Args["query"].(string)
+				limit, _ := params.Args["limit"].(int)
+				if limit < 1 || limit > models.MaxPageSize {
+					return nil, fmt.Errorf("limit must be between 1 and %d", models.MaxPageSize)
+				}
+
+				return g.Search(params.Context, query, limit)
+			},
+		},
+
 		"get":&graphql.Field{
 			Type: movieType,
 			Description : "Get movie by id",
@@ -108,14 +177,22 @@ func New(movies []*models.Movie) *Graph{
 		},
 	}
 
-	return &Graph {
-		Movies: movies,
-		fields : fields,
-		movieType : movieType,
+	// every resolver gets a span of its own
+	for name, field := range fields {
+		field.Resolve = traced(name, field.Resolve)
 	}
+
+	g.fields = fields
+	g.movieType = movieType
+
+	return g
 }
 
-func (g *Graph) Query() (*graphql.Result, error){
+// Query executes QueryString. ctx is handed to the resolvers.
+func (g *Graph) Query(ctx context.Context) (*graphql.Result, error){
+	ctx, span := tracer.Start(ctx, "graphql.query")
+	defer span.End()
+
 	rootQuery := graphql.ObjectConfig{Name : "RootQuery", Fields : g.fields}
 	schemaConfig := graphql.SchemaConfig{Query:graphql.NewObject(rootQuery)}
 	schema, err := graphql.NewSchema(schemaConfig)
@@ -124,11 +201,31 @@ func (g *Graph) Query() (*graphql.Result, error){
 		return nil, err
 	}
 
-	params := graphql.Params{Schema: schema, RequestString: g.QueryString}
+	params := graphql.Params{Schema: schema, RequestString: g.QueryString, Context: ctx}
 	resp := graphql.Do(params)
 	if len(resp.Errors) > 0{
-		return nil, errors.New("error executing query")
+		span.SetStatus(codes.Error, resp.Errors[0].Message)
+		return nil, ErrQuery
 	}
 
 	return resp, nil
+}
+
+// traced wraps resolve in a span named after the field it resolves, which is the parent of
+// the spans of the database calls the resolver makes.
+func traced(field string, resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(params graphql.ResolveParams) (interface{}, error) {
+		ctx, span := tracer.Start(params.Context, "graphql.resolve "+field,
+			trace.WithAttributes(attribute.String("graphql.field.name", field)))
+		defer span.End()
+
+		params.Context = ctx
+		result, err := resolve(params)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+
+		return result, err
+	}
 }
\ No newline at end of file
@@ -0,0 +1,123 @@
+// Package logging sets up the structured logger and carries per-request values, such as the
+// request ID, in contexts so that every log record made with the context includes them.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// New returns a logger writing to w in format "json" or "text", at level "debug", "info",
+// "warn" or "error".
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	var h slog.Handler
+	switch format {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return slog.New(contextHandler{h}), nil
+}
+
+// contextHandler adds the request values of the context, and its trace and span IDs, to
+// each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info, ok := ctx.Value(requestKey).(*requestInfo); ok {
+		r.AddAttrs(slog.String("request_id", info.id))
+		if userID := info.userID.Load(); userID != 0 {
+			r.AddAttrs(slog.Int64("user_id", userID))
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type contextKey struct{}
+
+var requestKey contextKey
+
+// requestInfo is shared by a request and every context derived from it, so the user ID set
+// by the authentication middleware is visible to the access log further out.
+type requestInfo struct {
+	id     string
+	userID atomic.Int64
+}
+
+// WithRequestID returns a context for the request with the given ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey, &requestInfo{id: id})
+}
+
+// RequestID returns the request ID of the context, or "".
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestKey).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// SetUserID records the authenticated user of the request the context belongs to.
+func SetUserID(ctx context.Context, userID int) {
+	if info, ok := ctx.Value(requestKey).(*requestInfo); ok {
+		info.userID.Store(int64(userID))
+	}
+}
+
+// UserID returns the user ID recorded with SetUserID, or 0.
+func UserID(ctx context.Context) int {
+	if info, ok := ctx.Value(requestKey).(*requestInfo); ok {
+		return int(info.userID.Load())
+	}
+	return 0
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether an ID received from a client is safe to use: at most 128
+// letters, digits, dashes, underscores and dots, so it cannot forge log lines or headers.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	return strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") == ""
+}
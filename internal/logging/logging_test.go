@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"", false},
+		{"a", true},
+		{"0f8fad5b-d9cb-469f-a165-70867728950e", true},
+		{"Trace_1.2-3", true},
+		{strings.Repeat("x", 128), true},
+		{strings.Repeat("x", 129), false},
+		{"two words", false},
+		{"forged\nlevel=ERROR msg=fake", false},
+		{"id\r\nSet-Cookie: session=stolen", false},
+		{`quote"`, false},
+		{"<script>", false},
+		{"a/b", false},
+		{"a;b", false},
+		{"é", false},
+		{"\x00", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidRequestID(tt.id); got != tt.want {
+			t.Errorf("ValidRequestID(%q): got %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestNewRequestIDIsValid(t *testing.T) {
+	a, b := NewRequestID(), NewRequestID()
+	if !ValidRequestID(a) || a == b {
+		t.Errorf("got %q and %q", a, b)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tt := range []struct{ format, level string }{{"json", "verbose"}, {"xml", "info"}} {
+		_, err := New(&bytes.Buffer{}, tt.format, tt.level)
+		if err == nil {
+			t.Errorf("format %s, level %s: got no error", tt.format, tt.level)
+		}
+	}
+}
+
+func TestContextValuesReachRecords(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	// the user is set on a context derived from the request's, as the auth middleware does
+	SetUserID(context.WithoutCancel(ctx), 42)
+
+	logger.InfoContext(ctx, "with request")
+	logger.With("component", "test").InfoContext(ctx, "with attrs")
+	logger.InfoContext(WithRequestID(context.Background(), "req-2"), "anonymous")
+	logger.Info("without context")
+	logger.DebugContext(ctx, "below the level")
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		err := json.Unmarshal([]byte(line), &record)
+		if err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 4 {
+		t.Fatalf("got %d records, want 4:\n%s", len(records), buf.String())
+	}
+
+	tests := []struct {
+		requestID interface{}
+		userID    interface{}
+	}{
+		{"req-1", 42.0},
+		{"req-1", 42.0},
+		{"req-2", nil},
+		{nil, nil},
+	}
+	for i, tt := range tests {
+		if records[i]["request_id"] != tt.requestID || records[i]["user_id"] != tt.userID {
+			t.Errorf("%s: got request_id %v and user_id %v, want %v and %v",
+				records[i]["msg"], records[i]["request_id"], records[i]["user_id"], tt.requestID, tt.userID)
+		}
+	}
+	if records[1]["component"] != "test" {
+		t.Errorf("lost the logger's attributes: %v", records[1])
+	}
+
+	if RequestID(ctx) != "req-1" || UserID(ctx) != 42 || RequestID(context.Background()) != "" || UserID(context.Background()) != 0 {
+		t.Error("the context accessors disagree with the records")
+	}
+}
@@ -0,0 +1,210 @@
+// Package mailer sends the emails of the application, such as password reset links.
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTP sends mail through an SMTP server, using STARTTLS when the server offers it and PLAIN
+// authentication when a username is set. The whole exchange, from dialing on, ends with the
+// context passed to Send.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// smtp.SendMail would do the same, but without a way to give up on a server that stops
+	// answering
+	for _, line := range []string{m.From, msg.To} {
+		if strings.ContainsAny(line, "\r\n") {
+			return errors.New("mailer: a line must not contain CR or LF")
+		}
+	}
+
+	addr := net.JoinHostPort(m.Host, fmt.Sprint(m.Port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			return err
+		}
+	}
+	// a cancellation without a deadline interrupts the exchange too
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	defer c.Close()
+
+	err = m.send(c, auth, msg)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+
+	return nil
+}
+
+// send runs the SMTP exchange that delivers msg on c.
+func (m *SMTP) send(c *smtp.Client, auth smtp.Auth, msg Message) error {
+	err := c.Hello("localhost")
+	if err != nil {
+		return err
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: m.Host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("mailer: server doesn't support AUTH")
+		}
+		err = c.Auth(auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(m.From)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(format(m.From, msg))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// contextError returns the context's error instead of err when the context ended, since err is
+// then only the timeout it caused on the connection.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("mailer: %w: %v", ctxErr, err)
+	}
+	return err
+}
+
+// Local does not deliver mail, for development and tests. It writes every message to a file
+// in Dir. If Dir is empty it only logs the recipient and subject to Logger, or the default
+// logger: bodies hold secrets such as password reset links, which must not reach the logs.
+type Local struct {
+	Dir    string
+	From   string
+	Logger *slog.Logger
+}
+
+func (m *Local) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if m.Dir == "" {
+		logger := m.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.InfoContext(ctx, "mail not sent, no SMTP server or mail dir is set", "to", msg.To, "subject", msg.Subject)
+		return nil
+	}
+
+	err := os.MkdirAll(m.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), safeName(msg.To))
+
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o600)
+}
+
+// format renders msg as an RFC 5322 message.
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
+
+// safeName keeps the letters, digits, dots, dashes and @ of s, for use in a file name.
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '@':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,189 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = Message{
+	To:      "user@example.com",
+	Subject: "Reset your password",
+	Body:    "open https://example.com/reset?token=secret-token\n",
+}
+
+func TestLocalWritesFiles(t *testing.T) {
+	dir := t.TempDir()
+	m := &Local{Dir: dir, From: "Go Movies <no-reply@example.com>"}
+
+	err := m.Send(context.Background(), testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*-user@example.com.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got files %v, %v", files, err)
+	}
+
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: user@example.com\r\n", "Subject: Reset your password\r\n", "token=secret-token\r\n"} {
+		if !bytes.Contains(b, []byte(want)) {
+			t.Errorf("%q is missing from\n%s", want, b)
+		}
+	}
+}
+
+func TestLocalNeverLogsBodies(t *testing.T) {
+	var log bytes.Buffer
+	m := &Local{Logger: slog.New(slog.NewTextHandler(&log, nil))}
+
+	err := m.Send(context.Background(), testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(log.String(), "to=user@example.com") {
+		t.Errorf("the recipient is missing from %q", log.String())
+	}
+	if strings.Contains(log.String(), "secret-token") {
+		t.Errorf("the body was logged: %q", log.String())
+	}
+}
+
+// fakeSMTP listens on a local port and runs serve for each connection.
+func fakeSMTP(t *testing.T, serve func(conn net.Conn)) *SMTP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	return &SMTP{Host: host, Port: p, From: "no-reply@example.com"}
+}
+
+func TestSMTPSends(t *testing.T) {
+	received := make(chan string, 1)
+
+	m := fakeSMTP(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 fake ESMTP")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250 fake")
+			case strings.HasPrefix(cmd, "MAIL"), strings.HasPrefix(cmd, "RCPT"):
+				reply("250 ok")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 unknown")
+			}
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := m.Send(ctx, testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-received
+	if !strings.Contains(got, "To: user@example.com\r\n") || !strings.Contains(got, "token=secret-token") {
+		t.Errorf("got message\n%s", got)
+	}
+}
+
+func TestSMTPGivesUpWithTheContext(t *testing.T) {
+	// the server accepts the connection and never answers
+	m := fakeSMTP(t, func(conn net.Conn) {
+		_, _ = conn.Read(make([]byte, 1))
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := m.Send(ctx, testMessage)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("got %v, want the deadline", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("took %s", elapsed)
+		}
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+
+		err := m.Send(ctx, testMessage)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want the cancellation", err)
+		}
+	})
+}
+
+func TestSMTPRefusesHeaderInjection(t *testing.T) {
+	m := &SMTP{Host: "127.0.0.1", Port: 1, From: "no-reply@example.com"}
+
+	msg := testMessage
+	msg.To = "user@example.com\r\nBcc: victim@example.com"
+
+	err := m.Send(context.Background(), msg)
+	if err == nil || !strings.Contains(err.Error(), "CR or LF") {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Package metrics collects the server's Prometheus metrics: HTTP requests by route, database
+// calls by repository method, the database connection pool, calls to The Movie Database and
+// logins.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gomovies"
+
+// Outcomes of database and TMDB calls.
+const (
+	OutcomeOK       = "ok"
+	OutcomeNotFound = "not_found"
+	OutcomeError    = "error"
+)
+
+// Metrics holds the collectors. Create it with New.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	httpInFlight prometheus.Gauge
+
+	dbDuration *prometheus.HistogramVec
+	dbErrors   *prometheus.CounterVec
+
+	tmdbDuration *prometheus.HistogramVec
+
+	logins *prometheus.CounterVec
+}
+
+// New returns Metrics registered in a registry of their own, along with the Go runtime and
+// process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method and route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		httpInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests being served.",
+		}),
+
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_call_duration_seconds",
+			Help:      "Duration of repository calls by method and outcome.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"method", "outcome"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_call_errors_total",
+			Help:      "Repository calls that failed, by method. Rows not found are not counted.",
+		}, []string{"method"}),
+
+		tmdbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "tmdb_request_duration_seconds",
+			Help:      "Duration of The Movie Database poster lookups by outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"outcome"}),
+
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Login attempts by method (password, totp, oidc) and result (success, failure, throttled, or challenge when a second factor is required).",
+		}, []string{"method", "result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration, m.httpInFlight,
+		m.dbDuration, m.dbErrors,
+		m.tmdbDuration,
+		m.logins,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Gatherer returns the registry the metrics are collected in.
+func (m *Metrics) Gatherer() prometheus.Gatherer {
+	return m.registry
+}
+
+// RegisterDB adds the statistics of a database connection pool.
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Middleware records every request under its chi route pattern, such as /movies/{id}, so
+// that the number of label values stays bounded. Requests matching no route are recorded
+// as "unmatched".
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.httpInFlight.Inc()
+		defer m.httpInFlight.Dec()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		start := time.Now()
+
+		defer func() {
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			m.httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+			m.httpDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// DBHook records the duration and outcome of a repository call. It is a dbrepo.Hook.
+func (m *Metrics) DBHook(ctx context.Context, method string) (context.Context, func(err error)) {
+	start := time.Now()
+
+	return ctx, func(err error) {
+		outcome := OutcomeOK
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			outcome = OutcomeNotFound
+		case err != nil:
+			outcome = OutcomeError
+			m.dbErrors.WithLabelValues(method).Inc()
+		}
+
+		m.dbDuration.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveTMDB records a call to The Movie Database.
+func (m *Metrics) ObserveTMDB(outcome string, duration time.Duration) {
+	m.tmdbDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+}
+
+// CountLogin records a login attempt.
+func (m *Metrics) CountLogin(method, result string) {
+	m.logins.WithLabelValues(method, result).Inc()
+}
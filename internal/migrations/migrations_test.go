@@ -0,0 +1,242 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	migrations, err := Load(files, "sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("migration %d_%s is at position %d", m.Version, m.Name, i)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %d_%s is missing a script", m.Version, m.Name)
+		}
+	}
+
+	if migrations[0].Name != "initial_schema" {
+		t.Errorf("first migration is %s", migrations[0].Name)
+	}
+}
+
+// migrationFS returns a file system with the named files in sql/, each holding a statement.
+func migrationFS(names ...string) fstest.MapFS {
+	fsys := fstest.MapFS{}
+	for _, name := range names {
+		fsys["sql/"+name] = &fstest.MapFile{Data: []byte("select '" + name + "';\n")}
+	}
+	return fsys
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		// want is the expected versions and names, or problem the expected error
+		want    []string
+		problem string
+	}{
+		{"empty", nil, nil, ""},
+		{"sorted by version, not by name", []string{
+			"0010_j.up.sql", "0010_j.down.sql",
+			"0002_b.up.sql", "0002_b.down.sql",
+			"0001_z.up.sql", "0001_z.down.sql",
+			"0003_c.up.sql", "0003_c.down.sql",
+			"0004_d.up.sql", "0004_d.down.sql",
+			"0005_e.up.sql", "0005_e.down.sql",
+			"0006_f.up.sql", "0006_f.down.sql",
+			"0007_g.up.sql", "0007_g.down.sql",
+			"0008_h.up.sql", "0008_h.down.sql",
+			"0009_i.up.sql", "0009_i.down.sql",
+		}, []string{"1_z", "2_b", "3_c", "4_d", "5_e", "6_f", "7_g", "8_h", "9_i", "10_j"}, ""},
+		{"missing down file", []string{"0001_a.up.sql"}, nil, "needs both an up and a down file"},
+		{"missing up file", []string{"0001_a.down.sql"}, nil, "needs both an up and a down file"},
+		{"two names for a version", []string{"0001_a.up.sql", "0001_b.down.sql"}, nil, "two names"},
+		{"duplicate version", []string{"0001_a.up.sql", "001_a.up.sql", "0001_a.down.sql"}, nil, "more than one up file"},
+		{"gap", []string{"0001_a.up.sql", "0001_a.down.sql", "0003_c.up.sql", "0003_c.down.sql"}, nil, "without gaps"},
+		{"not starting at 1", []string{"0002_b.up.sql", "0002_b.down.sql"}, nil, "without gaps"},
+		{"bad file name", []string{"0001_Initial.up.sql"}, nil, "invalid migration file name"},
+		{"no version", []string{"initial.up.sql"}, nil, "invalid migration file name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := migrationFS(tt.files...)
+			fsys["sql"] = &fstest.MapFile{Mode: os.ModeDir}
+
+			migrations, err := Load(fsys, "sql")
+			if tt.problem != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.problem) {
+					t.Errorf("got %v, want an error with %q", err, tt.problem)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, m := range migrations {
+				got = append(got, fmt.Sprintf("%d_%s", m.Version, m.Name))
+				if !strings.Contains(m.Up, ".up.sql") || !strings.Contains(m.Down, ".down.sql") {
+					t.Errorf("migration %d has scripts %q and %q", m.Version, m.Up, m.Down)
+				}
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		wantUp   string
+		wantDown string
+	}{
+		{"Add users", "0001_add_users.up.sql", "0001_add_users.down.sql"},
+		{"  movies: index on TITLE!  ", "0002_movies_index_on_title.up.sql", "0002_movies_index_on_title.down.sql"},
+		{"../../etc/passwd", "0003_etc_passwd.up.sql", "0003_etc_passwd.down.sql"},
+		{"__keep__digits_2fa__", "0004_keep_digits_2fa.up.sql", "0004_keep_digits_2fa.down.sql"},
+	}
+
+	for _, tt := range tests {
+		up, down, err := Create(dir, tt.name)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.name, err)
+		}
+		if up != filepath.Join(dir, tt.wantUp) || down != filepath.Join(dir, tt.wantDown) {
+			t.Errorf("%q: got %s and %s, want %s and %s", tt.name, up, down, tt.wantUp, tt.wantDown)
+		}
+	}
+
+	// the new files are valid migrations
+	migrations, err := Load(os.DirFS(dir), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != len(tests) {
+		t.Errorf("got %d migrations, want %d", len(migrations), len(tests))
+	}
+}
+
+func TestCreateRefusesEmptyNames(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"", "   ", "!?-", "é"} {
+		_, _, err := Create(dir, name)
+		if err == nil {
+			t.Errorf("%q: got no error", name)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("files were written: %v", entries)
+	}
+}
+
+func TestCreateNumbersAfterTheHighestVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"0001_a.up.sql", "0001_a.down.sql", "0002_b.up.sql", "0002_b.down.sql"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte("select 1;\n"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up, _, err := Create(dir, "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(up) != "0003_c.up.sql" {
+		t.Errorf("got %s", up)
+	}
+
+	// a broken directory is reported rather than numbered around
+	err = os.Remove(filepath.Join(dir, "0002_b.down.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = Create(dir, "d")
+	if err == nil {
+		t.Error("created a migration next to one without a down file")
+	}
+}
+
+// TestStatusIsReadOnly needs the Postgres database in TEST_DSN, like the repository parity
+// tests, and drops its public schema.
+func TestStatusIsReadOnly(t *testing.T) {
+	dsn := os.Getenv("TEST_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DSN is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	_, err = db.ExecContext(ctx, `drop schema public cascade; create schema public`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a database that was never migrated is behind, and stays untouched
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(migrator.Migrations) {
+		t.Errorf("got %d pending migrations, want %d", len(pending), len(migrator.Migrations))
+	}
+
+	var exists bool
+	err = db.QueryRowContext(ctx, `select to_regclass('schema_migrations') is not null`).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists {
+		t.Error("checking the status created schema_migrations")
+	}
+
+	_, err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err = migrator.Pending(ctx)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("after up: got %d pending, %v", len(pending), err)
+	}
+}
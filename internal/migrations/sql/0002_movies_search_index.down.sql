@@ -0,0 +1 @@
+DROP INDEX IF EXISTS public.movies_search_idx;
@@ -0,0 +1,2 @@
+-- The expression must match movieDocument in internal/repository/dbrepo/postgres_dbrepo.go.
+CREATE INDEX IF NOT EXISTS movies_search_idx ON public.movies USING gin ((setweight(to_tsvector('english', coalesce(title, '')), 'A') || setweight(to_tsvector('english', coalesce(description, '')), 'B')));
@@ -0,0 +1,2 @@
+-- Email addresses identify users, so they must be unique regardless of case.
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON public.users (lower(email));
@@ -0,0 +1,5 @@
+-- Users created before roles existed could manage the whole catalog, so they become admins.
+-- New users are viewers unless an admin promotes them.
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS role character varying(20) NOT NULL DEFAULT 'admin'
+    CONSTRAINT users_role_check CHECK (role IN ('admin', 'editor', 'viewer'));
+ALTER TABLE public.users ALTER COLUMN role SET DEFAULT 'viewer';
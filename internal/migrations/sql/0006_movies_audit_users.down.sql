@@ -0,0 +1,3 @@
+ALTER TABLE public.movies
+    DROP COLUMN IF EXISTS created_by,
+    DROP COLUMN IF EXISTS updated_by;
@@ -0,0 +1,4 @@
+-- The users who created and last changed each movie. Deleting a user keeps their movies.
+ALTER TABLE public.movies
+    ADD COLUMN IF NOT EXISTS created_by integer REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS updated_by integer REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE SET NULL;
@@ -0,0 +1,13 @@
+-- Personal API keys. Only the SHA-256 hash of a key is stored; scopes are space separated.
+CREATE TABLE IF NOT EXISTS public.api_keys (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    name character varying(255) NOT NULL,
+    prefix character varying(16) NOT NULL,
+    key_hash character(64) NOT NULL UNIQUE,
+    scopes character varying(255) NOT NULL,
+    last_used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON public.api_keys (user_id);
@@ -0,0 +1,21 @@
+-- Failed login counters, keyed by "account:<email>" or "ip:<address>".
+CREATE TABLE IF NOT EXISTS public.login_throttles (
+    key character varying(320) PRIMARY KEY,
+    failures integer NOT NULL,
+    last_failure_at timestamp without time zone NOT NULL,
+    locked_until timestamp without time zone NOT NULL
+);
+
+-- Security relevant events. Rows outlive the users they mention.
+CREATE TABLE IF NOT EXISTS public.audit_log (
+    id serial PRIMARY KEY,
+    event character varying(64) NOT NULL,
+    user_id integer,
+    actor_id integer,
+    email character varying(255) NOT NULL DEFAULT '',
+    ip character varying(64) NOT NULL DEFAULT '',
+    detail text NOT NULL DEFAULT '',
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON public.audit_log (created_at);
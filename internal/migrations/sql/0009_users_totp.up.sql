@@ -0,0 +1,17 @@
+-- TOTP two-factor authentication. totp_secret is set on enrollment and totp_enabled once the
+-- user has confirmed a code; totp_last_step stops a code from being used twice.
+ALTER TABLE public.users
+    ADD COLUMN IF NOT EXISTS totp_secret character varying(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS totp_last_step bigint NOT NULL DEFAULT 0;
+
+-- Single-use recovery codes for users who lost their authenticator. Only hashes are stored.
+CREATE TABLE IF NOT EXISTS public.recovery_codes (
+    id serial PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    code_hash character(64) NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON public.recovery_codes (user_id);
@@ -0,0 +1,10 @@
+-- Password reset tokens. Only the SHA-256 hash of a token is stored.
+CREATE TABLE IF NOT EXISTS public.password_resets (
+    token_hash character(64) PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    expires_at timestamp without time zone NOT NULL,
+    used_at timestamp without time zone,
+    created_at timestamp without time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON public.password_resets (user_id);
@@ -0,0 +1,11 @@
+-- Accounts at external OpenID Connect providers, linked to our users. A user can have one
+-- identity per provider.
+CREATE TABLE IF NOT EXISTS public.oidc_identities (
+    issuer character varying(255) NOT NULL,
+    subject character varying(255) NOT NULL,
+    user_id integer NOT NULL REFERENCES public.users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    created_at timestamp without time zone NOT NULL,
+    PRIMARY KEY (issuer, subject)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS oidc_identities_user_id_issuer_idx ON public.oidc_identities (user_id, issuer);
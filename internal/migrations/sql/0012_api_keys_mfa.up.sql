@@ -0,0 +1,4 @@
+-- Whether the session that created an API key had passed 2FA. Admin routes only accept keys
+-- that were; keys created before this migration were not checked, so they start as false.
+ALTER TABLE public.api_keys
+    ADD COLUMN IF NOT EXISTS mfa boolean NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS public.login_throttles_last_failure_at_idx;
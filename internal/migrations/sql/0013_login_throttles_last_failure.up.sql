@@ -0,0 +1,2 @@
+-- Stale login counters are pruned by the time of their last failure.
+CREATE INDEX IF NOT EXISTS login_throttles_last_failure_at_idx ON public.login_throttles (last_failure_at);
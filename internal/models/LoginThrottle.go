@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// LoginThrottle counts the recent failed logins for one key: an email address or a client IP.
+// Attempts are counted before they are checked and taken back if they succeed.
+// No login for the key is accepted before LockedUntil.
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// LoginThrottlePolicy says how long a key is locked after each counted login attempt.
+type LoginThrottlePolicy struct {
+	// BaseDelay is the lock after the first attempt, doubling with each further one; zero
+	// for no backoff.
+	BaseDelay time.Duration
+	// MaxAttempts attempts, and each one after, lock the key for Lockout.
+	MaxAttempts int
+	Lockout     time.Duration
+}
+
+// Delay returns the lock after the nth attempt.
+func (p LoginThrottlePolicy) Delay(n int) time.Duration {
+	if n >= p.MaxAttempts {
+		return p.Lockout
+	}
+	if p.BaseDelay <= 0 || n < 1 {
+		return 0
+	}
+	if n > 62 {
+		return p.Lockout
+	}
+
+	delay := p.BaseDelay << (n - 1)
+	if delay <= 0 || delay > p.Lockout {
+		return p.Lockout
+	}
+	return delay
+}
+
+// Events written to the audit log.
+const (
+	AuditLoginLocked   = "login.locked"
+	AuditLoginUnlocked = "login.unlocked"
+)
+
+// AuditEvent is an entry of the audit log. UserID is the user the event is about and ActorID
+// the user who caused it; either is zero when there is no such user.
+type AuditEvent struct {
+	ID        int
+	Event     string
+	UserID    int
+	ActorID   int
+	Email     string
+	IP        string
+	Detail    string
+	CreatedAt time.Time
+}
@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"html"
+	"strings"
+	"time"
+)
 
 type Movie struct {
 	ID int `json:"id"`
@@ -12,6 +16,8 @@ type Movie struct {
 	Image string `json:"image"`
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
+	CreatedBy int `json:"-"` // id of the user who created the movie, 0 if unknown
+	UpdatedBy int `json:"-"` // id of the user who last changed the movie, 0 if unknown
 	Genres []*Genre `json:"genres,omitempty"`
 	GenresArray []int `json:"genres_array,omitempty"`
 }
@@ -22,4 +28,35 @@ type Genre struct {
 	Checked bool `json:"checked"`
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
-}
\ No newline at end of file
+}
+
+// MovieSearchResult is a movie matched by a full-text search, with its relevance and the
+// matched words wrapped in <b></b> tags. The highlights are HTML: everything but the tags is
+// escaped, so they are safe to render.
+type MovieSearchResult struct {
+	Movie *Movie `json:"movie"`
+	Rank float64 `json:"rank"`
+	TitleHighlight string `json:"title_highlight"`
+	DescriptionHighlight string `json:"description_highlight"`
+}
+
+// Markers the repositories put around the matched words of a search, before HighlightHTML
+// turns them into tags. Any already in the text are stripped first, so only matches get tags.
+const (
+	HighlightStart = "\x02"
+	HighlightStop = "\x03"
+)
+
+// HighlightHTML escapes text, which is user content, and then turns the highlight markers
+// into <b></b> tags.
+func HighlightHTML(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, HighlightStart, "<b>")
+	return strings.ReplaceAll(text, HighlightStop, "</b>")
+}
+
+// StripHighlightMarkers removes the highlight markers from text, so that only those added by
+// a search become tags.
+func StripHighlightMarkers(text string) string {
+	return strings.NewReplacer(HighlightStart, "", HighlightStop, "").Replace(text)
+}
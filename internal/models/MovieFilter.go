@@ -0,0 +1,206 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+	DefaultSort     = "title"
+)
+
+// movieSortColumns maps the accepted sort keys to the movies column they order by.
+var movieSortColumns = map[string]string{
+	"title":        "title",
+	"release_date": "release_date",
+	"runtime":      "runtime",
+	"created_at":   "created_at",
+}
+
+// MovieFilter describes one page of a movie listing.
+type MovieFilter struct {
+	Page     int
+	PageSize int
+	// Sort is one of title, release_date, runtime or created_at, prefixed with "-" for
+	// descending order. Ties are always broken by id.
+	Sort string
+
+	MPAARatings     []string
+	ReleaseYearFrom int
+	ReleaseYearTo   int
+	RunTimeMin      int
+	RunTimeMax      int
+	// GenreIDs matches movies that have at least one of the genres.
+	GenreIDs []int
+
+	// Cursor, when set, switches to keyset pagination: the page starts right after the
+	// movie the cursor was issued for, and Page is ignored.
+	Cursor string
+}
+
+// PageMetadata describes where a page sits in the full result set.
+type PageMetadata struct {
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+// MovieCursor is the decoded form of MovieFilter.Cursor: the sort value and id of the last
+// movie on the previous page.
+type MovieCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// Validate fills in defaults and checks the filter for values that cannot be queried.
+func (f *MovieFilter) Validate() error {
+	if f.Page == 0 {
+		f.Page = 1
+	}
+	if f.PageSize == 0 {
+		f.PageSize = DefaultPageSize
+	}
+	if f.Sort == "" {
+		f.Sort = DefaultSort
+	}
+
+	switch {
+	case f.Page < 1:
+		return errors.New("page must be greater than zero")
+	case f.PageSize < 1 || f.PageSize > MaxPageSize:
+		return fmt.Errorf("page_size must be between 1 and %d", MaxPageSize)
+	case f.ReleaseYearFrom < 0 || f.ReleaseYearTo < 0:
+		return errors.New("release year must not be negative")
+	case f.ReleaseYearTo > 0 && f.ReleaseYearFrom > f.ReleaseYearTo:
+		return errors.New("release_year_from must not be after release_year_to")
+	case f.RunTimeMin < 0 || f.RunTimeMax < 0:
+		return errors.New("runtime must not be negative")
+	case f.RunTimeMax > 0 && f.RunTimeMin > f.RunTimeMax:
+		return errors.New("runtime_min must not be greater than runtime_max")
+	}
+
+	if _, ok := movieSortColumns[strings.TrimPrefix(f.Sort, "-")]; !ok {
+		return errors.New("sort must be title, release_date, runtime or created_at, optionally prefixed with -")
+	}
+
+	if f.Cursor != "" {
+		c, err := DecodeMovieCursor(f.Cursor)
+		if err != nil {
+			return err
+		}
+		if c.Sort != f.Sort {
+			return errors.New("cursor was issued for a different sort order")
+		}
+	}
+
+	return nil
+}
+
+// SortColumn returns the movies column the filter orders by.
+func (f MovieFilter) SortColumn() string {
+	return movieSortColumns[strings.TrimPrefix(f.Sort, "-")]
+}
+
+// SortDescending reports whether the filter orders from the highest value down.
+func (f MovieFilter) SortDescending() bool {
+	return strings.HasPrefix(f.Sort, "-")
+}
+
+// Offset returns the number of movies before the current page in offset pagination.
+func (f MovieFilter) Offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// NewPageMetadata calculates the metadata of a page. For keyset pagination, pass a page of
+// zero and the cursor of the next page, if there is one.
+func NewPageMetadata(totalRecords, page, pageSize int, nextCursor string) PageMetadata {
+	metadata := PageMetadata{
+		PageSize:     pageSize,
+		TotalRecords: totalRecords,
+		NextCursor:   nextCursor,
+	}
+
+	if page > 0 {
+		metadata.CurrentPage = page
+		metadata.FirstPage = 1
+		metadata.LastPage = (totalRecords + pageSize - 1) / pageSize
+		if metadata.LastPage == 0 {
+			metadata.LastPage = 1
+		}
+	}
+
+	return metadata
+}
+
+// MovieSortValue returns the value of the column a movie is sorted by, in the text form used
+// in cursors and accepted by Postgres for that column type.
+func MovieSortValue(movie *Movie, column string) string {
+	switch column {
+	case "release_date":
+		return movie.ReleaseDate.Format("2006-01-02")
+	case "runtime":
+		return strconv.Itoa(movie.RunTime)
+	case "created_at":
+		return movie.CreatedAt.Format("2006-01-02 15:04:05.999999")
+	default:
+		return movie.Title
+	}
+}
+
+// EncodeMovieCursor returns the cursor for the page that starts after movie.
+func EncodeMovieCursor(movie *Movie, filter MovieFilter) string {
+	c := MovieCursor{
+		Sort:  filter.Sort,
+		Value: MovieSortValue(movie, filter.SortColumn()),
+		ID:    movie.ID,
+	}
+
+	out, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// DecodeMovieCursor parses a cursor created by EncodeMovieCursor.
+func DecodeMovieCursor(cursor string) (MovieCursor, error) {
+	var c MovieCursor
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, errors.New("invalid cursor")
+	}
+
+	err = json.Unmarshal(raw, &c)
+	if err != nil || c.ID < 1 {
+		return c, errors.New("invalid cursor")
+	}
+
+	column := movieSortColumns[strings.TrimPrefix(c.Sort, "-")]
+	if column == "" {
+		return c, errors.New("invalid cursor")
+	}
+
+	// make sure the value can be compared against its column
+	switch column {
+	case "release_date":
+		_, err = time.Parse("2006-01-02", c.Value)
+	case "runtime":
+		_, err = strconv.Atoi(c.Value)
+	case "created_at":
+		_, err = time.Parse("2006-01-02 15:04:05.999999", c.Value)
+	}
+	if err != nil {
+		return c, errors.New("invalid cursor")
+	}
+
+	return c, nil
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMovieFilterValidate(t *testing.T) {
+	cursor := EncodeMovieCursor(&Movie{ID: 7, Title: "Alien"}, MovieFilter{Sort: "title"})
+
+	tests := []struct {
+		name   string
+		filter MovieFilter
+		// problem is part of the expected error, empty if the filter is valid
+		problem string
+	}{
+		{"defaults", MovieFilter{}, ""},
+		{"every sort key", MovieFilter{Sort: "-created_at"}, ""},
+		{"unknown sort key", MovieFilter{Sort: "rating"}, "sort must be"},
+		{"sort injection", MovieFilter{Sort: "title; drop table movies"}, "sort must be"},
+		{"double minus", MovieFilter{Sort: "--title"}, "sort must be"},
+		{"negative page", MovieFilter{Page: -1}, "page must be"},
+		{"smallest page size", MovieFilter{PageSize: 1}, ""},
+		{"largest page size", MovieFilter{PageSize: MaxPageSize}, ""},
+		{"negative page size", MovieFilter{PageSize: -1}, "page_size"},
+		{"page size too large", MovieFilter{PageSize: MaxPageSize + 1}, "page_size"},
+		{"one release year", MovieFilter{ReleaseYearFrom: 1980, ReleaseYearTo: 1980}, ""},
+		{"open release years", MovieFilter{ReleaseYearFrom: 1980}, ""},
+		{"inverted release years", MovieFilter{ReleaseYearFrom: 1990, ReleaseYearTo: 1980}, "release_year_from"},
+		{"negative release year", MovieFilter{ReleaseYearTo: -1}, "release year"},
+		{"inverted runtimes", MovieFilter{RunTimeMin: 120, RunTimeMax: 90}, "runtime_min"},
+		{"negative runtime", MovieFilter{RunTimeMin: -1}, "runtime"},
+		{"cursor", MovieFilter{Cursor: cursor}, ""},
+		{"cursor of another sort", MovieFilter{Sort: "-title", Cursor: cursor}, "different sort"},
+		{"garbage cursor", MovieFilter{Cursor: "not a cursor"}, "invalid cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.filter
+			err := f.Validate()
+
+			switch {
+			case tt.problem == "" && err != nil:
+				t.Errorf("got %v, want no error", err)
+			case tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)):
+				t.Errorf("got %v, want an error about %s", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestMovieFilterDefaults(t *testing.T) {
+	var f MovieFilter
+	err := f.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Page != 1 || f.PageSize != DefaultPageSize || f.Sort != DefaultSort {
+		t.Errorf("got %+v", f)
+	}
+	if f.SortColumn() != "title" || f.SortDescending() || f.Offset() != 0 {
+		t.Errorf("got column %q, descending %v, offset %d", f.SortColumn(), f.SortDescending(), f.Offset())
+	}
+
+	f = MovieFilter{Page: 3, PageSize: 10, Sort: "-release_date"}
+	if f.SortColumn() != "release_date" || !f.SortDescending() || f.Offset() != 20 {
+		t.Errorf("got column %q, descending %v, offset %d", f.SortColumn(), f.SortDescending(), f.Offset())
+	}
+}
+
+func TestMovieCursorRoundTrip(t *testing.T) {
+	movie := &Movie{
+		ID:          42,
+		Title:       `"Quoted" & ünïcode`,
+		ReleaseDate: time.Date(1979, 5, 25, 0, 0, 0, 0, time.UTC),
+		RunTime:     117,
+		CreatedAt:   time.Date(2024, 2, 29, 13, 4, 5, 123456000, time.UTC),
+	}
+
+	tests := []struct {
+		sort  string
+		value string
+	}{
+		{"title", `"Quoted" & ünïcode`},
+		{"-title", `"Quoted" & ünïcode`},
+		{"release_date", "1979-05-25"},
+		{"runtime", "117"},
+		{"-created_at", "2024-02-29 13:04:05.123456"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			encoded := EncodeMovieCursor(movie, MovieFilter{Sort: tt.sort})
+			if strings.ContainsAny(encoded, "+/=") {
+				t.Errorf("cursor %q is not URL safe", encoded)
+			}
+
+			got, err := DecodeMovieCursor(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (MovieCursor{Sort: tt.sort, Value: tt.value, ID: 42}); got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeTamperedMovieCursor(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "%%%"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"title","v":"a","id":1}`))},
+		{"not JSON", encode("title,a,1")},
+		{"wrong types", encode(`{"s":"title","v":"a","id":"1"}`)},
+		{"no id", encode(`{"s":"title","v":"a"}`)},
+		{"negative id", encode(`{"s":"title","v":"a","id":-1}`)},
+		{"unknown sort", encode(`{"s":"rating","v":"a","id":1}`)},
+		{"sort injection", encode(`{"s":"title desc, id","v":"a","id":1}`)},
+		{"date that is not a date", encode(`{"s":"release_date","v":"1979-05-25' or 1=1","id":1}`)},
+		{"runtime that is not a number", encode(`{"s":"runtime","v":"1 or 1=1","id":1}`)},
+		{"timestamp that is not a timestamp", encode(`{"s":"created_at","v":"yesterday","id":1}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeMovieCursor(tt.cursor)
+			if err == nil || err.Error() != "invalid cursor" {
+				t.Errorf("got %v, want invalid cursor", err)
+			}
+		})
+	}
+}
+
+func TestNewPageMetadata(t *testing.T) {
+	tests := []struct {
+		name                  string
+		total, page, pageSize int
+		current, first, last  int
+	}{
+		{"empty", 0, 1, 20, 1, 1, 1},
+		{"one partial page", 3, 1, 20, 1, 1, 1},
+		{"exactly full pages", 40, 2, 20, 2, 1, 2},
+		{"partial last page", 41, 1, 20, 1, 1, 3},
+		{"keyset", 41, 0, 20, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewPageMetadata(tt.total, tt.page, tt.pageSize, "")
+			if got.CurrentPage != tt.current || got.FirstPage != tt.first || got.LastPage != tt.last ||
+				got.TotalRecords != tt.total || got.PageSize != tt.pageSize {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// PasswordReset is a single-use token that lets a user set a new password without knowing
+// the old one. Only the SHA-256 hash of the token is stored.
+type PasswordReset struct {
+	Hash      string
+	UserID    int
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// NewPasswordReset generates a reset token for the user that is valid for ttl, and returns
+// it in plain text together with the record to store.
+func NewPasswordReset(userID int, ttl time.Duration) (string, PasswordReset, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", PasswordReset{}, err
+	}
+
+	plainText := hex.EncodeToString(b)
+
+	reset := PasswordReset{
+		Hash:      HashPasswordResetToken(plainText),
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+
+	return plainText, reset, nil
+}
+
+// HashPasswordResetToken returns the hash a reset token is stored and looked up by.
+func HashPasswordResetToken(plainText string) string {
+	sum := sha256.Sum256([]byte(plainText))
+	return hex.EncodeToString(sum[:])
+}
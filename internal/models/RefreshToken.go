@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// RefreshToken is the server-side record of an issued refresh token. ID is the token's jti
+// claim. Tokens issued from the same login share a FamilyID.
+type RefreshToken struct {
+	ID        string
+	UserID    int
+	FamilyID  string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
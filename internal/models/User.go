@@ -2,17 +2,51 @@ package models
 
 import (
 	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// PasswordMinLength and PasswordMaxLength bound new passwords. bcrypt ignores everything
+	// after the 72nd byte.
+	PasswordMinLength = 8
+	PasswordMaxLength = 72
+
+	passwordCost = 12
+)
+
+// Roles, from most to least privileged. Every user has exactly one.
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// ValidRole reports whether role is one of the known roles.
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleEditor, RoleViewer:
+		return true
+	}
+	return false
+}
+
 type User struct {
 	ID int `json:"id"`
 	FirstName string `json:"first_name"`
 	LastName string `json:"last_name"`
 	Email string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"-"` // bcrypt hash, never sent to clients
+	Role string `json:"role"`
+	TOTPSecret string `json:"-"` // set on enrollment, used once TOTPEnabled
+	TOTPEnabled bool `json:"-"`
+	TOTPLastStep int64 `json:"-"` // time step of the last accepted code
 	CreatedAt time.Time `json:"-"`
 	UpdatedAt time.Time `json:"-"`
 }
@@ -30,4 +64,78 @@ func (u *User) PasswordMatches(plainText string) (bool, error){
 	}
 
 	return true,nil
-}
\ No newline at end of file
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// PasswordMatchesNoUser does the work of PasswordMatches against a throwaway hash and always
+// returns false. Calling it when no user was found makes a login for an unknown email take
+// as long as one with a wrong password.
+func PasswordMatchesNoUser(plainText string) bool {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("no such user"), passwordCost)
+	})
+
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(plainText))
+	return false
+}
+
+// SetPassword replaces the user's password with a bcrypt hash of plainText.
+func (u *User) SetPassword(plainText string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(plainText), passwordCost)
+	if err != nil {
+		return err
+	}
+
+	u.Password = string(hash)
+	return nil
+}
+
+// NormalizeEmail trims and lower-cases an email address, so that lookups and uniqueness
+// checks do not depend on how the user typed it.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ValidateEmail checks that email is a single bare address, such as user@example.com.
+func ValidateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return errors.New("email must be a valid email address")
+	}
+	return nil
+}
+
+// ValidatePassword checks a new password against the strength rules: between
+// PasswordMinLength and PasswordMaxLength bytes, at least one letter and one digit, and
+// not the user's email address.
+func ValidatePassword(password, email string) error {
+	if len(password) < PasswordMinLength {
+		return fmt.Errorf("password must be at least %d characters long", PasswordMinLength)
+	}
+	if len(password) > PasswordMaxLength {
+		return fmt.Errorf("password must be at most %d bytes long", PasswordMaxLength)
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return errors.New("password must contain at least one letter and one digit")
+	}
+
+	if email != "" && strings.EqualFold(password, email) {
+		return errors.New("password must not be the email address")
+	}
+
+	return nil
+}
@@ -0,0 +1,1589 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryDBRepo is a thread-safe, in-memory implementation of repository.DatabaseRepo.
+// It mirrors the behavior of PostgresDBRepo (ordering, genre filtering, the same domain
+// errors for missing rows, cascading deletes) so handlers can be exercised without a database.
+// Every method returns ctx.Err() if the context is already done.
+type MemoryDBRepo struct {
+	// writeMu serializes writers, including whole transactions; mu guards the data below
+	writeMu sync.Mutex
+	mu      sync.RWMutex
+
+	movies         map[int]models.Movie
+	genres         map[int]models.Genre
+	moviesGenres   []movieGenre
+	users          map[int]models.User
+	refreshTokens  map[string]models.RefreshToken
+	apiKeys        map[int]models.APIKey
+	loginThrottles map[string]models.LoginThrottle
+	auditLog       []models.AuditEvent
+	recoveryCodes  []recoveryCode
+	passwordResets map[string]models.PasswordReset
+	oidcIdentities []oidcIdentity
+
+	nextMovieID  int
+	nextGenreID  int
+	nextUserID   int
+	nextAPIKeyID int
+}
+
+// oidcIdentity is a row of the oidc_identities table.
+type oidcIdentity struct {
+	Issuer  string
+	Subject string
+	UserID  int
+}
+
+// recoveryCode is a row of the recovery_codes table.
+type recoveryCode struct {
+	UserID int
+	Hash   string
+	Used   bool
+}
+
+// movieGenre is a row of the movies_genres table.
+type movieGenre struct {
+	MovieID int
+	GenreID int
+}
+
+// NewMemoryDBRepo returns an empty MemoryDBRepo.
+func NewMemoryDBRepo() *MemoryDBRepo {
+	return &MemoryDBRepo{
+		movies:         make(map[int]models.Movie),
+		genres:         make(map[int]models.Genre),
+		users:          make(map[int]models.User),
+		refreshTokens:  make(map[string]models.RefreshToken),
+		apiKeys:        make(map[int]models.APIKey),
+		loginThrottles: make(map[string]models.LoginThrottle),
+		passwordResets: make(map[string]models.PasswordReset),
+		nextMovieID:    1,
+		nextGenreID:    1,
+		nextUserID:     1,
+		nextAPIKeyID:   1,
+	}
+}
+
+// NewSeededMemoryDBRepo returns a MemoryDBRepo populated with the same genres, movies
+// and admin user as sql/create_tables.sql.
+func NewSeededMemoryDBRepo() *MemoryDBRepo {
+	m := NewMemoryDBRepo()
+
+	seeded := time.Date(2022, 9, 23, 0, 0, 0, 0, time.UTC)
+
+	for _, g := range []string{
+		"Comedy", "Sci-Fi", "Horror", "Romance", "Action", "Thriller",
+		"Drama", "Mystery", "Crime", "Animation", "Adventure", "Fantasy", "Superhero",
+	} {
+		m.InsertGenre(models.Genre{Genre: g, CreatedAt: seeded, UpdatedAt: seeded})
+	}
+
+	movies := []struct {
+		movie  models.Movie
+		genres []int
+	}{
+		{
+			movie: models.Movie{
+				Title:       "Highlander",
+				ReleaseDate: time.Date(1986, 3, 7, 0, 0, 0, 0, time.UTC),
+				RunTime:     116,
+				MPAARating:  "R",
+				Description: "He fought his first battle on the Scottish Highlands in 1536. He will fight his greatest battle on the streets of New York City in 1986. His name is Connor MacLeod. He is immortal.",
+				Image:       "/8Z8dptJEypuLoOQro1WugD855YE.jpg",
+			},
+			genres: []int{5, 12},
+		},
+		{
+			movie: models.Movie{
+				Title:       "Raiders of the Lost Ark",
+				ReleaseDate: time.Date(1981, 6, 12, 0, 0, 0, 0, time.UTC),
+				RunTime:     115,
+				MPAARating:  "PG-13",
+				Description: "Archaeology professor Indiana Jones ventures to seize a biblical artefact known as the Ark of the Covenant. While doing so, he puts up a fight against Renee and a troop of Nazis.",
+				Image:       "/ceG9VzoRAVGwivFU403Wc3AHRys.jpg",
+			},
+			genres: []int{5, 11},
+		},
+		{
+			movie: models.Movie{
+				Title:       "The Godfather",
+				ReleaseDate: time.Date(1972, 3, 24, 0, 0, 0, 0, time.UTC),
+				RunTime:     175,
+				MPAARating:  "18A",
+				Description: "The aging patriarch of an organized crime dynasty in postwar New York City transfers control of his clandestine empire to his reluctant youngest son.",
+				Image:       "/3bhkrj58Vtu7enYsRolD1fZdja1.jpg",
+			},
+			genres: []int{9, 7},
+		},
+	}
+
+	for _, s := range movies {
+		s.movie.CreatedAt = seeded
+		s.movie.UpdatedAt = seeded
+		id, _ := m.InsertMovie(context.Background(), s.movie)
+		_ = m.UpdateMovieGenres(context.Background(), id, s.genres)
+	}
+
+	_, _ = m.InsertUser(context.Background(), models.User{
+		FirstName: "Admin",
+		LastName:  "User",
+		Email:     "admin@example.com",
+		Password:  "$2a$14$wVsaPvJnJJsomWArouWCtusem6S/.Gauq/GjOIEHpyh2DAMmso1wy",
+		Role:      models.RoleAdmin,
+		CreatedAt: seeded,
+		UpdatedAt: seeded,
+	})
+
+	return m
+}
+
+// Connection returns nil, since there is no underlying database.
+func (m *MemoryDBRepo) Connection() *sql.DB {
+	return nil
+}
+
+// WithTx runs fn against a private copy of the data, which replaces the data only if fn
+// returns nil. Writers are serialized for the duration of the transaction, while readers
+// keep seeing the last committed data. fn must only use the repository it is given;
+// writing through m from inside fn deadlocks.
+func (m *MemoryDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.writeMu.Lock()
+	defer m.writeMu.Unlock()
+
+	m.mu.RLock()
+	tx := m.clone()
+	m.mu.RUnlock()
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	// a cancelled context rolls back, as it does for a database transaction
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.movies = tx.movies
+	m.genres = tx.genres
+	m.moviesGenres = tx.moviesGenres
+	m.users = tx.users
+	m.refreshTokens = tx.refreshTokens
+	m.apiKeys = tx.apiKeys
+	m.loginThrottles = tx.loginThrottles
+	m.auditLog = tx.auditLog
+	m.recoveryCodes = tx.recoveryCodes
+	m.passwordResets = tx.passwordResets
+	m.oidcIdentities = tx.oidcIdentities
+	m.nextMovieID = tx.nextMovieID
+	m.nextGenreID = tx.nextGenreID
+	m.nextUserID = tx.nextUserID
+	m.nextAPIKeyID = tx.nextAPIKeyID
+
+	return nil
+}
+
+// clone returns a deep copy of the data. The caller must hold m.mu.
+func (m *MemoryDBRepo) clone() *MemoryDBRepo {
+	c := NewMemoryDBRepo()
+
+	for id, movie := range m.movies {
+		c.movies[id] = movie
+	}
+	for id, genre := range m.genres {
+		c.genres[id] = genre
+	}
+	for id, user := range m.users {
+		c.users[id] = user
+	}
+	for id, token := range m.refreshTokens {
+		c.refreshTokens[id] = token
+	}
+	for id, key := range m.apiKeys {
+		c.apiKeys[id] = key
+	}
+	for key, throttle := range m.loginThrottles {
+		c.loginThrottles[key] = throttle
+	}
+	c.auditLog = append([]models.AuditEvent(nil), m.auditLog...)
+	c.recoveryCodes = append([]recoveryCode(nil), m.recoveryCodes...)
+	for hash, reset := range m.passwordResets {
+		c.passwordResets[hash] = reset
+	}
+	c.oidcIdentities = append([]oidcIdentity(nil), m.oidcIdentities...)
+	c.moviesGenres = append([]movieGenre(nil), m.moviesGenres...)
+	c.nextMovieID = m.nextMovieID
+	c.nextGenreID = m.nextGenreID
+	c.nextUserID = m.nextUserID
+	c.nextAPIKeyID = m.nextAPIKeyID
+
+	return c
+}
+
+// lockForWrite acquires the locks needed to modify the data and returns a function releasing them.
+func (m *MemoryDBRepo) lockForWrite() func() {
+	m.writeMu.Lock()
+	m.mu.Lock()
+
+	return func() {
+		m.mu.Unlock()
+		m.writeMu.Unlock()
+	}
+}
+
+// InsertGenre adds a genre and returns its id. It is not part of repository.DatabaseRepo
+// and exists so tests can seed data.
+func (m *MemoryDBRepo) InsertGenre(genre models.Genre) int {
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	genre.ID = m.nextGenreID
+	genre.Checked = false
+	m.nextGenreID++
+	m.genres[genre.ID] = genre
+
+	return genre.ID
+}
+
+// AllMovies returns a slice of movies, sorted by name.
+func (m *MemoryDBRepo) AllMovies(ctx context.Context, genre ...int) ([]*models.Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var movies []*models.Movie
+
+	for _, movie := range m.movies {
+		if len(genre) > 0 && !m.hasGenre(movie.ID, genre[0]) {
+			continue
+		}
+
+		movie := movie
+		movie.Genres = nil
+		movie.GenresArray = nil
+		movies = append(movies, &movie)
+	}
+
+	sortMoviesByTitle(movies)
+
+	return movies, nil
+}
+
+// ListMovies returns one page of the movies matching filter, along with the page metadata.
+// The filter must have been validated.
+func (m *MemoryDBRepo) ListMovies(ctx context.Context, filter models.MovieFilter) ([]*models.Movie, models.PageMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, models.PageMetadata{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var matches []*models.Movie
+
+	for _, movie := range m.movies {
+		if !m.matchesFilter(movie, filter) {
+			continue
+		}
+
+		movie := movie
+		movie.Genres = nil
+		movie.GenresArray = nil
+		matches = append(matches, &movie)
+	}
+
+	column := filter.SortColumn()
+	desc := filter.SortDescending()
+
+	// before orders a ahead of b: by the sort column, then by id, both in the filter's direction
+	before := func(a, b *models.Movie) bool {
+		c := compareMovies(a, b, column)
+		if c == 0 {
+			c = a.ID - b.ID
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return before(matches[i], matches[j])
+	})
+
+	total := len(matches)
+	page := filter.Page
+	start := filter.Offset()
+
+	if filter.Cursor != "" {
+		// keyset pagination: continue right after the last movie of the previous page
+		page = 0
+		c, err := models.DecodeMovieCursor(filter.Cursor)
+		if err != nil {
+			return nil, models.PageMetadata{}, err
+		}
+
+		start = len(matches)
+		for i, movie := range matches {
+			cmp := compareCursor(movie, column, c)
+			if (!desc && cmp > 0) || (desc && cmp < 0) {
+				start = i
+				break
+			}
+		}
+	}
+
+	if start > len(matches) {
+		start = len(matches)
+	}
+	end := start + filter.PageSize
+	if end > len(matches) {
+		end = len(matches)
+	}
+
+	movies := matches[start:end]
+
+	nextCursor := ""
+	if end < len(matches) && len(movies) > 0 {
+		nextCursor = models.EncodeMovieCursor(movies[len(movies)-1], filter)
+	}
+
+	return movies, models.NewPageMetadata(total, page, filter.PageSize, nextCursor), nil
+}
+
+// SearchMovies approximates the Postgres full-text search: a movie matches when every word of
+// the query starts a word of its title or description. Title matches rank higher than
+// description matches, and matched words are wrapped in <b></b> tags in the escaped text.
+func (m *MemoryDBRepo) SearchMovies(ctx context.Context, query string, limit int) ([]*models.MovieSearchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	terms := searchWords(strings.ToLower(query))
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var results []*models.MovieSearchResult
+
+	for _, movie := range m.movies {
+		titleHits := countMatches(movie.Title, terms)
+		descriptionHits := countMatches(movie.Description, terms)
+
+		matchedAll := true
+		for _, term := range terms {
+			if countMatches(movie.Title, []string{term}) == 0 && countMatches(movie.Description, []string{term}) == 0 {
+				matchedAll = false
+				break
+			}
+		}
+		if !matchedAll {
+			continue
+		}
+
+		movie := movie
+		movie.Genres = nil
+		movie.GenresArray = nil
+
+		results = append(results, &models.MovieSearchResult{
+			Movie:                &movie,
+			Rank:                 float64(titleHits) + 0.4*float64(descriptionHits),
+			TitleHighlight:       highlight(movie.Title, terms),
+			DescriptionHighlight: highlight(movie.Description, terms),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		if a.Movie.Title != b.Movie.Title {
+			return collate(a.Movie.Title, b.Movie.Title)
+		}
+		return a.Movie.ID < b.Movie.ID
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results, nil
+}
+
+func (m *MemoryDBRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movie, ok := m.movies[id]
+	if !ok {
+		return nil, repository.ErrMovieNotFound.Wrap(sql.ErrNoRows)
+	}
+
+	genres := m.genresForMovie(id)
+	for _, g := range genres {
+		g.CreatedAt = time.Time{}
+		g.UpdatedAt = time.Time{}
+	}
+
+	movie.Genres = genres
+	movie.GenresArray = nil
+
+	return &movie, nil
+}
+
+func (m *MemoryDBRepo) OneMovieForEdit(ctx context.Context, id int) (*models.Movie, []*models.Genre, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	movie, ok := m.movies[id]
+	if !ok {
+		return nil, nil, repository.ErrMovieNotFound.Wrap(sql.ErrNoRows)
+	}
+
+	genres := m.genresForMovie(id)
+
+	var genresArray []int
+	for _, g := range genres {
+		g.CreatedAt = time.Time{}
+		g.UpdatedAt = time.Time{}
+		genresArray = append(genresArray, g.ID)
+	}
+
+	movie.Genres = genres
+	movie.GenresArray = genresArray
+
+	var allGenres []*models.Genre
+	for _, g := range m.sortedGenres() {
+		g.CreatedAt = time.Time{}
+		g.UpdatedAt = time.Time{}
+		g.Checked = containsInt(genresArray, g.ID)
+		allGenres = append(allGenres, g)
+	}
+
+	return &movie, allGenres, nil
+}
+
+func (m *MemoryDBRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) {
+			return &user, nil
+		}
+	}
+
+	return nil, repository.ErrUserNotFound.Wrap(sql.ErrNoRows)
+}
+
+func (m *MemoryDBRepo) GetUserById(ctx context.Context, id int) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound.Wrap(sql.ErrNoRows)
+	}
+
+	return &user, nil
+}
+
+// InsertUser adds a user and returns its id. user.Password must already be a bcrypt hash.
+func (m *MemoryDBRepo) InsertUser(ctx context.Context, user models.User) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	if m.emailTaken(user.Email, 0) {
+		return 0, repository.ErrDuplicateEmail
+	}
+
+	if user.Role == "" {
+		user.Role = models.RoleViewer
+	}
+
+	// 2FA starts disabled, as the users columns default to
+	user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep = "", false, 0
+
+	user.ID = m.nextUserID
+	user.CreatedAt = user.CreatedAt.Truncate(time.Microsecond)
+	user.UpdatedAt = user.UpdatedAt.Truncate(time.Microsecond)
+	m.nextUserID++
+	m.users[user.ID] = user
+
+	return user.ID, nil
+}
+
+// UpdateUser updates a user's profile: names and email address. The password is changed
+// with UpdateUserPassword.
+func (m *MemoryDBRepo) UpdateUser(ctx context.Context, user models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	existing, ok := m.users[user.ID]
+	if !ok {
+		return nil
+	}
+
+	if m.emailTaken(user.Email, user.ID) {
+		return repository.ErrDuplicateEmail
+	}
+
+	existing.Email = user.Email
+	existing.FirstName = user.FirstName
+	existing.LastName = user.LastName
+	existing.UpdatedAt = user.UpdatedAt.Truncate(time.Microsecond)
+	m.users[user.ID] = existing
+
+	return nil
+}
+
+// UpdateUserPassword replaces a user's password hash.
+func (m *MemoryDBRepo) UpdateUserPassword(ctx context.Context, id int, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil
+	}
+
+	user.Password = hash
+	user.UpdatedAt = time.Now().Truncate(time.Microsecond)
+	m.users[id] = user
+
+	return nil
+}
+
+// UpdateUserRole changes a user's role. Tokens already issued keep the old role until
+// they are refreshed.
+func (m *MemoryDBRepo) UpdateUserRole(ctx context.Context, id int, role string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !models.ValidRole(role) {
+		return fmt.Errorf("new row for relation \"users\" violates check constraint \"users_role_check\": role %q", role)
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	user, ok := m.users[id]
+	if !ok {
+		return nil
+	}
+
+	user.Role = role
+	user.UpdatedAt = time.Now().Truncate(time.Microsecond)
+	m.users[id] = user
+
+	return nil
+}
+
+func (m *MemoryDBRepo) DeleteUser(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	delete(m.users, id)
+
+	// movies.created_by and updated_by are set to NULL by the foreign keys in Postgres
+	for movieID, movie := range m.movies {
+		if movie.CreatedBy == id || movie.UpdatedBy == id {
+			if movie.CreatedBy == id {
+				movie.CreatedBy = 0
+			}
+			if movie.UpdatedBy == id {
+				movie.UpdatedBy = 0
+			}
+			m.movies[movieID] = movie
+		}
+	}
+
+	// refresh_tokens rows are removed by the on delete cascade foreign key in Postgres
+	for tokenID, token := range m.refreshTokens {
+		if token.UserID == id {
+			delete(m.refreshTokens, tokenID)
+		}
+	}
+
+	// and so are api_keys, recovery_codes, password_resets and oidc_identities rows
+	for keyID, key := range m.apiKeys {
+		if key.UserID == id {
+			delete(m.apiKeys, keyID)
+		}
+	}
+	m.deleteRecoveryCodes(id)
+	m.deletePasswordResets(id)
+
+	identities := m.oidcIdentities[:0]
+	for _, identity := range m.oidcIdentities {
+		if identity.UserID != id {
+			identities = append(identities, identity)
+		}
+	}
+	m.oidcIdentities = identities
+
+	return nil
+}
+
+func (m *MemoryDBRepo) GetUserByOIDCIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, identity := range m.oidcIdentities {
+		if identity.Issuer == issuer && identity.Subject == subject {
+			user, ok := m.users[identity.UserID]
+			if !ok {
+				break
+			}
+			return &user, nil
+		}
+	}
+
+	return nil, repository.ErrUserNotFound.Wrap(sql.ErrNoRows)
+}
+
+func (m *MemoryDBRepo) LinkOIDCIdentity(ctx context.Context, issuer, subject string, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	if _, ok := m.users[userID]; !ok {
+		return fmt.Errorf("insert or update on table \"oidc_identities\" violates foreign key constraint \"oidc_identities_user_id_fkey\": user %d does not exist", userID)
+	}
+	for _, identity := range m.oidcIdentities {
+		if identity.Issuer == issuer && (identity.Subject == subject || identity.UserID == userID) {
+			return fmt.Errorf("duplicate key value violates unique constraint \"oidc_identities_pkey\"")
+		}
+	}
+
+	m.oidcIdentities = append(m.oidcIdentities, oidcIdentity{Issuer: issuer, Subject: subject, UserID: userID})
+
+	return nil
+}
+
+func (m *MemoryDBRepo) EnrollUserTOTP(ctx context.Context, userID int, secret string, recoveryCodeHashes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.UpdatedAt = time.Now().Truncate(time.Microsecond)
+	m.users[userID] = user
+
+	m.deleteRecoveryCodes(userID)
+	for _, hash := range recoveryCodeHashes {
+		m.recoveryCodes = append(m.recoveryCodes, recoveryCode{UserID: userID, Hash: hash})
+	}
+
+	return nil
+}
+
+func (m *MemoryDBRepo) EnableUserTOTP(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	user, ok := m.users[userID]
+	if !ok || user.TOTPSecret == "" {
+		return nil
+	}
+
+	user.TOTPEnabled = true
+	user.UpdatedAt = time.Now().Truncate(time.Microsecond)
+	m.users[userID] = user
+
+	return nil
+}
+
+func (m *MemoryDBRepo) DisableUserTOTP(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.UpdatedAt = time.Now().Truncate(time.Microsecond)
+	m.users[userID] = user
+
+	m.deleteRecoveryCodes(userID)
+
+	return nil
+}
+
+func (m *MemoryDBRepo) UpdateUserTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	user, ok := m.users[userID]
+	if !ok || user.TOTPLastStep >= step {
+		return false, nil
+	}
+
+	user.TOTPLastStep = step
+	m.users[userID] = user
+
+	return true, nil
+}
+
+func (m *MemoryDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	for i, code := range m.recoveryCodes {
+		if code.UserID == userID && code.Hash == hash && !code.Used {
+			m.recoveryCodes[i].Used = true
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// deleteRecoveryCodes removes all recovery codes of a user. The caller must hold m.mu.
+func (m *MemoryDBRepo) deleteRecoveryCodes(userID int) {
+	kept := m.recoveryCodes[:0]
+	for _, code := range m.recoveryCodes {
+		if code.UserID != userID {
+			kept = append(kept, code)
+		}
+	}
+	m.recoveryCodes = kept
+}
+
+func (m *MemoryDBRepo) InsertPasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	if _, ok := m.users[reset.UserID]; !ok {
+		return fmt.Errorf("insert or update on table \"password_resets\" violates foreign key constraint \"password_resets_user_id_fkey\": user %d does not exist", reset.UserID)
+	}
+	if _, ok := m.passwordResets[reset.Hash]; ok {
+		return fmt.Errorf("duplicate key value violates unique constraint \"password_resets_pkey\"")
+	}
+
+	reset.UsedAt = nil
+	reset.ExpiresAt = reset.ExpiresAt.Truncate(time.Microsecond)
+	reset.CreatedAt = reset.CreatedAt.Truncate(time.Microsecond)
+	m.passwordResets[reset.Hash] = reset
+
+	return nil
+}
+
+func (m *MemoryDBRepo) UsePasswordReset(ctx context.Context, hash string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	now := time.Now().Truncate(time.Microsecond)
+
+	reset, ok := m.passwordResets[hash]
+	if !ok || reset.UsedAt != nil || !reset.ExpiresAt.After(now) {
+		return 0, repository.ErrPasswordResetNotFound.Wrap(sql.ErrNoRows)
+	}
+
+	reset.UsedAt = &now
+	m.passwordResets[hash] = reset
+
+	return reset.UserID, nil
+}
+
+func (m *MemoryDBRepo) DeleteUserPasswordResets(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	m.deletePasswordResets(userID)
+
+	return nil
+}
+
+// deletePasswordResets removes all reset tokens of a user. The caller must hold m.mu.
+func (m *MemoryDBRepo) deletePasswordResets(userID int) {
+	for hash, reset := range m.passwordResets {
+		if reset.UserID == userID {
+			delete(m.passwordResets, hash)
+		}
+	}
+}
+
+func (m *MemoryDBRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	if _, ok := m.users[token.UserID]; !ok {
+		return fmt.Errorf("insert or update on table \"refresh_tokens\" violates foreign key constraint \"refresh_tokens_user_id_fkey\": user %d does not exist", token.UserID)
+	}
+	if _, ok := m.refreshTokens[token.ID]; ok {
+		return fmt.Errorf("duplicate key value violates unique constraint \"refresh_tokens_pkey\": %s", token.ID)
+	}
+
+	token.UsedAt = nil
+	token.RevokedAt = nil
+	m.refreshTokens[token.ID] = token
+
+	return nil
+}
+
+func (m *MemoryDBRepo) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	token, ok := m.refreshTokens[id]
+	if !ok {
+		return nil, repository.ErrRefreshTokenNotFound.Wrap(sql.ErrNoRows)
+	}
+
+	return &token, nil
+}
+
+func (m *MemoryDBRepo) MarkRefreshTokenUsed(ctx context.Context, id string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	token, ok := m.refreshTokens[id]
+	if !ok || token.UsedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now().Truncate(time.Microsecond)
+	token.UsedAt = &now
+	m.refreshTokens[id] = token
+
+	return true, nil
+}
+
+func (m *MemoryDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	m.revokeRefreshTokens(func(token models.RefreshToken) bool {
+		return token.FamilyID == familyID
+	})
+
+	return nil
+}
+
+func (m *MemoryDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	m.revokeRefreshTokens(func(token models.RefreshToken) bool {
+		return token.UserID == userID
+	})
+
+	return nil
+}
+
+func (m *MemoryDBRepo) InsertAPIKey(ctx context.Context, key models.APIKey) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	if _, ok := m.users[key.UserID]; !ok {
+		return 0, fmt.Errorf("insert or update on table \"api_keys\" violates foreign key constraint \"api_keys_user_id_fkey\": user %d does not exist", key.UserID)
+	}
+	for _, k := range m.apiKeys {
+		if k.Hash == key.Hash {
+			return 0, fmt.Errorf("duplicate key value violates unique constraint \"api_keys_key_hash_key\"")
+		}
+	}
+
+	key.ID = m.nextAPIKeyID
+	key.Scopes = append([]string(nil), key.Scopes...)
+	key.LastUsedAt = nil
+	key.CreatedAt = key.CreatedAt.Truncate(time.Microsecond)
+	m.apiKeys[key.ID] = key
+	m.nextAPIKeyID++
+
+	return key.ID, nil
+}
+
+func (m *MemoryDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.apiKeys {
+		if key.Hash == hash {
+			return copyAPIKey(key), nil
+		}
+	}
+
+	return nil, repository.ErrAPIKeyNotFound.Wrap(sql.ErrNoRows)
+}
+
+func (m *MemoryDBRepo) UserAPIKeys(ctx context.Context, userID int) ([]*models.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []*models.APIKey
+	for _, key := range m.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, copyAPIKey(key))
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+
+	return keys, nil
+}
+
+func (m *MemoryDBRepo) DeleteAPIKey(ctx context.Context, userID, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	key, ok := m.apiKeys[id]
+	if !ok || key.UserID != userID {
+		return repository.ErrAPIKeyNotFound.Wrap(sql.ErrNoRows)
+	}
+
+	delete(m.apiKeys, id)
+
+	return nil
+}
+
+func (m *MemoryDBRepo) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	key, ok := m.apiKeys[id]
+	if !ok {
+		return nil
+	}
+
+	usedAt = usedAt.Truncate(time.Microsecond)
+	key.LastUsedAt = &usedAt
+	m.apiKeys[id] = key
+
+	return nil
+}
+
+func (m *MemoryDBRepo) GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	throttle, ok := m.loginThrottles[key]
+	if !ok {
+		return nil, repository.ErrLoginThrottleNotFound.Wrap(sql.ErrNoRows)
+	}
+
+	return &throttle, nil
+}
+
+func (m *MemoryDBRepo) ReserveLoginAttempt(ctx context.Context, key string, at, resetBefore time.Time, policy models.LoginThrottlePolicy) (*models.LoginThrottle, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	at = at.Truncate(time.Microsecond)
+
+	throttle, ok := m.loginThrottles[key]
+	if ok && throttle.LockedUntil.After(at) {
+		return &throttle, false, nil
+	}
+	if !ok || throttle.LastFailureAt.Before(resetBefore) {
+		throttle = models.LoginThrottle{Key: key}
+	}
+
+	throttle.Failures++
+	throttle.LastFailureAt = at
+	throttle.LockedUntil = at.Add(policy.Delay(throttle.Failures)).Truncate(time.Microsecond)
+	m.loginThrottles[key] = throttle
+
+	return &throttle, true, nil
+}
+
+func (m *MemoryDBRepo) ReleaseLoginAttempt(ctx context.Context, key string, lockedUntil, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	throttle, ok := m.loginThrottles[key]
+	if !ok {
+		return nil
+	}
+
+	if throttle.Failures > 0 {
+		throttle.Failures--
+	}
+	if throttle.LockedUntil.Equal(lockedUntil) {
+		throttle.LockedUntil = at.Truncate(time.Microsecond)
+	}
+	m.loginThrottles[key] = throttle
+
+	return nil
+}
+
+func (m *MemoryDBRepo) ClearLoginThrottle(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	delete(m.loginThrottles, key)
+
+	return nil
+}
+
+func (m *MemoryDBRepo) PruneLoginThrottles(ctx context.Context, resetBefore, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	n := 0
+	for key, throttle := range m.loginThrottles {
+		if throttle.LastFailureAt.Before(resetBefore) && !throttle.LockedUntil.After(now) {
+			delete(m.loginThrottles, key)
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (m *MemoryDBRepo) InsertAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	event.ID = len(m.auditLog) + 1
+	event.CreatedAt = event.CreatedAt.Truncate(time.Microsecond)
+	m.auditLog = append(m.auditLog, event)
+
+	return nil
+}
+
+// copyAPIKey returns a copy of key that shares no memory with the stored one.
+func copyAPIKey(key models.APIKey) *models.APIKey {
+	key.Scopes = append([]string(nil), key.Scopes...)
+	return &key
+}
+
+// revokeRefreshTokens revokes the tokens for which match returns true. The caller must hold m.mu.
+func (m *MemoryDBRepo) revokeRefreshTokens(match func(token models.RefreshToken) bool) {
+	now := time.Now().Truncate(time.Microsecond)
+
+	for id, token := range m.refreshTokens {
+		if token.RevokedAt == nil && match(token) {
+			token.RevokedAt = &now
+			m.refreshTokens[id] = token
+		}
+	}
+}
+
+// emailTaken reports whether a user other than exceptID has the email address, compared
+// case-insensitively like the users_email_key index. The caller must hold m.mu.
+func (m *MemoryDBRepo) emailTaken(email string, exceptID int) bool {
+	for _, u := range m.users {
+		if u.ID != exceptID && strings.EqualFold(u.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemoryDBRepo) AllGenres(ctx context.Context) ([]*models.Genre, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedGenres(), nil
+}
+
+func (m *MemoryDBRepo) InsertMovie(ctx context.Context, movie models.Movie) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	movie.ID = m.nextMovieID
+	movie.ReleaseDate = truncateToDate(movie.ReleaseDate)
+	movie.CreatedAt = movie.CreatedAt.Truncate(time.Microsecond)
+	movie.UpdatedAt = movie.UpdatedAt.Truncate(time.Microsecond)
+	movie.Genres = nil
+	movie.GenresArray = nil
+	m.nextMovieID++
+	m.movies[movie.ID] = movie
+
+	return movie.ID, nil
+}
+
+func (m *MemoryDBRepo) UpdateMovie(ctx context.Context, movie models.Movie) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	existing, ok := m.movies[movie.ID]
+	if !ok {
+		return repository.ErrMovieNotFound.Wrap(sql.ErrNoRows)
+	}
+
+	existing.Title = movie.Title
+	existing.Description = movie.Description
+	existing.ReleaseDate = truncateToDate(movie.ReleaseDate)
+	existing.RunTime = movie.RunTime
+	existing.MPAARating = movie.MPAARating
+	existing.UpdatedAt = movie.UpdatedAt.Truncate(time.Microsecond)
+	existing.Image = movie.Image
+	existing.UpdatedBy = movie.UpdatedBy
+	m.movies[movie.ID] = existing
+
+	return nil
+}
+
+func (m *MemoryDBRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	// check the foreign keys first so a failure leaves the previous genres in place,
+	// like the transaction in PostgresDBRepo
+	for _, n := range genreIDs {
+		if _, ok := m.movies[id]; !ok {
+			return repository.ErrMovieNotFound.Wrap(fmt.Errorf("insert or update on table \"movies_genres\" violates foreign key constraint \"movies_genres_movie_id_fkey\": movie %d does not exist", id))
+		}
+		if _, ok := m.genres[n]; !ok {
+			return repository.ErrUnknownGenre.Wrap(fmt.Errorf("insert or update on table \"movies_genres\" violates foreign key constraint \"movies_genres_genre_id_fkey\": genre %d does not exist", n))
+		}
+	}
+
+	var kept []movieGenre
+	for _, mg := range m.moviesGenres {
+		if mg.MovieID != id {
+			kept = append(kept, mg)
+		}
+	}
+
+	for _, n := range genreIDs {
+		kept = append(kept, movieGenre{MovieID: id, GenreID: n})
+	}
+	m.moviesGenres = kept
+
+	return nil
+}
+
+func (m *MemoryDBRepo) DeleteMovie(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	unlock := m.lockForWrite()
+	defer unlock()
+
+	if _, ok := m.movies[id]; !ok {
+		return repository.ErrMovieNotFound.Wrap(sql.ErrNoRows)
+	}
+	delete(m.movies, id)
+
+	// movies_genres rows are removed by the on delete cascade foreign key in Postgres
+	var kept []movieGenre
+	for _, mg := range m.moviesGenres {
+		if mg.MovieID != id {
+			kept = append(kept, mg)
+		}
+	}
+	m.moviesGenres = kept
+
+	return nil
+}
+
+// hasGenre reports whether the movie is linked to the genre. The caller must hold m.mu.
+func (m *MemoryDBRepo) hasGenre(movieID, genreID int) bool {
+	for _, mg := range m.moviesGenres {
+		if mg.MovieID == movieID && mg.GenreID == genreID {
+			return true
+		}
+	}
+	return false
+}
+
+// genresForMovie returns copies of the genres linked to a movie, sorted by name.
+// The caller must hold m.mu.
+func (m *MemoryDBRepo) genresForMovie(movieID int) []*models.Genre {
+	var genres []*models.Genre
+	for _, mg := range m.moviesGenres {
+		if mg.MovieID != movieID {
+			continue
+		}
+		g := m.genres[mg.GenreID]
+		genres = append(genres, &g)
+	}
+
+	sortGenresByName(genres)
+
+	return genres
+}
+
+// sortedGenres returns copies of all genres, sorted by name. The caller must hold m.mu.
+func (m *MemoryDBRepo) sortedGenres() []*models.Genre {
+	var genres []*models.Genre
+	for _, g := range m.genres {
+		g := g
+		genres = append(genres, &g)
+	}
+
+	sortGenresByName(genres)
+
+	return genres
+}
+
+// matchesFilter reports whether a movie passes every filter condition. The caller must hold m.mu.
+func (m *MemoryDBRepo) matchesFilter(movie models.Movie, filter models.MovieFilter) bool {
+	if len(filter.MPAARatings) > 0 && !containsString(filter.MPAARatings, movie.MPAARating) {
+		return false
+	}
+	if filter.ReleaseYearFrom > 0 && movie.ReleaseDate.Year() < filter.ReleaseYearFrom {
+		return false
+	}
+	if filter.ReleaseYearTo > 0 && movie.ReleaseDate.Year() > filter.ReleaseYearTo {
+		return false
+	}
+	if filter.RunTimeMin > 0 && movie.RunTime < filter.RunTimeMin {
+		return false
+	}
+	if filter.RunTimeMax > 0 && movie.RunTime > filter.RunTimeMax {
+		return false
+	}
+	if len(filter.GenreIDs) > 0 {
+		found := false
+		for _, id := range filter.GenreIDs {
+			if m.hasGenre(movie.ID, id) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// wordPattern splits text into words the way the search functions see them.
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+func searchWords(text string) []string {
+	return wordPattern.FindAllString(text, -1)
+}
+
+// countMatches returns the number of words in text that start with one of the terms.
+func countMatches(text string, terms []string) int {
+	n := 0
+	for _, word := range searchWords(strings.ToLower(text)) {
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// highlight escapes text and wraps its words that start with one of the terms in <b></b> tags.
+func highlight(text string, terms []string) string {
+	marked := wordPattern.ReplaceAllStringFunc(models.StripHighlightMarkers(text), func(word string) string {
+		lower := strings.ToLower(word)
+		for _, term := range terms {
+			if strings.HasPrefix(lower, term) {
+				return models.HighlightStart + word + models.HighlightStop
+			}
+		}
+		return word
+	})
+
+	return models.HighlightHTML(marked)
+}
+
+// compareMovies compares two movies by a sort column, returning a negative number, zero or
+// a positive number.
+func compareMovies(a, b *models.Movie, column string) int {
+	switch column {
+	case "release_date":
+		return compareTimes(a.ReleaseDate, b.ReleaseDate)
+	case "runtime":
+		return a.RunTime - b.RunTime
+	case "created_at":
+		return compareTimes(a.CreatedAt, b.CreatedAt)
+	default:
+		return compareStrings(a.Title, b.Title)
+	}
+}
+
+// compareCursor compares a movie's (sort value, id) pair with the one stored in a cursor.
+func compareCursor(movie *models.Movie, column string, c models.MovieCursor) int {
+	var cmp int
+
+	switch column {
+	case "release_date":
+		t, _ := time.Parse("2006-01-02", c.Value)
+		cmp = compareTimes(movie.ReleaseDate, t)
+	case "runtime":
+		n, _ := strconv.Atoi(c.Value)
+		cmp = movie.RunTime - n
+	case "created_at":
+		t, _ := time.Parse("2006-01-02 15:04:05.999999", c.Value)
+		cmp = compareTimes(movie.CreatedAt, t)
+	default:
+		cmp = compareStrings(movie.Title, c.Value)
+	}
+
+	if cmp == 0 {
+		cmp = movie.ID - c.ID
+	}
+
+	return cmp
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case collate(a, b):
+		return -1
+	}
+	return 1
+}
+
+func sortMoviesByTitle(movies []*models.Movie) {
+	sort.Slice(movies, func(i, j int) bool {
+		if movies[i].Title != movies[j].Title {
+			return collate(movies[i].Title, movies[j].Title)
+		}
+		return movies[i].ID < movies[j].ID
+	})
+}
+
+func sortGenresByName(genres []*models.Genre) {
+	sort.Slice(genres, func(i, j int) bool {
+		if genres[i].Genre != genres[j].Genre {
+			return collate(genres[i].Genre, genres[j].Genre)
+		}
+		return genres[i].ID < genres[j].ID
+	})
+}
+
+// collate approximates the en_US.utf8 collation of the postgres image, which compares
+// case-insensitively before falling back to the raw bytes.
+func collate(a, b string) bool {
+	la, lb := strings.ToLower(a), strings.ToLower(b)
+	if la != lb {
+		return la < lb
+	}
+	return a < b
+}
+
+// truncateToDate mirrors storing a time.Time in a Postgres date column. Timestamps are
+// likewise truncated to the microsecond precision of a timestamp column.
+func truncateToDate(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func containsString(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(s []int, n int) bool {
+	for _, v := range s {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,342 @@
+package dbrepo
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Hook is called when a repository call starts, with the name of the method. It returns the
+// context for the call and a function to call with the call's error when it returns.
+type Hook func(ctx context.Context, method string) (context.Context, func(err error))
+
+// ObservedDBRepo wraps a DatabaseRepo and runs Hook around each of its calls, for metrics and
+// the like. Calls made in a transaction are observed too.
+type ObservedDBRepo struct {
+	Repo repository.DatabaseRepo
+	Hook Hook
+}
+
+// Observe wraps repo so that every call runs each of the hooks, the first one outermost.
+func Observe(repo repository.DatabaseRepo, hooks ...Hook) repository.DatabaseRepo {
+	for i := len(hooks) - 1; i >= 0; i-- {
+		repo = &ObservedDBRepo{Repo: repo, Hook: hooks[i]}
+	}
+	return repo
+}
+
+func (r *ObservedDBRepo) Connection() *sql.DB {
+	return r.Repo.Connection()
+}
+
+func (r *ObservedDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	ctx, done := r.Hook(ctx, "WithTx")
+	err := r.Repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+		return fn(&ObservedDBRepo{Repo: tx, Hook: r.Hook})
+	})
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) AllMovies(ctx context.Context, genre ...int) ([]*models.Movie, error) {
+	ctx, done := r.Hook(ctx, "AllMovies")
+	r0, err := r.Repo.AllMovies(ctx, genre...)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) ListMovies(ctx context.Context, filter models.MovieFilter) ([]*models.Movie, models.PageMetadata, error) {
+	ctx, done := r.Hook(ctx, "ListMovies")
+	r0, r1, err := r.Repo.ListMovies(ctx, filter)
+	done(err)
+	return r0, r1, err
+}
+
+func (r *ObservedDBRepo) SearchMovies(ctx context.Context, query string, limit int) ([]*models.MovieSearchResult, error) {
+	ctx, done := r.Hook(ctx, "SearchMovies")
+	r0, err := r.Repo.SearchMovies(ctx, query, limit)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, done := r.Hook(ctx, "GetUserByEmail")
+	r0, err := r.Repo.GetUserByEmail(ctx, email)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) GetUserById(ctx context.Context, id int) (*models.User, error) {
+	ctx, done := r.Hook(ctx, "GetUserById")
+	r0, err := r.Repo.GetUserById(ctx, id)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) InsertUser(ctx context.Context, user models.User) (int, error) {
+	ctx, done := r.Hook(ctx, "InsertUser")
+	r0, err := r.Repo.InsertUser(ctx, user)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) UpdateUser(ctx context.Context, user models.User) error {
+	ctx, done := r.Hook(ctx, "UpdateUser")
+	err := r.Repo.UpdateUser(ctx, user)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) UpdateUserPassword(ctx context.Context, id int, hash string) error {
+	ctx, done := r.Hook(ctx, "UpdateUserPassword")
+	err := r.Repo.UpdateUserPassword(ctx, id, hash)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) UpdateUserRole(ctx context.Context, id int, role string) error {
+	ctx, done := r.Hook(ctx, "UpdateUserRole")
+	err := r.Repo.UpdateUserRole(ctx, id, role)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) DeleteUser(ctx context.Context, id int) error {
+	ctx, done := r.Hook(ctx, "DeleteUser")
+	err := r.Repo.DeleteUser(ctx, id)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) GetUserByOIDCIdentity(ctx context.Context, issuer string, subject string) (*models.User, error) {
+	ctx, done := r.Hook(ctx, "GetUserByOIDCIdentity")
+	r0, err := r.Repo.GetUserByOIDCIdentity(ctx, issuer, subject)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) LinkOIDCIdentity(ctx context.Context, issuer string, subject string, userID int) error {
+	ctx, done := r.Hook(ctx, "LinkOIDCIdentity")
+	err := r.Repo.LinkOIDCIdentity(ctx, issuer, subject, userID)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) EnrollUserTOTP(ctx context.Context, userID int, secret string, recoveryCodeHashes []string) error {
+	ctx, done := r.Hook(ctx, "EnrollUserTOTP")
+	err := r.Repo.EnrollUserTOTP(ctx, userID, secret, recoveryCodeHashes)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) EnableUserTOTP(ctx context.Context, userID int) error {
+	ctx, done := r.Hook(ctx, "EnableUserTOTP")
+	err := r.Repo.EnableUserTOTP(ctx, userID)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) DisableUserTOTP(ctx context.Context, userID int) error {
+	ctx, done := r.Hook(ctx, "DisableUserTOTP")
+	err := r.Repo.DisableUserTOTP(ctx, userID)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) UpdateUserTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, done := r.Hook(ctx, "UpdateUserTOTPStep")
+	r0, err := r.Repo.UpdateUserTOTPStep(ctx, userID, step)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	ctx, done := r.Hook(ctx, "UseRecoveryCode")
+	r0, err := r.Repo.UseRecoveryCode(ctx, userID, hash)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) InsertPasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	ctx, done := r.Hook(ctx, "InsertPasswordReset")
+	err := r.Repo.InsertPasswordReset(ctx, reset)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) UsePasswordReset(ctx context.Context, hash string) (int, error) {
+	ctx, done := r.Hook(ctx, "UsePasswordReset")
+	r0, err := r.Repo.UsePasswordReset(ctx, hash)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) DeleteUserPasswordResets(ctx context.Context, userID int) error {
+	ctx, done := r.Hook(ctx, "DeleteUserPasswordResets")
+	err := r.Repo.DeleteUserPasswordResets(ctx, userID)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	ctx, done := r.Hook(ctx, "InsertRefreshToken")
+	err := r.Repo.InsertRefreshToken(ctx, token)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	ctx, done := r.Hook(ctx, "GetRefreshToken")
+	r0, err := r.Repo.GetRefreshToken(ctx, id)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) MarkRefreshTokenUsed(ctx context.Context, id string) (bool, error) {
+	ctx, done := r.Hook(ctx, "MarkRefreshTokenUsed")
+	r0, err := r.Repo.MarkRefreshTokenUsed(ctx, id)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	ctx, done := r.Hook(ctx, "RevokeRefreshTokenFamily")
+	err := r.Repo.RevokeRefreshTokenFamily(ctx, familyID)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	ctx, done := r.Hook(ctx, "RevokeUserRefreshTokens")
+	err := r.Repo.RevokeUserRefreshTokens(ctx, userID)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) InsertAPIKey(ctx context.Context, key models.APIKey) (int, error) {
+	ctx, done := r.Hook(ctx, "InsertAPIKey")
+	r0, err := r.Repo.InsertAPIKey(ctx, key)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	ctx, done := r.Hook(ctx, "GetAPIKeyByHash")
+	r0, err := r.Repo.GetAPIKeyByHash(ctx, hash)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) UserAPIKeys(ctx context.Context, userID int) ([]*models.APIKey, error) {
+	ctx, done := r.Hook(ctx, "UserAPIKeys")
+	r0, err := r.Repo.UserAPIKeys(ctx, userID)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) DeleteAPIKey(ctx context.Context, userID int, id int) error {
+	ctx, done := r.Hook(ctx, "DeleteAPIKey")
+	err := r.Repo.DeleteAPIKey(ctx, userID, id)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	ctx, done := r.Hook(ctx, "TouchAPIKey")
+	err := r.Repo.TouchAPIKey(ctx, id, usedAt)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	ctx, done := r.Hook(ctx, "GetLoginThrottle")
+	r0, err := r.Repo.GetLoginThrottle(ctx, key)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) ReserveLoginAttempt(ctx context.Context, key string, at, resetBefore time.Time, policy models.LoginThrottlePolicy) (*models.LoginThrottle, bool, error) {
+	ctx, done := r.Hook(ctx, "ReserveLoginAttempt")
+	r0, r1, err := r.Repo.ReserveLoginAttempt(ctx, key, at, resetBefore, policy)
+	done(err)
+	return r0, r1, err
+}
+
+func (r *ObservedDBRepo) ReleaseLoginAttempt(ctx context.Context, key string, lockedUntil, at time.Time) error {
+	ctx, done := r.Hook(ctx, "ReleaseLoginAttempt")
+	err := r.Repo.ReleaseLoginAttempt(ctx, key, lockedUntil, at)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) ClearLoginThrottle(ctx context.Context, key string) error {
+	ctx, done := r.Hook(ctx, "ClearLoginThrottle")
+	err := r.Repo.ClearLoginThrottle(ctx, key)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) PruneLoginThrottles(ctx context.Context, resetBefore, now time.Time) (int, error) {
+	ctx, done := r.Hook(ctx, "PruneLoginThrottles")
+	r0, err := r.Repo.PruneLoginThrottles(ctx, resetBefore, now)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) InsertAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	ctx, done := r.Hook(ctx, "InsertAuditEvent")
+	err := r.Repo.InsertAuditEvent(ctx, event)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) OneMovieForEdit(ctx context.Context, id int) (*models.Movie, []*models.Genre, error) {
+	ctx, done := r.Hook(ctx, "OneMovieForEdit")
+	r0, r1, err := r.Repo.OneMovieForEdit(ctx, id)
+	done(err)
+	return r0, r1, err
+}
+
+func (r *ObservedDBRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
+	ctx, done := r.Hook(ctx, "OneMovie")
+	r0, err := r.Repo.OneMovie(ctx, id)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) AllGenres(ctx context.Context) ([]*models.Genre, error) {
+	ctx, done := r.Hook(ctx, "AllGenres")
+	r0, err := r.Repo.AllGenres(ctx)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) InsertMovie(ctx context.Context, movie models.Movie) (int, error) {
+	ctx, done := r.Hook(ctx, "InsertMovie")
+	r0, err := r.Repo.InsertMovie(ctx, movie)
+	done(err)
+	return r0, err
+}
+
+func (r *ObservedDBRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error {
+	ctx, done := r.Hook(ctx, "UpdateMovieGenres")
+	err := r.Repo.UpdateMovieGenres(ctx, id, genreIDs)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) UpdateMovie(ctx context.Context, movie models.Movie) error {
+	ctx, done := r.Hook(ctx, "UpdateMovie")
+	err := r.Repo.UpdateMovie(ctx, movie)
+	done(err)
+	return err
+}
+
+func (r *ObservedDBRepo) DeleteMovie(ctx context.Context, id int) error {
+	ctx, done := r.Hook(ctx, "DeleteMovie")
+	err := r.Repo.DeleteMovie(ctx, id)
+	done(err)
+	return err
+}
@@ -2,25 +2,105 @@ package dbrepo
 
 import (
 	"backend/internal/models"
+	"backend/internal/repository"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/jackc/pgconn"
 )
 
 type PostgresDBRepo struct {
 	DB *sql.DB
+	// Timeout bounds every query. If zero, dbTimeout is used.
+	Timeout time.Duration
+
+	// tx is set on the copy of the repository handed to a WithTx callback
+	tx *sql.Tx
+}
+
+// queryer is the subset of *sql.DB and *sql.Tx used by the repository methods.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
 }
 
 const dbTimeout = time.Second * 3 //3 seconds
 
+// uniqueViolation is the Postgres error code for a unique index violation.
+const uniqueViolation = "23505"
+
+// foreignKeyViolation is the Postgres error code for a foreign key violation.
+const foreignKeyViolation = "23503"
+
 func (m *PostgresDBRepo) Connection() *sql.DB{
 	return m.DB
 }
 
+// conn returns the transaction if the repository is bound to one, and the connection pool otherwise.
+func (m *PostgresDBRepo) conn() queryer {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.DB
+}
+
+// WithTx runs fn inside a database transaction. The repository passed to fn executes every
+// statement on that transaction, which is committed if fn returns nil and rolled back if fn
+// returns an error or panics. Calling WithTx on a repository that is already bound to a
+// transaction runs fn on the same transaction.
+func (m *PostgresDBRepo) WithTx(ctx context.Context, fn func(repo repository.DatabaseRepo) error) error {
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		return fn(tx)
+	})
+}
+
+// inTx is WithTx for callers inside this package that need the concrete repository type.
+func (m *PostgresDBRepo) inTx(ctx context.Context, fn func(tx *PostgresDBRepo) error) error {
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = fn(&PostgresDBRepo{DB: m.DB, Timeout: m.Timeout, tx: tx})
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// withTimeout derives a context from the caller's context that is also cancelled after m.Timeout,
+// so a query stops when either the client goes away or the timeout is reached.
+func (m *PostgresDBRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = dbTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 // AllMovies returns a slice of movies, sorted by name.
-func (m *PostgresDBRepo) AllMovies(genre ...int) ([]*models.Movie, error){
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) AllMovies(ctx context.Context, genre ...int) ([]*models.Movie, error){
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
 	where := ""
@@ -32,14 +112,14 @@ func (m *PostgresDBRepo) AllMovies(genre ...int) ([]*models.Movie, error){
 		select
 			id, title, release_date, runtime,
 			mpaa_rating, description, coalesce(image, ''),
-			created_at, updated_at
+			created_at, updated_at, coalesce(created_by, 0), coalesce(updated_by, 0)
 		from
 			movies %s
 		order by
 			title
 	`, where)
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.conn().QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +139,8 @@ func (m *PostgresDBRepo) AllMovies(genre ...int) ([]*models.Movie, error){
 			&movie.Image,
 			&movie.CreatedAt,
 			&movie.UpdatedAt,
+			&movie.CreatedBy,
+			&movie.UpdatedBy,
 		)
 		if err != nil {
 			return nil, err
@@ -70,14 +152,239 @@ func (m *PostgresDBRepo) AllMovies(genre ...int) ([]*models.Movie, error){
 	return movies, nil
 }
 
-func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// ListMovies returns one page of the movies matching filter, along with the page metadata.
+// The filter must have been validated.
+func (m *PostgresDBRepo) ListMovies(ctx context.Context, filter models.MovieFilter) ([]*models.Movie, models.PageMetadata, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	var conditions []string
+	var args []interface{}
+
+	// arg appends a query argument and returns its placeholder
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if len(filter.MPAARatings) > 0 {
+		var placeholders []string
+		for _, rating := range filter.MPAARatings {
+			placeholders = append(placeholders, arg(rating))
+		}
+		conditions = append(conditions, fmt.Sprintf("mpaa_rating in (%s)", strings.Join(placeholders, ", ")))
+	}
+	if filter.ReleaseYearFrom > 0 {
+		conditions = append(conditions, "release_date >= "+arg(time.Date(filter.ReleaseYearFrom, 1, 1, 0, 0, 0, 0, time.UTC)))
+	}
+	if filter.ReleaseYearTo > 0 {
+		conditions = append(conditions, "release_date < "+arg(time.Date(filter.ReleaseYearTo+1, 1, 1, 0, 0, 0, 0, time.UTC)))
+	}
+	if filter.RunTimeMin > 0 {
+		conditions = append(conditions, "runtime >= "+arg(filter.RunTimeMin))
+	}
+	if filter.RunTimeMax > 0 {
+		conditions = append(conditions, "runtime <= "+arg(filter.RunTimeMax))
+	}
+	if len(filter.GenreIDs) > 0 {
+		var placeholders []string
+		for _, id := range filter.GenreIDs {
+			placeholders = append(placeholders, arg(id))
+		}
+		conditions = append(conditions, fmt.Sprintf("id in (select movie_id from movies_genres where genre_id in (%s))", strings.Join(placeholders, ", ")))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "where " + strings.Join(conditions, " and ")
+	}
+
+	// count every match, regardless of the page
+	var total int
+	err := m.conn().QueryRowContext(ctx, "select count(*) from movies "+where, args...).Scan(&total)
+	if err != nil {
+		return nil, models.PageMetadata{}, err
+	}
+
+	column := filter.SortColumn()
+	direction, comparison := "asc", ">"
+	if filter.SortDescending() {
+		direction, comparison = "desc", "<"
+	}
+
+	page := filter.Page
+	limit := ""
+	if filter.Cursor != "" {
+		// keyset pagination: continue right after the last movie of the previous page
+		page = 0
+		c, err := models.DecodeMovieCursor(filter.Cursor)
+		if err != nil {
+			return nil, models.PageMetadata{}, err
+		}
+
+		columnTypes := map[string]string{
+			"title":        "varchar",
+			"release_date": "date",
+			"runtime":      "integer",
+			"created_at":   "timestamp",
+		}
+
+		condition := fmt.Sprintf("(%s, id) %s (%s::%s, %s)", column, comparison, arg(c.Value), columnTypes[column], arg(c.ID))
+		if where == "" {
+			where = "where " + condition
+		} else {
+			where += " and " + condition
+		}
+		limit = "limit " + arg(filter.PageSize+1)
+	} else {
+		limit = fmt.Sprintf("limit %s offset %s", arg(filter.PageSize+1), arg(filter.Offset()))
+	}
+
+	query := fmt.Sprintf(`
+		select
+			id, title, release_date, runtime,
+			mpaa_rating, description, coalesce(image, ''),
+			created_at, updated_at, coalesce(created_by, 0), coalesce(updated_by, 0)
+		from
+			movies %s
+		order by
+			%s %s, id %s
+		%s
+	`, where, column, direction, direction, limit)
+
+	rows, err := m.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, models.PageMetadata{}, err
+	}
+	defer rows.Close()
+
+	var movies []*models.Movie
+
+	for rows.Next(){
+		var movie models.Movie
+		err := rows.Scan(
+			&movie.ID,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.RunTime,
+			&movie.MPAARating,
+			&movie.Description,
+			&movie.Image,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.CreatedBy,
+			&movie.UpdatedBy,
+		)
+		if err != nil {
+			return nil, models.PageMetadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, models.PageMetadata{}, err
+	}
+
+	// one extra row was requested to find out whether there is a next page
+	nextCursor := ""
+	if len(movies) > filter.PageSize {
+		movies = movies[:filter.PageSize]
+		nextCursor = models.EncodeMovieCursor(movies[len(movies)-1], filter)
+	}
+
+	return movies, models.NewPageMetadata(total, page, filter.PageSize, nextCursor), nil
+}
+
+// movieDocument is the weighted tsvector searched by SearchMovies. It must stay identical to
+// the expression of the movies_search_idx index, or the index will not be used.
+const movieDocument = `setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(description, '')), 'B')`
+
+// SearchMovies runs a full-text search over movie titles and descriptions. The query accepts
+// web search syntax ("quoted phrases", or, -excluded). Results are ordered by relevance.
+func (m *PostgresDBRepo) SearchMovies(ctx context.Context, query string, limit int) ([]*models.MovieSearchResult, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := fmt.Sprintf(`
+		select
+			id, title, release_date, runtime,
+			mpaa_rating, description, coalesce(image, ''),
+			created_at, updated_at, coalesce(created_by, 0), coalesce(updated_by, 0),
+			ts_rank(%[1]s, q) as rank,
+			ts_headline('english', translate(coalesce(title, ''), $3, ''), q, $4),
+			ts_headline('english', translate(coalesce(description, ''), $3, ''), q, $5)
+		from
+			movies, websearch_to_tsquery('english', $1) q
+		where
+			%[1]s @@ q
+		order by
+			rank desc, title, id
+		limit $2
+	`, movieDocument)
+
+	// ts_headline marks matches with control characters rather than tags, so that the text
+	// around them can be escaped before they become <b></b>
+	markers := models.HighlightStart + models.HighlightStop
+	selectors := fmt.Sprintf(`StartSel="%s", StopSel="%s"`, models.HighlightStart, models.HighlightStop)
+
+	rows, err := m.conn().QueryContext(ctx, stmt, query, limit, markers,
+		"HighlightAll=true, "+selectors,
+		"MaxFragments=2, MaxWords=20, MinWords=5, "+selectors,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*models.MovieSearchResult
+
+	for rows.Next() {
+		var movie models.Movie
+		var result models.MovieSearchResult
+		err := rows.Scan(
+			&movie.ID,
+			&movie.Title,
+			&movie.ReleaseDate,
+			&movie.RunTime,
+			&movie.MPAARating,
+			&movie.Description,
+			&movie.Image,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.CreatedBy,
+			&movie.UpdatedBy,
+			&result.Rank,
+			&result.TitleHighlight,
+			&result.DescriptionHighlight,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		result.TitleHighlight = models.HighlightHTML(result.TitleHighlight)
+		result.DescriptionHighlight = models.HighlightHTML(result.DescriptionHighlight)
+		result.Movie = &movie
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (m *PostgresDBRepo) OneMovie(ctx context.Context, id int) (*models.Movie, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at
+	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at,
+			coalesce(created_by, 0), coalesce(updated_by, 0)
 			 from movies where id = $1`
 	
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.conn().QueryRowContext(ctx, query, id)
 
 	var movie models.Movie
 
@@ -91,10 +398,12 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 		&movie.Image,
 		&movie.CreatedAt,
 		&movie.UpdatedAt,
+		&movie.CreatedBy,
+		&movie.UpdatedBy,
 	) // Scan the values I get from the database into the movie variable 
 
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, repository.ErrMovieNotFound)
 	}
 
 	// get genres, if any
@@ -103,7 +412,7 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 			where mg.movie_id = $1
 			order by g.genre`
 
-	rows, err := m.DB.QueryContext(ctx, query,id)
+	rows, err := m.conn().QueryContext(ctx, query,id)
 	if err != nil && err != sql.ErrNoRows{
 		return nil, err
 	}
@@ -128,14 +437,15 @@ func (m *PostgresDBRepo) OneMovie(id int) (*models.Movie, error) {
 	return &movie, err
 }
 
-func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) OneMovieForEdit(ctx context.Context, id int) (*models.Movie, []*models.Genre, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at
+	query := `select id, title, release_date, runtime, mpaa_rating, description, coalesce(image, ''), created_at, updated_at,
+			coalesce(created_by, 0), coalesce(updated_by, 0)
 			 from movies where id = $1`
 	
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.conn().QueryRowContext(ctx, query, id)
 
 	var movie models.Movie
 
@@ -149,10 +459,12 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 		&movie.Image,
 		&movie.CreatedAt,
 		&movie.UpdatedAt,
+		&movie.CreatedBy,
+		&movie.UpdatedBy,
 	) // Scan the values I get from the database into the movie variable 
 
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, notFound(err, repository.ErrMovieNotFound)
 	}
 
 	// get genres, if any
@@ -161,7 +473,7 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 			where mg.movie_id = $1
 			order by g.genre`
 
-	rows, err := m.DB.QueryContext(ctx, query,id)
+	rows, err := m.conn().QueryContext(ctx, query,id)
 	if err != nil && err != sql.ErrNoRows{
 		return nil, nil, err
 	}
@@ -190,7 +502,7 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 	var allGenres []*models.Genre
 
 	query = "select id, genre from genres order by genre"
-	gRows, err := m.DB.QueryContext(ctx, query)
+	gRows, err := m.conn().QueryContext(ctx, query)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -206,21 +518,29 @@ func (m *PostgresDBRepo) OneMovieForEdit(id int) (*models.Movie, []*models.Genre
 			return nil, nil, err
 		}
 
+		// mark the genres this movie already has
+		for _, id := range genresArray {
+			if id == g.ID {
+				g.Checked = true
+			}
+		}
+
 		allGenres = append(allGenres, &g)
 	}
 
 	return &movie, allGenres, err
 }
 
-func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error){
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUserByEmail(ctx context.Context, email string) (*models.User, error){
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password,
-		created_at, updated_at from users where email = $1`
+	query := `select id, email, first_name, last_name, password, role,
+		totp_secret, totp_enabled, totp_last_step,
+		created_at, updated_at from users where lower(email) = lower($1)`
 	
 	var user models.User
-	row := m.DB.QueryRowContext(ctx, query, email)
+	row := m.conn().QueryRowContext(ctx, query, email)
 
 	err := row.Scan(
 		&user.ID,
@@ -228,27 +548,32 @@ func (m *PostgresDBRepo) GetUserByEmail(email string) (*models.User, error){
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
+		&user.Role,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.TOTPLastStep,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, repository.ErrUserNotFound)
 	}
 
 	return &user, nil
 }
 
 
-func (m *PostgresDBRepo) GetUserById(id int) (*models.User, error){
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUserById(ctx context.Context, id int) (*models.User, error){
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password,
+	query := `select id, email, first_name, last_name, password, role,
+		totp_secret, totp_enabled, totp_last_step,
 		created_at, updated_at from users where id = $1`
 	
 	var user models.User
-	row := m.DB.QueryRowContext(ctx, query, id)
+	row := m.conn().QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&user.ID,
@@ -256,94 +581,147 @@ func (m *PostgresDBRepo) GetUserById(id int) (*models.User, error){
 		&user.FirstName,
 		&user.LastName,
 		&user.Password,
+		&user.Role,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.TOTPLastStep,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, repository.ErrUserNotFound)
 	}
 
 	return &user, nil
 }
 
-func (m *PostgresDBRepo) AllGenres() ([]*models.Genre, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) GetUserByOIDCIdentity(ctx context.Context, issuer, subject string) (*models.User, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	query := `select id, genre, created_at, updated_at from genres order by genre`
+	query := `select u.id, u.email, u.first_name, u.last_name, u.password, u.role,
+		u.totp_secret, u.totp_enabled, u.totp_last_step,
+		u.created_at, u.updated_at
+		from users u
+		join oidc_identities i on i.user_id = u.id
+		where i.issuer = $1 and i.subject = $2`
+
+	var user models.User
+	row := m.conn().QueryRowContext(ctx, query, issuer, subject)
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.Role,
+		&user.TOTPSecret,
+		&user.TOTPEnabled,
+		&user.TOTPLastStep,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
 	if err != nil {
-		return nil, err
+		return nil, notFound(err, repository.ErrUserNotFound)
 	}
-	defer rows.Close()
 
-	var genres []*models.Genre
+	return &user, nil
+}
 
-	for rows.Next() {
-		var g models.Genre
-		err := rows.Scan(
-			&g.ID,
-			&g.Genre,
-			&g.CreatedAt,
-			&g.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
+func (m *PostgresDBRepo) LinkOIDCIdentity(ctx context.Context, issuer, subject string, userID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
 
-		genres = append(genres, &g)
+	stmt := `insert into oidc_identities (issuer, subject, user_id, created_at) values ($1, $2, $3, $4)`
+
+	_, err := m.conn().ExecContext(ctx, stmt, issuer, subject, userID, time.Now())
+	if err != nil {
+		return err
 	}
 
-	return genres, nil
+	return nil
 }
 
-func (m *PostgresDBRepo) InsertMovie(movie models.Movie) (int, error){
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// InsertUser adds a user and returns its id. user.Password must already be a bcrypt hash.
+func (m *PostgresDBRepo) InsertUser(ctx context.Context, user models.User) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	stmt := `insert into movies (title, description, release_date, runtime,
-			mpaa_rating, created_at, updated_at, image)
-			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
-	
+	if user.Role == "" {
+		user.Role = models.RoleViewer
+	}
+
+	stmt := `insert into users (email, first_name, last_name, password, role, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
 	var newID int
-	err := m.DB.QueryRowContext(ctx, stmt, 
-		movie.Title,
-		movie.Description,
-		movie.ReleaseDate,
-		movie.RunTime,
-		movie.MPAARating,
-		movie.CreatedAt,
-		movie.UpdatedAt,
-		movie.Image,
+	err := m.conn().QueryRowContext(ctx, stmt,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.Password,
+		user.Role,
+		user.CreatedAt,
+		user.UpdatedAt,
 	).Scan(&newID)
 
 	if err != nil {
-		return 0, err
+		return 0, userWriteError(err)
 	}
 
 	return newID, nil
 }
 
-func (m *PostgresDBRepo) UpdateMovie(movie models.Movie) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+// UpdateUser updates a user's profile: names and email address. The password is changed
+// with UpdateUserPassword.
+func (m *PostgresDBRepo) UpdateUser(ctx context.Context, user models.User) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	stmt := `update movies set title = $1, description = $2, release_date = $3,
-				runtime = $4, mpaa_rating = $5,
-				updated_at = $6, image = $7 where id = $8`
-	_, err := m.DB.ExecContext(ctx, stmt, 
-		movie.Title,
-		movie.Description,
-		movie.ReleaseDate,
-		movie.RunTime,
-		movie.MPAARating,
-		movie.UpdatedAt,
-		movie.Image,
-		movie.ID,
+	stmt := `update users set email = $1, first_name = $2, last_name = $3, updated_at = $4
+			where id = $5`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		user.Email,
+		user.FirstName,
+		user.LastName,
+		user.UpdatedAt,
+		user.ID,
 	)
 
+	if err != nil {
+		return userWriteError(err)
+	}
+
+	return nil
+}
+
+// UpdateUserPassword replaces a user's password hash.
+func (m *PostgresDBRepo) UpdateUserPassword(ctx context.Context, id int, hash string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update users set password = $1, updated_at = $2 where id = $3`
+
+	_, err := m.conn().ExecContext(ctx, stmt, hash, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateUserRole changes a user's role. Tokens already issued keep the old role until
+// they are refreshed.
+func (m *PostgresDBRepo) UpdateUserRole(ctx context.Context, id int, role string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update users set role = $1, updated_at = $2 where id = $3`
+
+	_, err := m.conn().ExecContext(ctx, stmt, role, time.Now(), id)
 	if err != nil {
 		return err
 	}
@@ -351,37 +729,724 @@ func (m *PostgresDBRepo) UpdateMovie(movie models.Movie) error {
 	return nil
 }
 
-func (m *PostgresDBRepo) UpdateMovieGenres(id int, genreIDs []int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) DeleteUser(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	stmt := `delete from movies_genres where movie_id = $1`
+	stmt := `delete from users where id = $1`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
+	_, err := m.conn().ExecContext(ctx, stmt, id)
 	if err != nil {
 		return err
 	}
 
-	for _, n := range genreIDs {
-		stmt := `insert into movies_genres (movie_id, genre_id) values ($1, $2)`
-		_, err := m.DB.ExecContext(ctx, stmt, id, n)
+	return nil
+}
+
+func (m *PostgresDBRepo) EnrollUserTOTP(ctx context.Context, userID int, secret string, recoveryCodeHashes []string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		stmt := `update users set totp_secret = $1, totp_enabled = false, totp_last_step = 0,
+				updated_at = $2 where id = $3`
+
+		_, err := tx.conn().ExecContext(ctx, stmt, secret, time.Now(), userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.conn().ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
 		if err != nil {
 			return err
 		}
+
+		stmt = `insert into recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`
+
+		for _, hash := range recoveryCodeHashes {
+			_, err = tx.conn().ExecContext(ctx, stmt, userID, hash, time.Now())
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *PostgresDBRepo) EnableUserTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update users set totp_enabled = true, updated_at = $1 where id = $2 and totp_secret <> ''`
+
+	_, err := m.conn().ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func (m *PostgresDBRepo) DeleteMovie(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+func (m *PostgresDBRepo) DisableUserTOTP(ctx context.Context, userID int) error {
+	ctx, cancel := m.withTimeout(ctx)
 	defer cancel()
 
-	stmt := `delete from movies where id = $1`
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		stmt := `update users set totp_secret = '', totp_enabled = false, totp_last_step = 0,
+				updated_at = $1 where id = $2`
 
-	_, err := m.DB.ExecContext(ctx, stmt, id)
-	if err != nil {
+		_, err := tx.conn().ExecContext(ctx, stmt, time.Now(), userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.conn().ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
 		return err
+	})
+}
+
+func (m *PostgresDBRepo) UpdateUserTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update users set totp_last_step = $1 where id = $2 and totp_last_step < $1`
+
+	result, err := m.conn().ExecContext(ctx, stmt, step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (m *PostgresDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update recovery_codes set used_at = $1
+			where id = (
+				select id from recovery_codes
+				where user_id = $2 and code_hash = $3 and used_at is null
+				limit 1
+			) and used_at is null`
+
+	result, err := m.conn().ExecContext(ctx, stmt, time.Now(), userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (m *PostgresDBRepo) InsertPasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `insert into password_resets (token_hash, user_id, expires_at, created_at)
+			values ($1, $2, $3, $4)`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		reset.Hash,
+		reset.UserID,
+		reset.ExpiresAt,
+		reset.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UsePasswordReset checks and consumes the token in one statement, so a token cannot be used
+// twice by concurrent requests.
+func (m *PostgresDBRepo) UsePasswordReset(ctx context.Context, hash string) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update password_resets set used_at = $1
+			where token_hash = $2 and used_at is null and expires_at > $1
+			returning user_id`
+
+	var userID int
+	err := m.conn().QueryRowContext(ctx, stmt, time.Now(), hash).Scan(&userID)
+	if err != nil {
+		return 0, notFound(err, repository.ErrPasswordResetNotFound)
+	}
+
+	return userID, nil
+}
+
+func (m *PostgresDBRepo) DeleteUserPasswordResets(ctx context.Context, userID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `delete from password_resets where user_id = $1`
+
+	_, err := m.conn().ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) InsertRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `insert into refresh_tokens (id, user_id, family_id, expires_at, created_at)
+			values ($1, $2, $3, $4, $5)`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		token.ID,
+		token.UserID,
+		token.FamilyID,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select id, user_id, family_id, expires_at, used_at, revoked_at, created_at
+			from refresh_tokens where id = $1`
+
+	var token models.RefreshToken
+	err := m.conn().QueryRowContext(ctx, query, id).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.RevokedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, notFound(err, repository.ErrRefreshTokenNotFound)
+	}
+
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed sets used_at only if it is still empty, so of two concurrent refreshes
+// with the same token exactly one succeeds.
+func (m *PostgresDBRepo) MarkRefreshTokenUsed(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update refresh_tokens set used_at = $1 where id = $2 and used_at is null`
+
+	result, err := m.conn().ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (m *PostgresDBRepo) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where family_id = $2 and revoked_at is null`
+
+	_, err := m.conn().ExecContext(ctx, stmt, time.Now(), familyID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`
+
+	_, err := m.conn().ExecContext(ctx, stmt, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) InsertAPIKey(ctx context.Context, key models.APIKey) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `insert into api_keys (user_id, name, prefix, key_hash, scopes, mfa, created_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	var newID int
+	err := m.conn().QueryRowContext(ctx, stmt,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		key.Hash,
+		strings.Join(key.Scopes, " "),
+		key.MFA,
+		key.CreatedAt,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func (m *PostgresDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select id, user_id, name, prefix, key_hash, scopes, mfa, last_used_at, created_at
+			from api_keys where key_hash = $1`
+
+	key, err := scanAPIKey(m.conn().QueryRowContext(ctx, query, hash))
+	if err != nil {
+		return nil, notFound(err, repository.ErrAPIKeyNotFound)
+	}
+
+	return key, nil
+}
+
+func (m *PostgresDBRepo) UserAPIKeys(ctx context.Context, userID int) ([]*models.APIKey, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select id, user_id, name, prefix, key_hash, scopes, mfa, last_used_at, created_at
+			from api_keys where user_id = $1 order by id`
+
+	rows, err := m.conn().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (m *PostgresDBRepo) DeleteAPIKey(ctx context.Context, userID, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `delete from api_keys where id = $1 and user_id = $2`
+
+	result, err := m.conn().ExecContext(ctx, stmt, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrAPIKeyNotFound.Wrap(sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update api_keys set last_used_at = $1 where id = $2`
+
+	_, err := m.conn().ExecContext(ctx, stmt, usedAt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// scanAPIKey reads an api_keys row selected in the column order used above.
+func scanAPIKey(row interface{ Scan(dest ...interface{}) error }) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		&scopes,
+		&key.MFA,
+		&key.LastUsedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Fields(scopes)
+
+	return &key, nil
+}
+
+func (m *PostgresDBRepo) GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select key, failures, last_failure_at, locked_until from login_throttles where key = $1`
+
+	var throttle models.LoginThrottle
+	err := m.conn().QueryRowContext(ctx, query, key).Scan(
+		&throttle.Key,
+		&throttle.Failures,
+		&throttle.LastFailureAt,
+		&throttle.LockedUntil,
+	)
+	if err != nil {
+		return nil, notFound(err, repository.ErrLoginThrottleNotFound)
+	}
+
+	return &throttle, nil
+}
+
+// ReserveLoginAttempt checks the lock and counts the attempt in a single upsert, which does
+// nothing when the existing row is locked. The lock follows policy.Delay, computed in SQL from
+// the new count: the microseconds of the base delay, doubled per attempt and capped at the
+// lockout.
+func (m *PostgresDBRepo) ReserveLoginAttempt(ctx context.Context, key string, at, resetBefore time.Time, policy models.LoginThrottlePolicy) (*models.LoginThrottle, bool, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	failures := `(case when login_throttles.last_failure_at < $3 then 1 else login_throttles.failures + 1 end)`
+	stmt := `insert into login_throttles (key, failures, last_failure_at, locked_until)
+			values ($1, 1, $2, $4)
+			on conflict (key) do update set
+				failures = ` + failures + `,
+				last_failure_at = excluded.last_failure_at,
+				locked_until = excluded.last_failure_at + interval '1 microsecond' * (case
+					when ` + failures + ` >= $5 then $6::float8
+					when $7::float8 <= 0 then 0
+					else least($6::float8, $7::float8 * power(2, least(` + failures + ` - 1, 62)))
+				end)
+			where login_throttles.locked_until <= excluded.last_failure_at
+			returning key, failures, last_failure_at, locked_until`
+
+	var throttle models.LoginThrottle
+	err := m.conn().QueryRowContext(ctx, stmt,
+		key,
+		at,
+		resetBefore,
+		at.Add(policy.Delay(1)),
+		policy.MaxAttempts,
+		policy.Lockout.Microseconds(),
+		policy.BaseDelay.Microseconds(),
+	).Scan(
+		&throttle.Key,
+		&throttle.Failures,
+		&throttle.LastFailureAt,
+		&throttle.LockedUntil,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		// locked: report the lock, unless it was pruned in the meantime
+		locked, err := m.GetLoginThrottle(ctx, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &models.LoginThrottle{Key: key, LockedUntil: at}, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		return locked, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return &throttle, true, nil
+}
+
+func (m *PostgresDBRepo) ReleaseLoginAttempt(ctx context.Context, key string, lockedUntil, at time.Time) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update login_throttles set
+				failures = greatest(failures - 1, 0),
+				locked_until = case when locked_until = $2 then $3 else locked_until end
+			where key = $1`
+
+	_, err := m.conn().ExecContext(ctx, stmt, key, lockedUntil, at)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) ClearLoginThrottle(ctx context.Context, key string) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `delete from login_throttles where key = $1`
+
+	_, err := m.conn().ExecContext(ctx, stmt, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *PostgresDBRepo) PruneLoginThrottles(ctx context.Context, resetBefore, now time.Time) (int, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `delete from login_throttles where last_failure_at < $1 and locked_until <= $2`
+
+	result, err := m.conn().ExecContext(ctx, stmt, resetBefore, now)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+func (m *PostgresDBRepo) InsertAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `insert into audit_log (event, user_id, actor_id, email, ip, detail, created_at)
+			values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := m.conn().ExecContext(ctx, stmt,
+		event.Event,
+		nullableID(event.UserID),
+		nullableID(event.ActorID),
+		event.Email,
+		event.IP,
+		event.Detail,
+		event.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// nullableID stores a zero user id as NULL.
+func nullableID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// userWriteError turns a violation of the unique email index into repository.ErrDuplicateEmail.
+func userWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return repository.ErrDuplicateEmail.Wrap(err)
+	}
+	return err
+}
+
+// movieGenreWriteError turns a violation of the movies_genres foreign keys into
+// repository.ErrUnknownGenre or repository.ErrMovieNotFound.
+func movieGenreWriteError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation {
+		if pgErr.ConstraintName == "movies_genres_movie_id_fkey" {
+			return repository.ErrMovieNotFound.Wrap(err)
+		}
+		return repository.ErrUnknownGenre.Wrap(err)
+	}
+	return err
+}
+
+// notFound turns sql.ErrNoRows into the domain error e, which wraps it.
+func notFound(err error, e *repository.Error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return e.Wrap(err)
+	}
+	return err
+}
+
+func (m *PostgresDBRepo) AllGenres(ctx context.Context) ([]*models.Genre, error) {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	query := `select id, genre, created_at, updated_at from genres order by genre`
+
+	rows, err := m.conn().QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var genres []*models.Genre
+
+	for rows.Next() {
+		var g models.Genre
+		err := rows.Scan(
+			&g.ID,
+			&g.Genre,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &g)
+	}
+
+	return genres, nil
+}
+
+func (m *PostgresDBRepo) InsertMovie(ctx context.Context, movie models.Movie) (int, error){
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `insert into movies (title, description, release_date, runtime,
+			mpaa_rating, created_at, updated_at, image, created_by, updated_by)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+	
+	var newID int
+	err := m.conn().QueryRowContext(ctx, stmt, 
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate,
+		movie.RunTime,
+		movie.MPAARating,
+		movie.CreatedAt,
+		movie.UpdatedAt,
+		movie.Image,
+		nullableID(movie.CreatedBy),
+		nullableID(movie.UpdatedBy),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func (m *PostgresDBRepo) UpdateMovie(ctx context.Context, movie models.Movie) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `update movies set title = $1, description = $2, release_date = $3,
+				runtime = $4, mpaa_rating = $5,
+				updated_at = $6, image = $7, updated_by = $8 where id = $9`
+	result, err := m.conn().ExecContext(ctx, stmt, 
+		movie.Title,
+		movie.Description,
+		movie.ReleaseDate,
+		movie.RunTime,
+		movie.MPAARating,
+		movie.UpdatedAt,
+		movie.Image,
+		nullableID(movie.UpdatedBy),
+		movie.ID,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrMovieNotFound.Wrap(sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// UpdateMovieGenres replaces the genres of a movie. The delete and the inserts run in one
+// transaction, so a failed insert leaves the previous genres in place.
+func (m *PostgresDBRepo) UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error {
+	return m.inTx(ctx, func(tx *PostgresDBRepo) error {
+		ctx, cancel := tx.withTimeout(ctx)
+		defer cancel()
+
+		stmt := `delete from movies_genres where movie_id = $1`
+
+		_, err := tx.conn().ExecContext(ctx, stmt, id)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range genreIDs {
+			stmt := `insert into movies_genres (movie_id, genre_id) values ($1, $2)`
+			_, err := tx.conn().ExecContext(ctx, stmt, id, n)
+			if err != nil {
+				return movieGenreWriteError(err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *PostgresDBRepo) DeleteMovie(ctx context.Context, id int) error {
+	ctx, cancel := m.withTimeout(ctx)
+	defer cancel()
+
+	stmt := `delete from movies where id = $1`
+
+	result, err := m.conn().ExecContext(ctx, stmt, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrMovieNotFound.Wrap(sql.ErrNoRows)
 	}
 
 	return nil
@@ -0,0 +1,720 @@
+package dbrepo
+
+import (
+	"backend/internal/migrations"
+	"backend/internal/models"
+	"backend/internal/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// testDSNEnv names the variable with a Postgres connection string for the parity tests. The
+// tests drop and recreate the public schema of that database, so never point it at real data.
+// Without it only MemoryDBRepo is tested.
+const testDSNEnv = "TEST_DSN"
+
+// testGenres and testMovies are the data every repository under test starts with. The
+// titles differ in case, so their order tells a case-insensitive collation from a byte one.
+var testGenres = []string{"Drama", "action", "Comedy"}
+
+var testMovies = []struct {
+	title  string
+	genres []string
+}{
+	{"casablanca", []string{"Drama"}},
+	{"Alien", []string{"action", "Drama"}},
+	{"brazil", []string{"Comedy"}},
+}
+
+// testRepo is a repository seeded with testGenres and testMovies, with the ids they were given.
+type testRepo struct {
+	name     string
+	repo     repository.DatabaseRepo
+	genreIDs map[string]int
+	movieIDs map[string]int
+}
+
+// newTestRepos returns a seeded MemoryDBRepo, and a seeded PostgresDBRepo when TEST_DSN is set.
+func newTestRepos(t *testing.T) []testRepo {
+	t.Helper()
+
+	memory := NewMemoryDBRepo()
+	repos := []testRepo{seedTestRepo(t, "memory", memory, func(name string) int {
+		return memory.InsertGenre(models.Genre{Genre: name})
+	})}
+
+	if dsn := os.Getenv(testDSNEnv); dsn != "" {
+		db := openTestDB(t, dsn)
+		repos = append(repos, seedTestRepo(t, "postgres", &PostgresDBRepo{DB: db}, func(name string) int {
+			var id int
+			err := db.QueryRow(`insert into genres (genre, created_at, updated_at) values ($1, now(), now()) returning id`, name).Scan(&id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return id
+		}))
+	}
+
+	return repos
+}
+
+// openTestDB connects to dsn and gives it an empty schema at the latest migration.
+func openTestDB(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`drop schema public cascade; create schema public`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = migrator.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func seedTestRepo(t *testing.T, name string, repo repository.DatabaseRepo, insertGenre func(name string) int) testRepo {
+	t.Helper()
+	ctx := context.Background()
+
+	r := testRepo{name: name, repo: repo, genreIDs: map[string]int{}, movieIDs: map[string]int{}}
+
+	for _, g := range testGenres {
+		r.genreIDs[g] = insertGenre(g)
+	}
+
+	for _, m := range testMovies {
+		id, err := repo.InsertMovie(ctx, models.Movie{
+			Title:       m.title,
+			ReleaseDate: time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+			MPAARating:  "PG",
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.movieIDs[m.title] = id
+
+		var genreIDs []int
+		for _, g := range m.genres {
+			genreIDs = append(genreIDs, r.genreIDs[g])
+		}
+		err = repo.UpdateMovieGenres(ctx, id, genreIDs)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return r
+}
+
+// insertTestUser adds a user with the email address and returns its id.
+func insertTestUser(t *testing.T, repo repository.DatabaseRepo, email string) int {
+	t.Helper()
+
+	id, err := repo.InsertUser(context.Background(), models.User{
+		FirstName: "Test",
+		LastName:  "User",
+		Email:     email,
+		Password:  "$2a$10$abcdefghijklmnopqrstuv",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return id
+}
+
+func movieTitles(movies []*models.Movie) []string {
+	var titles []string
+	for _, m := range movies {
+		titles = append(titles, m.Title)
+	}
+	return titles
+}
+
+func TestAllMovies(t *testing.T) {
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			tests := []struct {
+				name  string
+				genre []int
+				want  []string
+			}{
+				{"all, by title ignoring case", nil, []string{"Alien", "brazil", "casablanca"}},
+				{"one genre", []int{r.genreIDs["Drama"]}, []string{"Alien", "casablanca"}},
+				{"genre of one movie", []int{r.genreIDs["Comedy"]}, []string{"brazil"}},
+				{"unknown genre", []int{9999}, nil},
+			}
+
+			for _, tt := range tests {
+				movies, err := r.repo.AllMovies(context.Background(), tt.genre...)
+				if err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+				if got := movieTitles(movies); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestListMovies(t *testing.T) {
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			tests := []struct {
+				name   string
+				filter models.MovieFilter
+				want   []string
+				// meta is the expected metadata, apart from the next cursor
+				meta models.PageMetadata
+				more bool
+			}{
+				{"first page by title", models.MovieFilter{PageSize: 2}, []string{"Alien", "brazil"},
+					models.PageMetadata{CurrentPage: 1, PageSize: 2, FirstPage: 1, LastPage: 2, TotalRecords: 3}, true},
+				{"last page", models.MovieFilter{Page: 2, PageSize: 2}, []string{"casablanca"},
+					models.PageMetadata{CurrentPage: 2, PageSize: 2, FirstPage: 1, LastPage: 2, TotalRecords: 3}, false},
+				{"past the last page", models.MovieFilter{Page: 5, PageSize: 2}, nil,
+					models.PageMetadata{CurrentPage: 5, PageSize: 2, FirstPage: 1, LastPage: 2, TotalRecords: 3}, false},
+				{"descending", models.MovieFilter{Sort: "-title"}, []string{"casablanca", "brazil", "Alien"},
+					models.PageMetadata{CurrentPage: 1, PageSize: models.DefaultPageSize, FirstPage: 1, LastPage: 1, TotalRecords: 3}, false},
+				{"one genre", models.MovieFilter{GenreIDs: []int{r.genreIDs["Drama"]}}, []string{"Alien", "casablanca"},
+					models.PageMetadata{CurrentPage: 1, PageSize: models.DefaultPageSize, FirstPage: 1, LastPage: 1, TotalRecords: 2}, false},
+				{"two genres of the same movie", models.MovieFilter{GenreIDs: []int{r.genreIDs["action"], r.genreIDs["Drama"]}}, []string{"Alien", "casablanca"},
+					models.PageMetadata{CurrentPage: 1, PageSize: models.DefaultPageSize, FirstPage: 1, LastPage: 1, TotalRecords: 2}, false},
+				{"genre paged", models.MovieFilter{PageSize: 1, GenreIDs: []int{r.genreIDs["Drama"], r.genreIDs["Comedy"]}}, []string{"Alien"},
+					models.PageMetadata{CurrentPage: 1, PageSize: 1, FirstPage: 1, LastPage: 3, TotalRecords: 3}, true},
+				{"unknown genre", models.MovieFilter{GenreIDs: []int{9999}}, nil,
+					models.PageMetadata{CurrentPage: 1, PageSize: models.DefaultPageSize, FirstPage: 1, LastPage: 1, TotalRecords: 0}, false},
+				{"rating", models.MovieFilter{MPAARatings: []string{"R"}}, nil,
+					models.PageMetadata{CurrentPage: 1, PageSize: models.DefaultPageSize, FirstPage: 1, LastPage: 1, TotalRecords: 0}, false},
+				{"release years", models.MovieFilter{ReleaseYearFrom: 1980, ReleaseYearTo: 1980, MPAARatings: []string{"PG", "R"}}, []string{"Alien", "brazil", "casablanca"},
+					models.PageMetadata{CurrentPage: 1, PageSize: models.DefaultPageSize, FirstPage: 1, LastPage: 1, TotalRecords: 3}, false},
+				{"later release years", models.MovieFilter{ReleaseYearFrom: 1981}, nil,
+					models.PageMetadata{CurrentPage: 1, PageSize: models.DefaultPageSize, FirstPage: 1, LastPage: 1, TotalRecords: 0}, false},
+			}
+
+			for _, tt := range tests {
+				filter := tt.filter
+				err := filter.Validate()
+				if err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+
+				movies, meta, err := r.repo.ListMovies(context.Background(), filter)
+				if err != nil {
+					t.Fatalf("%s: %v", tt.name, err)
+				}
+				if got := movieTitles(movies); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+				}
+
+				if more := meta.NextCursor != ""; more != tt.more {
+					t.Errorf("%s: next cursor %q, want one: %v", tt.name, meta.NextCursor, tt.more)
+				}
+				meta.NextCursor = ""
+				if meta != tt.meta {
+					t.Errorf("%s: got metadata %+v, want %+v", tt.name, meta, tt.meta)
+				}
+			}
+		})
+	}
+}
+
+func TestListMoviesKeyset(t *testing.T) {
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			// every test movie has the same release date and runtime, so only the ids order them
+			byID := []string{"casablanca", "Alien", "brazil"}
+			reversed := []string{"brazil", "Alien", "casablanca"}
+
+			tests := []struct {
+				sort     string
+				pageSize int
+				genreIDs []int
+				want     []string
+			}{
+				{"title", 1, nil, []string{"Alien", "brazil", "casablanca"}},
+				{"-title", 2, nil, []string{"casablanca", "brazil", "Alien"}},
+				{"release_date", 1, nil, byID},
+				{"-release_date", 1, nil, reversed},
+				{"runtime", 2, nil, byID},
+				{"-runtime", 1, nil, reversed},
+				{"release_date", 1, []int{r.genreIDs["Drama"]}, []string{"casablanca", "Alien"}},
+			}
+
+			for _, tt := range tests {
+				filter := models.MovieFilter{Sort: tt.sort, PageSize: tt.pageSize, GenreIDs: tt.genreIDs}
+				var got []string
+
+				// follow the cursors to the end, and no further than the number of movies
+				for pages := 0; pages <= len(testMovies); pages++ {
+					err := filter.Validate()
+					if err != nil {
+						t.Fatalf("%s: %v", tt.sort, err)
+					}
+
+					movies, meta, err := r.repo.ListMovies(context.Background(), filter)
+					if err != nil {
+						t.Fatalf("%s: %v", tt.sort, err)
+					}
+					got = append(got, movieTitles(movies)...)
+
+					if meta.TotalRecords != len(tt.want) || meta.PageSize != tt.pageSize {
+						t.Errorf("%s: got metadata %+v", tt.sort, meta)
+					}
+					if filter.Cursor != "" && meta.CurrentPage != 0 {
+						t.Errorf("%s: keyset page has page number %d", tt.sort, meta.CurrentPage)
+					}
+
+					if meta.NextCursor == "" {
+						break
+					}
+					filter.Cursor = meta.NextCursor
+				}
+
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s, %d per page: got %q, want %q", tt.sort, tt.pageSize, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOneMovie(t *testing.T) {
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			movie, err := r.repo.OneMovie(context.Background(), r.movieIDs["Alien"])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var genres []string
+			for _, g := range movie.Genres {
+				genres = append(genres, g.Genre)
+			}
+			if want := []string{"action", "Drama"}; !reflect.DeepEqual(genres, want) {
+				t.Errorf("genres: got %q, want %q", genres, want)
+			}
+		})
+	}
+}
+
+func TestOneMovieForEdit(t *testing.T) {
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			movie, allGenres, err := r.repo.OneMovieForEdit(context.Background(), r.movieIDs["Alien"])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wantIDs := []int{r.genreIDs["action"], r.genreIDs["Drama"]}
+			if !reflect.DeepEqual(movie.GenresArray, wantIDs) {
+				t.Errorf("GenresArray: got %v, want %v", movie.GenresArray, wantIDs)
+			}
+
+			checked := map[string]bool{}
+			var names []string
+			for _, g := range allGenres {
+				names = append(names, g.Genre)
+				checked[g.Genre] = g.Checked
+			}
+			if want := []string{"action", "Comedy", "Drama"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("all genres: got %q, want %q", names, want)
+			}
+			if want := map[string]bool{"action": true, "Comedy": false, "Drama": true}; !reflect.DeepEqual(checked, want) {
+				t.Errorf("checked: got %v, want %v", checked, want)
+			}
+		})
+	}
+}
+
+func TestNotFoundErrors(t *testing.T) {
+	ctx := context.Background()
+
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			userID := insertTestUser(t, r.repo, "someone@example.com")
+
+			tests := []struct {
+				name string
+				call func() error
+				want *repository.Error
+			}{
+				{"OneMovie", func() error { _, err := r.repo.OneMovie(ctx, 9999); return err }, repository.ErrMovieNotFound},
+				{"OneMovieForEdit", func() error { _, _, err := r.repo.OneMovieForEdit(ctx, 9999); return err }, repository.ErrMovieNotFound},
+				{"UpdateMovie", func() error { return r.repo.UpdateMovie(ctx, models.Movie{ID: 9999}) }, repository.ErrMovieNotFound},
+				{"DeleteMovie", func() error { return r.repo.DeleteMovie(ctx, 9999) }, repository.ErrMovieNotFound},
+				{"GetUserById", func() error { _, err := r.repo.GetUserById(ctx, 9999); return err }, repository.ErrUserNotFound},
+				{"GetUserByEmail", func() error { _, err := r.repo.GetUserByEmail(ctx, "nobody@example.com"); return err }, repository.ErrUserNotFound},
+				{"GetUserByOIDCIdentity", func() error { _, err := r.repo.GetUserByOIDCIdentity(ctx, "https://idp", "sub"); return err }, repository.ErrUserNotFound},
+				{"GetRefreshToken", func() error { _, err := r.repo.GetRefreshToken(ctx, "missing"); return err }, repository.ErrRefreshTokenNotFound},
+				{"UsePasswordReset", func() error { _, err := r.repo.UsePasswordReset(ctx, "missing"); return err }, repository.ErrPasswordResetNotFound},
+				{"GetAPIKeyByHash", func() error { _, err := r.repo.GetAPIKeyByHash(ctx, "missing"); return err }, repository.ErrAPIKeyNotFound},
+				{"DeleteAPIKey", func() error { return r.repo.DeleteAPIKey(ctx, userID, 9999) }, repository.ErrAPIKeyNotFound},
+				{"GetLoginThrottle", func() error { _, err := r.repo.GetLoginThrottle(ctx, "missing"); return err }, repository.ErrLoginThrottleNotFound},
+			}
+
+			for _, tt := range tests {
+				err := tt.call()
+				if !errors.Is(err, tt.want) {
+					t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+				}
+				if !errors.Is(err, sql.ErrNoRows) {
+					t.Errorf("%s: %v does not wrap sql.ErrNoRows", tt.name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	ctx := context.Background()
+
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			first := insertTestUser(t, r.repo, "taken@example.com")
+			second := insertTestUser(t, r.repo, "other@example.com")
+			alien := r.movieIDs["Alien"]
+
+			tests := []struct {
+				name string
+				call func() error
+				want *repository.Error
+			}{
+				{"InsertUser with a taken email in another case", func() error {
+					_, err := r.repo.InsertUser(ctx, models.User{Email: "TAKEN@example.com", Password: "x"})
+					return err
+				}, repository.ErrDuplicateEmail},
+				{"UpdateUser to a taken email", func() error {
+					return r.repo.UpdateUser(ctx, models.User{ID: second, Email: "taken@example.com"})
+				}, repository.ErrDuplicateEmail},
+				{"UpdateMovieGenres with an unknown genre", func() error {
+					return r.repo.UpdateMovieGenres(ctx, alien, []int{r.genreIDs["Comedy"], 9999})
+				}, repository.ErrUnknownGenre},
+				{"UpdateMovieGenres of a missing movie", func() error {
+					return r.repo.UpdateMovieGenres(ctx, 9999, []int{r.genreIDs["Comedy"]})
+				}, repository.ErrMovieNotFound},
+			}
+
+			for _, tt := range tests {
+				if err := tt.call(); !errors.Is(err, tt.want) {
+					t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+				}
+			}
+
+			// the failed writes left everything as it was
+			user, err := r.repo.GetUserById(ctx, first)
+			if err != nil || user.Email != "taken@example.com" {
+				t.Errorf("first user: got %v, %v", user, err)
+			}
+			movie, _, err := r.repo.OneMovieForEdit(ctx, alien)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []int{r.genreIDs["action"], r.genreIDs["Drama"]}; !reflect.DeepEqual(movie.GenresArray, want) {
+				t.Errorf("genres after a failed update: got %v, want %v", movie.GenresArray, want)
+			}
+		})
+	}
+}
+
+func TestWithTxRollsBack(t *testing.T) {
+	ctx := context.Background()
+	errAbort := errors.New("abort")
+
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			err := r.repo.WithTx(ctx, func(tx repository.DatabaseRepo) error {
+				_, err := tx.InsertMovie(ctx, models.Movie{Title: "Rolled back", CreatedAt: time.Now(), UpdatedAt: time.Now()})
+				if err != nil {
+					return err
+				}
+				err = tx.DeleteMovie(ctx, r.movieIDs["brazil"])
+				if err != nil {
+					return err
+				}
+				return errAbort
+			})
+			if !errors.Is(err, errAbort) {
+				t.Fatalf("got %v, want %v", err, errAbort)
+			}
+
+			movies, err := r.repo.AllMovies(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := movieTitles(movies), []string{"Alien", "brazil", "casablanca"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestMarkRefreshTokenUsed(t *testing.T) {
+	ctx := context.Background()
+
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			userID := insertTestUser(t, r.repo, "tokens@example.com")
+
+			err := r.repo.InsertRefreshToken(ctx, models.RefreshToken{
+				ID:        "token-1",
+				FamilyID:  "family-1",
+				UserID:    userID,
+				ExpiresAt: time.Now().Add(time.Hour),
+				CreatedAt: time.Now(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, want := range []bool{true, false} {
+				ok, err := r.repo.MarkRefreshTokenUsed(ctx, "token-1")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != want {
+					t.Errorf("call %d: got %v, want %v", i+1, ok, want)
+				}
+			}
+
+			err = r.repo.RevokeRefreshTokenFamily(ctx, "family-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := r.repo.GetRefreshToken(ctx, "token-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.RevokedAt == nil || token.UsedAt == nil {
+				t.Errorf("got used at %v, revoked at %v; want both set", token.UsedAt, token.RevokedAt)
+			}
+		})
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			userID := insertTestUser(t, r.repo, "keys@example.com")
+
+			for _, mfa := range []bool{false, true} {
+				_, key, err := models.NewAPIKey(userID, "script", []string{models.ScopeMoviesRead, models.ScopeMoviesWrite})
+				if err != nil {
+					t.Fatal(err)
+				}
+				key.MFA = mfa
+
+				_, err = r.repo.InsertAPIKey(ctx, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				stored, err := r.repo.GetAPIKeyByHash(ctx, key.Hash)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if stored.MFA != mfa || stored.UserID != userID || !stored.HasScope(models.ScopeMoviesWrite) {
+					t.Errorf("got %+v, want mfa %v and both scopes", stored, mfa)
+				}
+			}
+		})
+	}
+}
+
+func TestReserveLoginAttempt(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	policy := models.LoginThrottlePolicy{BaseDelay: time.Second, MaxAttempts: 3, Lockout: time.Hour}
+
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			const key = "account:guess@example.com"
+
+			reserve := func(at time.Time) (*models.LoginThrottle, bool) {
+				t.Helper()
+				throttle, ok, err := r.repo.ReserveLoginAttempt(ctx, key, at, at.Add(-24*time.Hour), policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return throttle, ok
+			}
+
+			// each attempt locks the key for the backoff, and a locked key counts nothing
+			at := now
+			for i, delay := range []time.Duration{time.Second, 2 * time.Second, time.Hour} {
+				throttle, ok := reserve(at)
+				if !ok || throttle.Failures != i+1 || !throttle.LockedUntil.Equal(at.Add(delay)) {
+					t.Fatalf("attempt %d: got %+v, %v, want locked for %s", i+1, throttle, ok, delay)
+				}
+
+				locked, ok := reserve(at.Add(delay - time.Millisecond))
+				if ok || locked.Failures != i+1 || !locked.LockedUntil.Equal(at.Add(delay)) {
+					t.Fatalf("attempt %d while locked: got %+v, %v", i+1, locked, ok)
+				}
+
+				at = at.Add(delay)
+			}
+
+			// a released attempt is not counted and its lock ends
+			throttle, ok := reserve(at)
+			if !ok || throttle.Failures != 4 {
+				t.Fatalf("after the lockout: got %+v, %v", throttle, ok)
+			}
+			err := r.repo.ReleaseLoginAttempt(ctx, key, throttle.LockedUntil, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := r.repo.GetLoginThrottle(ctx, key)
+			if err != nil || stored.Failures != 3 || !stored.LockedUntil.Equal(at) {
+				t.Fatalf("released: got %+v, %v", stored, err)
+			}
+
+			// a day later the count starts over
+			throttle, ok = reserve(at.Add(25 * time.Hour))
+			if !ok || throttle.Failures != 1 {
+				t.Errorf("after the reset: got %+v, %v", throttle, ok)
+			}
+		})
+	}
+}
+
+func TestReserveLoginAttemptConcurrently(t *testing.T) {
+	ctx := context.Background()
+	// no backoff, so only the lockout after the third attempt holds the others back
+	policy := models.LoginThrottlePolicy{MaxAttempts: 3, Lockout: time.Hour}
+
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			const attempts = 20
+			results := make(chan bool, attempts)
+			errs := make(chan error, attempts)
+
+			now := time.Now()
+			for i := 0; i < attempts; i++ {
+				go func() {
+					_, ok, err := r.repo.ReserveLoginAttempt(ctx, "ip:192.0.2.7", now, now.Add(-time.Hour), policy)
+					errs <- err
+					results <- ok
+				}()
+			}
+
+			reserved := 0
+			for i := 0; i < attempts; i++ {
+				if err := <-errs; err != nil {
+					t.Fatal(err)
+				}
+				if <-results {
+					reserved++
+				}
+			}
+			if reserved != policy.MaxAttempts {
+				t.Errorf("reserved %d attempts, want %d", reserved, policy.MaxAttempts)
+			}
+		})
+	}
+}
+
+func TestPruneLoginThrottles(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	resetBefore := now.Add(-time.Hour)
+
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			record := func(key string, at, lockedUntil time.Time) {
+				policy := models.LoginThrottlePolicy{MaxAttempts: 1, Lockout: lockedUntil.Sub(at)}
+				_, ok, err := r.repo.ReserveLoginAttempt(ctx, key, at, at.Add(-time.Hour), policy)
+				if err != nil || !ok {
+					t.Fatalf("reserving: %v, %v", ok, err)
+				}
+			}
+
+			record("account:stale@example.com", now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+			record("account:locked@example.com", now.Add(-2*time.Hour), now.Add(time.Hour))
+			record("account:recent@example.com", now.Add(-time.Minute), now.Add(-time.Minute))
+
+			n, err := r.repo.PruneLoginThrottles(ctx, resetBefore, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Errorf("pruned %d counters, want 1", n)
+			}
+
+			for key, kept := range map[string]bool{
+				"account:stale@example.com":  false,
+				"account:locked@example.com": true,
+				"account:recent@example.com": true,
+			} {
+				_, err := r.repo.GetLoginThrottle(ctx, key)
+				if found := err == nil; found != kept {
+					t.Errorf("%s: kept %v, want %v (error %v)", key, found, kept, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchMoviesEscapesHighlights(t *testing.T) {
+	ctx := context.Background()
+
+	for _, r := range newTestRepos(t) {
+		t.Run(r.name, func(t *testing.T) {
+			_, err := r.repo.InsertMovie(ctx, models.Movie{
+				Title:       "Rock & Roll <script>alert(1)</script>",
+				Description: "A rock band plays \x02<img src=x onerror=alert(1)>\x03 at the prom.",
+				CreatedAt:   time.Now(),
+				UpdatedAt:   time.Now(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			results, err := r.repo.SearchMovies(ctx, "rock", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+
+			for _, h := range []string{results[0].TitleHighlight, results[0].DescriptionHighlight} {
+				if !strings.Contains(h, "<b>") {
+					t.Errorf("%q highlights nothing", h)
+				}
+				untagged := strings.NewReplacer("<b>", "", "</b>", "").Replace(h)
+				if strings.ContainsAny(untagged, "<>\x02\x03") {
+					t.Errorf("%q is not escaped", h)
+				}
+			}
+		})
+	}
+}
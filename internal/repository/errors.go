@@ -0,0 +1,111 @@
+package repository
+
+import "errors"
+
+// Kind classifies domain errors by what the client did wrong.
+type Kind int
+
+const (
+	// KindNotFound means the thing the request is about does not exist.
+	KindNotFound Kind = iota + 1
+	// KindConflict means the request conflicts with the current state, such as a duplicate.
+	KindConflict
+	// KindValidation means the request carries invalid values.
+	KindValidation
+	// KindUnauthorized means the request is not authenticated.
+	KindUnauthorized
+	// KindForbidden means the authenticated user may not do this.
+	KindForbidden
+	// KindTooManyRequests means the client has to wait before trying again.
+	KindTooManyRequests
+)
+
+// Error is a domain error. Code is a stable identifier clients can rely on, such as
+// "movie_not_found", and Message is safe to show them. Err is the underlying cause, such as
+// sql.ErrNoRows or a Postgres error, which is for logs only.
+//
+// Errors compare equal with errors.Is when their kind and code match, so a sentinel such
+// as ErrMovieNotFound matches the errors made from it with Wrap.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Err     error
+}
+
+// ErrorOf returns the domain error in err's chain, or nil if there is none.
+func ErrorOf(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return nil
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Code == e.Code
+}
+
+// Wrap returns a copy of e caused by err.
+func (e *Error) Wrap(err error) *Error {
+	wrapped := *e
+	wrapped.Err = err
+	return &wrapped
+}
+
+// NotFound returns a KindNotFound error.
+func NotFound(code, message string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Message: message}
+}
+
+// Conflict returns a KindConflict error.
+func Conflict(code, message string) *Error {
+	return &Error{Kind: KindConflict, Code: code, Message: message}
+}
+
+// Validation returns a KindValidation error.
+func Validation(code, message string) *Error {
+	return &Error{Kind: KindValidation, Code: code, Message: message}
+}
+
+// Unauthorized returns a KindUnauthorized error.
+func Unauthorized(code, message string) *Error {
+	return &Error{Kind: KindUnauthorized, Code: code, Message: message}
+}
+
+// Forbidden returns a KindForbidden error.
+func Forbidden(code, message string) *Error {
+	return &Error{Kind: KindForbidden, Code: code, Message: message}
+}
+
+// TooManyRequests returns a KindTooManyRequests error.
+func TooManyRequests(code, message string) *Error {
+	return &Error{Kind: KindTooManyRequests, Code: code, Message: message}
+}
+
+// Errors returned by DatabaseRepo implementations. Those for missing rows wrap sql.ErrNoRows.
+var (
+	ErrMovieNotFound         = NotFound("movie_not_found", "movie not found")
+	ErrUserNotFound          = NotFound("user_not_found", "user not found")
+	ErrRefreshTokenNotFound  = NotFound("refresh_token_not_found", "refresh token not found")
+	ErrPasswordResetNotFound = NotFound("password_reset_not_found", "invalid or expired reset token")
+	ErrAPIKeyNotFound        = NotFound("api_key_not_found", "api key not found")
+	ErrLoginThrottleNotFound = NotFound("login_throttle_not_found", "no failed logins recorded")
+
+	// ErrDuplicateEmail is returned when a user write would give two users the same email address.
+	ErrDuplicateEmail = Conflict("email_in_use", "email address is already in use")
+	// ErrUnknownGenre is returned when a movie is given a genre that does not exist.
+	ErrUnknownGenre = Validation("unknown_genre", "unknown genre")
+)
@@ -2,20 +2,91 @@ package repository
 
 import (
 	"backend/internal/models"
+	"context"
 	"database/sql"
+	"time"
 )
 
+// DatabaseRepo is the data store of the application. Lookups of a single row that find
+// nothing return a KindNotFound *Error, such as ErrMovieNotFound, which wraps sql.ErrNoRows;
+// writes that break a constraint return a KindConflict or KindValidation one.
 type DatabaseRepo interface {
 	Connection() *sql.DB
-	AllMovies(genre ...int) ([]*models.Movie, error)
-	GetUserByEmail(email string) (*models.User, error)
-	GetUserById(id int) (*models.User, error)
+	AllMovies(ctx context.Context, genre ...int) ([]*models.Movie, error)
+	ListMovies(ctx context.Context, filter models.MovieFilter) ([]*models.Movie, models.PageMetadata, error)
+	SearchMovies(ctx context.Context, query string, limit int) ([]*models.MovieSearchResult, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserById(ctx context.Context, id int) (*models.User, error)
+	InsertUser(ctx context.Context, user models.User) (int, error)
+	UpdateUser(ctx context.Context, user models.User) error
+	UpdateUserPassword(ctx context.Context, id int, hash string) error
+	UpdateUserRole(ctx context.Context, id int, role string) error
+	DeleteUser(ctx context.Context, id int) error
+	// GetUserByOIDCIdentity returns the user linked to an account at an OIDC provider.
+	GetUserByOIDCIdentity(ctx context.Context, issuer, subject string) (*models.User, error)
+	LinkOIDCIdentity(ctx context.Context, issuer, subject string, userID int) error
+
+	// EnrollUserTOTP stores a new, not yet enabled TOTP secret and replaces the user's
+	// recovery codes.
+	EnrollUserTOTP(ctx context.Context, userID int, secret string, recoveryCodeHashes []string) error
+	EnableUserTOTP(ctx context.Context, userID int) error
+	// DisableUserTOTP removes the TOTP secret and recovery codes.
+	DisableUserTOTP(ctx context.Context, userID int) error
+	// UpdateUserTOTPStep records the time step of an accepted code. It returns false if a
+	// code of that step or a later one was already accepted.
+	UpdateUserTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	// UseRecoveryCode marks one of the user's unused recovery codes as used. It returns false
+	// if the user has no such unused code.
+	UseRecoveryCode(ctx context.Context, userID int, hash string) (bool, error)
+
+	InsertPasswordReset(ctx context.Context, reset models.PasswordReset) error
+	// UsePasswordReset marks an unused, unexpired reset token as used and returns the id of
+	// its user. It returns ErrPasswordResetNotFound if there is no such token.
+	UsePasswordReset(ctx context.Context, hash string) (int, error)
+	DeleteUserPasswordResets(ctx context.Context, userID int) error
+
+	InsertRefreshToken(ctx context.Context, token models.RefreshToken) error
+	GetRefreshToken(ctx context.Context, id string) (*models.RefreshToken, error)
+	// MarkRefreshTokenUsed records that a refresh token was exchanged. It returns false if the
+	// token had already been used, which means it is being replayed.
+	MarkRefreshTokenUsed(ctx context.Context, id string) (bool, error)
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeUserRefreshTokens(ctx context.Context, userID int) error
+
+	InsertAPIKey(ctx context.Context, key models.APIKey) (int, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (*models.APIKey, error)
+	UserAPIKeys(ctx context.Context, userID int) ([]*models.APIKey, error)
+	// DeleteAPIKey deletes one of the user's keys. It returns ErrAPIKeyNotFound if the user
+	// has no key with that id.
+	DeleteAPIKey(ctx context.Context, userID, id int) error
+	TouchAPIKey(ctx context.Context, id int, usedAt time.Time) error
+
+	GetLoginThrottle(ctx context.Context, key string) (*models.LoginThrottle, error)
+	// ReserveLoginAttempt counts a login attempt for key, unless the key is locked at at, and
+	// locks it for policy.Delay of the new count. The count starts over if the last attempt
+	// was before resetBefore. Checking the lock and counting is one atomic step, so parallel
+	// attempts cannot all get past the lock the first of them sets. It returns the counter
+	// after the attempt, or ok false and the counter as it is if the key was locked.
+	ReserveLoginAttempt(ctx context.Context, key string, at, resetBefore time.Time, policy models.LoginThrottlePolicy) (throttle *models.LoginThrottle, ok bool, err error)
+	// ReleaseLoginAttempt takes back an attempt ReserveLoginAttempt counted once it turned out
+	// not to be a failure. The lock it set ends at at, unless a later attempt replaced it.
+	ReleaseLoginAttempt(ctx context.Context, key string, lockedUntil, at time.Time) error
+	ClearLoginThrottle(ctx context.Context, key string) error
+	// PruneLoginThrottles deletes the counters whose last failure was before resetBefore and
+	// that are not locked at now, since they no longer hold anything back. It returns the
+	// number deleted.
+	PruneLoginThrottles(ctx context.Context, resetBefore, now time.Time) (int, error)
+	InsertAuditEvent(ctx context.Context, event models.AuditEvent) error
 	
-	OneMovieForEdit(id int) (*models.Movie, []*models.Genre, error)
-	OneMovie(id int) (*models.Movie, error)
-	AllGenres() ([]*models.Genre, error)
-	InsertMovie(movie models.Movie) (int, error)
-	UpdateMovieGenres(id int, genreIDs []int) error
-	UpdateMovie(movie models.Movie) error
-	DeleteMovie(id int) error
+	OneMovieForEdit(ctx context.Context, id int) (*models.Movie, []*models.Genre, error)
+	OneMovie(ctx context.Context, id int) (*models.Movie, error)
+	AllGenres(ctx context.Context) ([]*models.Genre, error)
+	InsertMovie(ctx context.Context, movie models.Movie) (int, error)
+	UpdateMovieGenres(ctx context.Context, id int, genreIDs []int) error
+	UpdateMovie(ctx context.Context, movie models.Movie) error
+	DeleteMovie(ctx context.Context, id int) error
+
+	// WithTx runs fn in a transaction. Every call made through the repo passed to fn is part
+	// of the transaction, which commits if fn returns nil and rolls back otherwise.
+	WithTx(ctx context.Context, fn func(repo DatabaseRepo) error) error
 }
\ No newline at end of file
@@ -0,0 +1,228 @@
+// Package tracing sets up OpenTelemetry tracing and makes the spans of the server: one for
+// every request, named after its chi route, one for every repository call and one for
+// every outgoing HTTP request, which also carries the trace on in its traceparent header.
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer of this package.
+const instrumentation = "backend/internal/tracing"
+
+// Config selects where spans go.
+type Config struct {
+	// Exporter is "otlp", "stdout" or "none".
+	Exporter string
+	// OTLPEndpoint is the collector URL, such as http://localhost:4318. When empty the
+	// standard OTEL_EXPORTER_OTLP_* variables apply.
+	OTLPEndpoint string
+	// SampleRatio is the share of new traces recorded; traces started by the caller follow
+	// the caller's sampling decision.
+	SampleRatio float64
+
+	ServiceName    string
+	ServiceVersion string
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator. Exporter
+// errors are logged to logger. The returned function flushes the spans not yet exported and
+// stops the exporter.
+//
+// With the "none" exporter no span is recorded, but the trace context of incoming requests is
+// still passed on to outgoing ones.
+func Setup(ctx context.Context, cfg Config, logger *slog.Logger) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("exporting traces", "error", err)
+	}))
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES win over the configured names
+	res, err := resource.New(ctx,
+		resource.WithSchemaURL(semconv.SchemaURL),
+		resource.WithAttributes(semconv.ServiceName(cfg.ServiceName), semconv.ServiceVersion(cfg.ServiceVersion)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for every request, continuing the trace of the caller if
+// the request has a traceparent header. The span is named after the chi route pattern, such
+// as "GET /movies/{id}", once the request has been routed, and fails on 5xx responses.
+func Middleware(next http.Handler) http.Handler {
+	tracer := otel.Tracer(instrumentation)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// DBHook returns a dbrepo.Hook that makes a child span for every repository call, named after
+// the repository method and recording the SQL operation it mostly runs. system is the
+// database, such as "postgresql". Rows not found are not errors.
+func DBHook(system string) func(ctx context.Context, method string) (context.Context, func(err error)) {
+	tracer := otel.Tracer(instrumentation)
+
+	return func(ctx context.Context, method string) (context.Context, func(err error)) {
+		ctx, span := tracer.Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				semconv.DBSystemKey.String(system),
+				semconv.DBOperationName(sqlOperation(method)),
+			),
+		)
+
+		return ctx, func(err error) {
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}
+	}
+}
+
+// sqlOperation returns the SQL statement a repository method runs, by the verb its name
+// starts with, or the method name itself when the verb says nothing about it.
+func sqlOperation(method string) string {
+	prefixes := []struct {
+		prefix    string
+		operation string
+	}{
+		{"Get", "SELECT"}, {"All", "SELECT"}, {"List", "SELECT"}, {"Search", "SELECT"},
+		{"One", "SELECT"}, {"User", "SELECT"},
+		{"Insert", "INSERT"}, {"Reserve", "INSERT"}, {"Link", "INSERT"}, {"Enroll", "INSERT"},
+		{"Update", "UPDATE"}, {"Mark", "UPDATE"}, {"Revoke", "UPDATE"}, {"Enable", "UPDATE"},
+		{"Disable", "UPDATE"}, {"Touch", "UPDATE"}, {"Release", "UPDATE"}, {"Use", "UPDATE"},
+		{"Delete", "DELETE"}, {"Clear", "DELETE"},
+		{"WithTx", "BEGIN"},
+	}
+
+	for _, p := range prefixes {
+		if strings.HasPrefix(method, p.prefix) {
+			return p.operation
+		}
+	}
+	return method
+}
+
+// Transport returns a RoundTripper that makes a client span for every request sent through
+// base, and sends the trace context along in the traceparent header. The query string,
+// which may hold API keys, is left out of the span.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base, tracer: otel.Tracer(instrumentation)}
+}
+
+type transport struct {
+	base   http.RoundTripper
+	tracer trace.Tracer
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := *req.URL
+	u.RawQuery = ""
+	u.User = nil
+
+	ctx, span := t.tracer.Start(req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Hostname()),
+			semconv.URLFull(u.String()),
+		),
+	)
+	defer span.End()
+
+	// a RoundTripper must not change the request it is given
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+
+	return resp, nil
+}
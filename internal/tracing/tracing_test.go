@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// recordSpans installs a global tracer provider that keeps every ended span in the returned
+// recorder. Middleware looks the tracer up when it is made, so build the router afterwards.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	return recorder
+}
+
+func testRouter() http.Handler {
+	mux := chi.NewRouter()
+	mux.Use(Middleware)
+
+	mux.Get("/movies/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Get("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	return mux
+}
+
+func TestMiddlewareNamesSpansAfterRoutes(t *testing.T) {
+	recorder := recordSpans(t)
+
+	rr := httptest.NewRecorder()
+	testRouter().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/movies/42", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %d", rr.Code)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+
+	if span.Name() != "GET /movies/{id}" {
+		t.Errorf("name: got %q", span.Name())
+	}
+	if span.Status().Code == codes.Error {
+		t.Errorf("a 200 response failed the span")
+	}
+
+	want := map[string]string{
+		string(semconv.HTTPRouteKey):              "/movies/{id}",
+		string(semconv.URLPathKey):                "/movies/42",
+		string(semconv.HTTPResponseStatusCodeKey): "200",
+	}
+	for _, attr := range span.Attributes() {
+		if value, ok := want[string(attr.Key)]; ok {
+			if got := attr.Value.Emit(); got != value {
+				t.Errorf("%s: got %q, want %q", attr.Key, got, value)
+			}
+			delete(want, string(attr.Key))
+		}
+	}
+	for key := range want {
+		t.Errorf("attribute %s is missing", key)
+	}
+}
+
+func TestMiddlewareFailsSpansOnServerErrors(t *testing.T) {
+	recorder := recordSpans(t)
+
+	testRouter().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/broken", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if spans[0].Status().Code != codes.Error {
+		t.Errorf("status: got %v, want an error", spans[0].Status())
+	}
+}
+
+func TestMiddlewareContinuesCallerTrace(t *testing.T) {
+	recorder := recordSpans(t)
+
+	const traceID, parentID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+
+	req := httptest.NewRequest(http.MethodGet, "/movies/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+parentID+"-01")
+	testRouter().ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if got := spans[0].SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("trace id: got %s, want %s", got, traceID)
+	}
+	if got := spans[0].Parent().SpanID().String(); got != parentID {
+		t.Errorf("parent: got %s, want %s", got, parentID)
+	}
+}
@@ -265,6 +265,13 @@ ALTER TABLE ONLY public.movies_genres
     ADD CONSTRAINT movies_genres_movie_id_fkey FOREIGN KEY (movie_id) REFERENCES public.movies(id) ON UPDATE CASCADE ON DELETE CASCADE;
 
 
+--
+-- Name: movies_search_idx; Type: INDEX; Schema: public; Owner: -
+--
+
+CREATE INDEX movies_search_idx ON public.movies USING gin ((setweight(to_tsvector('english', coalesce(title, '')), 'A') || setweight(to_tsvector('english', coalesce(description, '')), 'B')));
+
+
 --
 -- PostgreSQL database dump complete
 --